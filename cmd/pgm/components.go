@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/crgwilson/pgm/pkg/logger"
+	"github.com/crgwilson/pgm/pkg/migrate"
+)
+
+var errInvalidComponent = errors.New("Components must be given as name or name=directory, each only once")
+var errComponentCommand = errors.New("Only init, up, version and status can be run against several components, give a single --component for anything else")
+
+// component is a set of migrations with its own version line, see
+// migrate.NewComponentManager
+type component struct {
+	Name string
+	// Dir is where the component's migrations live, the -d directory if empty
+	Dir string
+}
+
+func (c component) dir(defaultDir string) string {
+	if c.Dir == "" {
+		return defaultDir
+	}
+
+	return c.Dir
+}
+
+// componentFlags collects every --component flag in the order given
+type componentFlags []component
+
+func (c *componentFlags) String() string {
+	names := make([]string, 0, len(*c))
+	for _, comp := range *c {
+		names = append(names, comp.Name)
+	}
+
+	return strings.Join(names, ",")
+}
+
+func (c *componentFlags) Set(value string) error {
+	name, dir, _ := strings.Cut(value, "=")
+	if name == "" {
+		return errInvalidComponent
+	}
+
+	for _, comp := range *c {
+		if comp.Name == name {
+			return errInvalidComponent
+		}
+	}
+
+	*c = append(*c, component{Name: name, Dir: dir})
+	return nil
+}
+
+// runComponents runs a command against every component, or only the one named
+// after the command, returning the exit code
+func runComponents(l logger.CliLogger, store migrate.MigrationStore, db migrate.DatabaseConnection, flags componentFlags, defaultDir string, configure func(m *migrate.MigrationManager), args []string) int {
+	components := make(migrate.Components)
+	for _, comp := range flags {
+		migrator := migrate.NewComponentManager(store, comp.Name, l.With(logger.F("component", comp.Name)))
+		configure(migrator)
+
+		sqlHooks, _ := loadMigrations(l, migrator, comp.dir(defaultDir))
+		migrator.AddHooks(sqlHooks.Hooks(db))
+
+		components[comp.Name] = migrator
+	}
+
+	if len(args) > 1 {
+		migrator, err := components.Get(args[1])
+		if err != nil {
+			l.Error(err.Error(), logger.F("component", args[1]))
+			return 24
+		}
+
+		components = migrate.Components{args[1]: migrator}
+	}
+
+	switch args[0] {
+	case "init":
+		err := components.InitDb()
+		if err != nil {
+			l.Error(err.Error())
+			return 6
+		}
+	case "up":
+		err := components.Up()
+		if err != nil {
+			l.Error(err.Error())
+			return 7
+		}
+	case "version":
+		for _, name := range components.Names() {
+			version, err := components[name].CurrentVersion()
+			if err != nil {
+				l.Error(err.Error(), logger.F("component", name))
+				return 9
+			}
+
+			l.Info(name+" "+version, logger.F("component", name), logger.Version(version))
+		}
+	case "status":
+		statuses, err := components.Status()
+		if err != nil {
+			l.Error(err.Error())
+			return 10
+		}
+
+		for _, name := range components.Names() {
+			l.Info("Component "+name, logger.F("component", name))
+			printStatus(l.With(logger.F("component", name)), statuses[name])
+		}
+	default:
+		l.Error(errComponentCommand.Error())
+		return 24
+	}
+
+	return 0
+}
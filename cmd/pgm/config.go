@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"strings"
+)
+
+var errInvalidVar = errors.New("Template variables must be given as key=value")
+
+// config is the file given with --config, e.g.
+//
+//	{"vars": {"schema": "tenant_1", "region": "eu"}}
+type config struct {
+	Vars map[string]string `json:"vars"`
+}
+
+func loadConfig(path string) (config, error) {
+	cfg := config{}
+	if path == "" {
+		return cfg, nil
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+
+	err = json.Unmarshal(contents, &cfg)
+	return cfg, err
+}
+
+// varFlags collects every --var key=value flag
+type varFlags map[string]string
+
+func (v varFlags) String() string {
+	pairs := make([]string, 0, len(v))
+	for key, value := range v {
+		pairs = append(pairs, key+"="+value)
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+func (v varFlags) Set(pair string) error {
+	key, value, ok := strings.Cut(pair, "=")
+	if !ok || key == "" {
+		return errInvalidVar
+	}
+
+	v[key] = value
+	return nil
+}
+
+// mergeVars combines template variables from each source, later sources
+// taking precedence over earlier ones
+func mergeVars(sources ...map[string]string) map[string]string {
+	vars := make(map[string]string)
+	for _, source := range sources {
+		for key, value := range source {
+			vars[key] = value
+		}
+	}
+
+	return vars
+}
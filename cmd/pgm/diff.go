@@ -0,0 +1,80 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/crgwilson/pgm/pkg/catalog"
+	"github.com/crgwilson/pgm/pkg/logger"
+	"github.com/crgwilson/pgm/pkg/migrate"
+	"github.com/crgwilson/pgm/pkg/pg"
+)
+
+var errDraftExists = errors.New("A migration already exists for the draft version")
+
+const draftHeader = "-- Draft generated by pgm diff from the differences between the database and\n-- the migrations. Review it carefully before committing.\n\n"
+
+// schemaDrift snapshots the schema the migrations produce, in a scratch
+// database, and the schema of the target database as it really is
+func schemaDrift(l logger.CliLogger, migrator *migrate.MigrationManager, sqlHooks migrate.SqlHooks, config pg.PostgresConfig, db *sql.DB) (expected, actual *catalog.Catalog, err error) {
+	err = withScratchMigrations(l, migrator, sqlHooks, config, migrator.HighestAvailableVersion(), func(scratchDb *sql.DB, scratch *migrate.MigrationManager) error {
+		var err error
+		expected, err = migrate.SnapshotSchema(scratchDb)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	actual, err = migrate.SnapshotSchema(db)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return expected, actual, nil
+}
+
+// printDrift logs every difference between the migrations and the database
+func printDrift(l logger.CliLogger, diffs []catalog.Difference) {
+	for _, d := range diffs {
+		var message string
+		switch d.Change {
+		case catalog.ChangeAdded:
+			message = d.Kind + " " + d.Name + " only exists in the database"
+		case catalog.ChangeRemoved:
+			message = d.Kind + " " + d.Name + " is missing from the database"
+		default:
+			message = d.Kind + " " + d.Name + " is " + d.After + " in the database but " + d.Before + " in the migrations"
+		}
+
+		l.Warn(message, logger.F("kind", d.Kind), logger.F("object", d.Name), logger.F("change", d.Change))
+	}
+}
+
+// writeDraft writes a migration which brings the migrations in line with the
+// database, refusing to overwrite an existing one
+func writeDraft(sqlDir, version string, expected, actual *catalog.Catalog) error {
+	files := map[string]string{
+		version + ".up.sql":   catalog.Draft(expected, actual),
+		version + ".down.sql": catalog.Draft(actual, expected),
+	}
+
+	for fileName := range files {
+		_, err := os.Stat(filepath.Join(sqlDir, fileName))
+		if err == nil {
+			return errDraftExists
+		}
+	}
+
+	for fileName, sql := range files {
+		err := ioutil.WriteFile(filepath.Join(sqlDir, fileName), []byte(draftHeader+sql), 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
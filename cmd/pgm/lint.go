@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/crgwilson/pgm/pkg/lint"
+)
+
+var errInvalidSeverity = errors.New("Lint severities must be given as rule=warning or rule=error")
+
+// severityFlags collects every --lint-severity rule=level flag
+type severityFlags map[string]lint.Severity
+
+func (s severityFlags) String() string {
+	pairs := make([]string, 0, len(s))
+	for rule, severity := range s {
+		pairs = append(pairs, rule+"="+string(severity))
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+func (s severityFlags) Set(pair string) error {
+	rule, level, ok := strings.Cut(pair, "=")
+	if !ok || rule == "" {
+		return errInvalidSeverity
+	}
+
+	severity, err := lint.ParseSeverity(level)
+	if err != nil {
+		return err
+	}
+
+	s[rule] = severity
+	return nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+
+	"github.com/crgwilson/pgm/pkg/logger"
+	"github.com/crgwilson/pgm/pkg/migrate"
+)
+
+// loadMigrations registers every migration, repeatable migration and hook file
+// found in sqlDir, exiting if any of them can't be read. It returns the hook
+// files along with the names of each version's files.
+func loadMigrations(l logger.CliLogger, migrator *migrate.MigrationManager, sqlDir string) (migrate.SqlHooks, map[string][]string) {
+	sqlHooks, versionFiles, err := migrator.LoadFS(os.DirFS(sqlDir))
+	if err == nil {
+		return sqlHooks, versionFiles
+	}
+
+	l.Error(err.Error())
+
+	var sourceErr *migrate.SourceError
+	var pathErr *fs.PathError
+	switch {
+	case errors.As(err, &sourceErr) && sourceErr.File == "":
+		exit(3)
+	case errors.As(err, &pathErr):
+		exit(4)
+	default:
+		exit(5)
+	}
+
+	return sqlHooks, versionFiles
+}
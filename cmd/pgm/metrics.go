@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/crgwilson/pgm/pkg/logger"
+	"github.com/crgwilson/pgm/pkg/metrics"
+	"github.com/crgwilson/pgm/pkg/migrate"
+)
+
+const metricsJob = "pgm"
+
+// exportMetrics returns hooks which push or write the registry's metrics once
+// each run has finished. Failing to export only warns, since the migration
+// itself has already happened.
+func exportMetrics(l logger.CliLogger, registry *metrics.Registry, pushgatewayUrl, textfile string) migrate.Hooks {
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	return migrate.Hooks{
+		AfterRun: func(run migrate.RunInfo) error {
+			if pushgatewayUrl != "" {
+				err := registry.Push(client, pushgatewayUrl, metricsJob)
+				if err != nil {
+					l.Warn("Unable to push metrics", logger.F("error", err))
+				}
+			}
+
+			if textfile != "" {
+				err := registry.WriteTextfile(textfile)
+				if err != nil {
+					l.Warn("Unable to write metrics", logger.F("error", err))
+				}
+			}
+
+			return nil
+		},
+	}
+}
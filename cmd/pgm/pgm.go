@@ -3,13 +3,21 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	"github.com/crgwilson/pgm/pkg/catalog"
+	"github.com/crgwilson/pgm/pkg/lint"
 	"github.com/crgwilson/pgm/pkg/logger"
+	"github.com/crgwilson/pgm/pkg/metrics"
 	"github.com/crgwilson/pgm/pkg/migrate"
 	"github.com/crgwilson/pgm/pkg/pg"
+	"github.com/crgwilson/pgm/pkg/tenant"
 )
 
 const usageText = `pgm: PostgreSQL schema migrator
@@ -19,21 +27,116 @@ Usage:
 
 Commands:
     init                   Perform all first time setup necessary for this tool to work
+    baseline <version>     Set up an existing database, treating everything up to <version> as applied
     up                     Run all available sql scripts until the highest available version is reached
     down                   Run all available sql scripts to completely revert all schema changes back to the first version
     version                Print the current schema version
+    status [--sql]         Print every known migration and whether it has been applied
+                           (--sql also prints the rendered SQL of everything up would run)
+    dump-schema [file]     Write the schema produced by all migrations to file (default schema.sql)
+    load-schema [file]     Set up an empty database from a file written by dump-schema (default schema.sql)
+    diff [--draft <v>]     Compare the database with the schema the migrations produce
+                           (--draft writes a migration for version <v> capturing the differences)
+    squash --through <v>   Combine every migration up to and including version <v> into one
+                           (--snapshot builds it from a schema snapshot, --archive <dir> keeps the originals)
+    lint                   Check every migration for operations which take long locks
+    test                   Apply, revert and reapply every migration in a scratch database, reporting anything down leaves behind
+    wait [--version <v>]   Wait until the database reaches version <v>, or with --latest the highest available version
+                           (exits 27 if --timeout runs out first, 28 for any other failure)
+    serve [--listen <a>]   Serve /healthz and /status over HTTP, and /migrate if PGM_SERVE_TOKEN is set
+
+Given more than one --component, init, up, version and status run against every
+component in turn, or only the one named after the command, e.g. pgm ... up billing.
+
+Given --tenants-file, --tenants-query or --tenants-like, init, up, down and version
+run against every tenant schema (or database, with --tenant-kind database) instead.
 
 `
 
+// atExit holds what has to be done before pgm exits, e.g. sending spans
+var atExit []func()
+
+// exit does everything registered in atExit, then exits with code
+func exit(code int) {
+	for _, f := range atExit {
+		f()
+	}
+
+	os.Exit(code)
+}
+
 func usage() {
 	fmt.Print(usageText)
 	flag.PrintDefaults()
-	os.Exit(1)
+	exit(1)
+}
+
+func printStatus(l logger.CliLogger, status migrate.Status) {
+	l.Info("Current version: "+status.CurrentVersion, logger.Version(status.CurrentVersion))
+	if status.Ahead {
+		ahead := migrate.DatabaseAheadError{CurrentVersion: status.CurrentVersion, LatestVersion: status.LatestVersion}
+		l.Warn(ahead.Error()+", it was probably migrated by a later release", logger.Version(status.CurrentVersion), logger.F("latest", status.LatestVersion))
+	}
+	if status.BaselineVersion != "" {
+		l.Info("Baseline version: "+status.BaselineVersion, logger.F("baseline", status.BaselineVersion))
+	}
+
+	for _, v := range status.Versions {
+		l.Info(fmt.Sprintf("%-32s %s", v.Version, v.State), logger.Version(v.Version), logger.F("state", v.State))
+	}
+
+	for _, r := range status.Repeatables {
+		l.Info(fmt.Sprintf("%-32s %s", r.Name, r.State), logger.F("name", r.Name), logger.F("state", r.State))
+	}
+}
+
+// printPlan prints each script which would run, as it would be run
+func printPlan(l logger.CliLogger, steps []migrate.PlannedStep) {
+	if len(steps) == 0 {
+		l.Info("Nothing to run")
+		return
+	}
+
+	for _, step := range steps {
+		name := step.Version + "." + step.Direction + ".sql"
+		if step.Repeatable {
+			name = step.Version + ".sql"
+		}
+
+		if l.Format == logger.JsonLogFormat {
+			l.Info("Would run "+name, logger.Version(step.Version), logger.Direction(step.Direction), logger.F("sql", step.Sql))
+			continue
+		}
+
+		fmt.Printf("-- %s\n%s\n", name, strings.TrimRight(step.Sql, "\n"))
+	}
+}
+
+// printFindings prints lint findings one per line like a compiler would, or
+// as log messages when logging JSON
+func printFindings(l logger.CliLogger, sqlDir string, findings []lint.Finding) {
+	for _, finding := range findings {
+		finding.File = filepath.Join(sqlDir, finding.File)
+
+		if l.Format != logger.JsonLogFormat {
+			fmt.Println(finding)
+			continue
+		}
+
+		fields := []logger.Field{logger.F("file", finding.File), logger.F("line", finding.Line), logger.F("rule", finding.Rule)}
+		if finding.Severity == lint.SeverityError {
+			l.Error(finding.Message, fields...)
+		} else {
+			l.Warn(finding.Message, fields...)
+		}
+	}
 }
 
 func main() {
 	// Init CLI flags
-	verbose := flag.Bool("v", false, "Log more verbosely")
+	logLevelName := flag.String("log-level", "info", "Minimum level of log messages to print (debug, info, warn, error)")
+	logFormatName := flag.String("log-format", "text", "Format of log messages (text, json)")
+	logTimestamps := flag.Bool("log-timestamps", false, "Prefix every log message with a timestamp")
 	sqlDir := flag.String("d", "./", "The directory containing SQL migration scripts")
 	dbHost := flag.String("H", "localhost", "Host address of the PostgreSQL database")
 	dbPort := flag.Int("p", 5432, "Host port of the PostgreSQL database")
@@ -41,18 +144,77 @@ func main() {
 	dbPassword := flag.String("P", "", "Login password for the PostgreSQL database")
 	dbName := flag.String("D", "postgres", "The name of the database to connect to")
 	dbSslMode := flag.String("s", "verify-full", "The 'sslmode' to set in the PostgreSQL connection URI")
+	allowOutOfOrder := flag.Bool("allow-out-of-order", false, "Apply migrations older than the current version which have never been applied")
+	tolerateAhead := flag.Bool("tolerate-ahead", false, "Have up succeed without doing anything when the database is newer than every known migration")
+	lintUp := flag.Bool("lint", false, "Refuse to run up if lint finds errors in the pending migrations")
+	lintSeverities := severityFlags{}
+	flag.Var(lintSeverities, "lint-severity", "Change a lint rule's severity as rule=warning or rule=error, may be repeated")
+	checkSchema := flag.Bool("check", false, "Have the dump-schema command fail if the existing file is out of date instead of writing it")
+	dryRun := flag.Bool("dry-run", false, "Have up and down print the SQL they would run instead of running it")
+	configPath := flag.String("config", "", "JSON config file supplying template variables")
+	cliVars := varFlags{}
+	flag.Var(cliVars, "var", "Set a template variable as key=value, may be repeated")
+	tenantKindName := flag.String("tenant-kind", "schema", "What each tenant is (schema, database)")
+	tenantsFile := flag.String("tenants-file", "", "Run against every tenant listed in this file, one per line")
+	tenantsQuery := flag.String("tenants-query", "", "Run against every tenant returned by this query")
+	tenantsLike := flag.String("tenants-like", "", "Run against every schema or database whose name matches this LIKE pattern")
+	parallel := flag.Int("parallel", 4, "The most tenants to migrate at once")
+	failFast := flag.Bool("fail-fast", false, "Stop starting new tenants as soon as one fails")
+	components := componentFlags{}
+	flag.Var(&components, "component", "Work on a component's version line, given as name or name=directory, may be repeated")
+	progress := flag.Bool("progress", false, "Show a live progress line while up or down run, when stdout is a terminal")
+	eventsPath := flag.String("events", "", "Write a JSON event for every run and step to this file, or - for stdout")
+	pushgatewayUrl := flag.String("metrics-pushgateway", "", "Push metrics to this Prometheus Pushgateway after up or down")
+	metricsTextfile := flag.String("metrics-textfile", "", "Write metrics to this file for the node exporter's textfile collector after up or down")
+	otlpEndpoint := flag.String("otlp-endpoint", "", "Send OpenTelemetry traces of up and down to this OTLP/HTTP collector URL")
+	scratchSchema := flag.Bool("scratch-schema", false, "Have the test command use a scratch schema in the target database instead of creating a scratch database")
 
 	flag.Usage = usage
 	flag.Parse()
 
 	// Init logger
-	var logLevel logger.LogLevel
-	if *verbose {
-		logLevel = logger.DebugLogLevel()
-	} else {
-		logLevel = logger.InfoLogLevel()
+	logLevel, err := logger.ParseLogLevel(*logLevelName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		usage()
 	}
+
+	logFormat, err := logger.ParseLogFormat(*logFormatName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		usage()
+	}
+
 	cliLogger := logger.NewCliLogger(logLevel)
+	cliLogger.Format = logFormat
+	cliLogger.Timestamps = *logTimestamps
+
+	// The progress line would only get in the way of anything reading stdout
+	var progressHooks migrate.Hooks
+	if *progress && logFormat == logger.TextLogFormat && isTerminal(os.Stdout) {
+		line := newProgressLine(os.Stdout)
+		cliLogger.Logger = line.Logger(cliLogger.Logger)
+		cliLogger.ErrLogger = line.Logger(cliLogger.ErrLogger)
+		progressHooks = line.Hooks()
+	}
+
+	lintRules, err := lint.WithSeverities(lint.DefaultRules(), lintSeverities)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		usage()
+	}
+
+	tenantKind, err := tenant.ParseKind(*tenantKindName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		usage()
+	}
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		cliLogger.Error(err.Error())
+		exit(21)
+	}
 
 	// Configure postgres connection
 	pgConfig := pg.PostgresConfig{
@@ -66,79 +228,357 @@ func main() {
 
 	db, err := pg.OpenDb(pgConfig)
 	if err != nil {
-		errorLog := fmt.Sprintf("%v", err)
-		cliLogger.Error(errorLog)
-		os.Exit(2)
+		cliLogger.Error(err.Error())
+		exit(2)
 	}
 
-	migrationStore := migrate.NewSchemaMigrationStore(db)
-	migrator := migrate.NewMigrationManager(migrationStore, cliLogger)
+	var events io.Writer
+	if *eventsPath != "" {
+		events, err = openEvents(*eventsPath)
+		if err != nil {
+			cliLogger.Error(err.Error())
+			exit(25)
+		}
+	}
 
-	// Register all provided sql files
-	files, err := ioutil.ReadDir(*sqlDir)
-	if err != nil {
-		errorLog := fmt.Sprintf("%v", err)
-		cliLogger.Error(errorLog)
-		os.Exit(3)
+	var registry *metrics.Registry
+	if *pushgatewayUrl != "" || *metricsTextfile != "" {
+		registry = metrics.NewRegistry()
 	}
 
-	for _, file := range files {
-		// If we find a non-sql file, we ignore it
-		if filepath.Ext(file.Name()) != ".sql" {
-			continue
+	var tracerProvider *sdktrace.TracerProvider
+	if *otlpEndpoint != "" {
+		tracerProvider, err = newTracerProvider(*otlpEndpoint)
+		if err != nil {
+			cliLogger.Error(err.Error())
+			exit(29)
 		}
 
-		sqlFileName := file.Name()
-		sqlFilePath := *sqlDir + "/" + sqlFileName
-		sqlFileContent, err := ioutil.ReadFile(sqlFilePath)
-		if err != nil {
-			errorLog := fmt.Sprintf("%v", err)
-			cliLogger.Error(errorLog)
-			os.Exit(4)
+		// exit skips deferred calls, so the provider is shut down either way
+		shutdown := func() { shutdownTracing(cliLogger, tracerProvider) }
+		atExit = append(atExit, shutdown)
+		defer shutdown()
+	}
+
+	templateVars := mergeVars(cfg.Vars, migrate.TemplateVarsFromEnv(os.Environ()), cliVars)
+	configure := func(m *migrate.MigrationManager) {
+		m.AllowOutOfOrder = *allowOutOfOrder
+		m.TolerateAhead = *tolerateAhead
+		m.TemplateVars = templateVars
+		if *lintUp {
+			m.LintRules = lintRules
+		}
+		if events != nil {
+			m.AddHooks(m.EventStream(events))
+		}
+		m.AddHooks(progressHooks)
+		if registry != nil {
+			m.AddHooks(registry.Hooks(m))
+			m.AddHooks(exportMetrics(cliLogger, registry, *pushgatewayUrl, *metricsTextfile))
 		}
+		if tracerProvider != nil {
+			for _, hooks := range traceSpans(cliLogger, tracerProvider, m) {
+				m.AddHooks(hooks)
+			}
+		}
+	}
+
+	migrationStore := migrate.NewSchemaMigrationStore(db)
+	if len(components) > 1 {
+		if flag.Arg(0) == "" {
+			usage()
+		}
+
+		exit(runComponents(cliLogger, migrationStore, db, components, *sqlDir, configure, flag.Args()))
+	}
+
+	migrator := migrate.NewMigrationManager(migrationStore, cliLogger)
+	if len(components) == 1 {
+		migrator = migrate.NewComponentManager(migrationStore, components[0].Name, cliLogger)
+		*sqlDir = components[0].dir(*sqlDir)
+	}
+	configure(migrator)
+
+	// Register all provided sql files
+	sqlHooks, versionFiles := loadMigrations(cliLogger, migrator, *sqlDir)
 
-		parsedSqlFile, err := migrate.ParseSqlFile(sqlFileName, sqlFileContent)
+	tenants := tenantOptions{
+		Kind:   tenantKind,
+		File:   *tenantsFile,
+		Query:  *tenantsQuery,
+		Like:   *tenantsLike,
+		Runner: tenant.Runner{Parallelism: *parallel, FailFast: *failFast},
+	}
+	if tenants.enabled() {
+		results, err := runTenants(cliLogger, migrator, configure, sqlHooks, pgConfig, db, tenants, flag.Arg(0))
 		if err != nil {
-			errorLog := fmt.Sprintf("%v", err)
-			cliLogger.Error(errorLog)
-			os.Exit(5)
+			cliLogger.Error(err.Error())
+			exit(22)
+		}
+
+		if !printTenantResults(cliLogger, results) {
+			exit(23)
 		}
-		migrator.RegisterMigrationPath(parsedSqlFile)
+		return
 	}
 
+	migrator.AddHooks(sqlHooks.Hooks(db))
+
 	// After all the flags we expect to find a subcommand of some sort
-	switch os.Args[len(os.Args)-1] {
+	switch flag.Arg(0) {
 	case "init":
 		err = migrator.InitDb()
 		if err != nil {
-			cliLogger.Error(fmt.Sprintf("%v", err))
-			os.Exit(6)
+			cliLogger.Error(err.Error())
+			exit(6)
 		}
 	case "up":
 		// Upgrade DB schema using the `up.sql` files we know about
 		highest := migrator.HighestAvailableVersion()
+		if *dryRun {
+			steps, err := migrator.PlanUp(highest)
+			if err != nil {
+				cliLogger.Error(err.Error())
+				exit(7)
+			}
+
+			printPlan(cliLogger, steps)
+			break
+		}
+
 		err := migrator.Up(highest)
 		if err != nil {
-			cliLogger.Error(fmt.Sprintf("%v", err))
-			os.Exit(7)
+			cliLogger.Error(err.Error())
+			exit(7)
+		}
+	case "baseline":
+		// Adopt an existing database by marking everything up to the given version as applied
+		version := flag.Arg(1)
+		if version == "" {
+			usage()
+		}
+
+		err = migrator.Baseline(version)
+		if err != nil {
+			cliLogger.Error(err.Error())
+			exit(11)
 		}
 	case "down":
 		// Downgrade DB schema using the `down.sql` files we know about
 		lowest := migrator.LowestAvailableVersion()
-		err := migrator.Down(lowest)
+
+		// We can't go any further back than the baseline, if there is one
+		baseline, err := migrator.BaselineVersion()
 		if err != nil {
-			cliLogger.Error(fmt.Sprintf("%v", err))
-			os.Exit(8)
+			cliLogger.Error(err.Error())
+			exit(8)
+		}
+
+		if baseline > lowest {
+			cliLogger.Warn("Database was baselined, stopping at version "+baseline, logger.Version(baseline))
+			lowest = baseline
+		}
+
+		if *dryRun {
+			steps, err := migrator.PlanDown(lowest)
+			if err != nil {
+				cliLogger.Error(err.Error())
+				exit(8)
+			}
+
+			printPlan(cliLogger, steps)
+			break
+		}
+
+		err = migrator.Down(lowest)
+		if err != nil {
+			cliLogger.Error(err.Error())
+			exit(8)
 		}
 	case "version":
 		// Get the current version of DB schema we have deployed
 		version, err := migrator.CurrentVersion()
 		if err != nil {
-			cliLogger.Error(fmt.Sprintf("%v", err))
-			os.Exit(9)
+			cliLogger.Error(err.Error())
+			exit(9)
+		}
+
+		cliLogger.Info(version, logger.Version(version))
+	case "status":
+		// Show which migrations have been applied and which are still pending
+		statusFlags := flag.NewFlagSet("status", flag.ExitOnError)
+		showSql := statusFlags.Bool("sql", false, "Print the rendered SQL of every script up would run")
+		statusFlags.Parse(flag.Args()[1:])
+
+		status, err := migrator.Status()
+		if err != nil {
+			cliLogger.Error(err.Error())
+			exit(10)
+		}
+
+		printStatus(cliLogger, status)
+
+		if *showSql {
+			steps, err := migrator.PlanUp(migrator.HighestAvailableVersion())
+			if err != nil {
+				cliLogger.Error(err.Error())
+				exit(10)
+			}
+
+			printPlan(cliLogger, steps)
+		}
+	case "dump-schema":
+		// Snapshot the schema all of the migrations add up to
+		path := flag.Arg(1)
+		if path == "" {
+			path = "schema.sql"
+		}
+
+		ddl, err := dumpSchema(cliLogger, migrator, sqlHooks, pgConfig)
+		if err != nil {
+			cliLogger.Error(err.Error())
+			exit(14)
+		}
+
+		if *checkSchema {
+			current, err := schemaIsCurrent(path, ddl)
+			if err != nil {
+				cliLogger.Error(err.Error())
+				exit(14)
+			}
+
+			if !current {
+				cliLogger.Error("Schema snapshot "+path+" is out of date, run pgm dump-schema to update it", logger.F("file", path))
+				exit(15)
+			}
+
+			cliLogger.Info("Schema snapshot "+path+" is up to date", logger.F("file", path))
+			break
 		}
 
-		cliLogger.Info(version)
+		err = ioutil.WriteFile(path, []byte(ddl), 0644)
+		if err != nil {
+			cliLogger.Error(err.Error())
+			exit(14)
+		}
+		cliLogger.Info("Wrote schema snapshot to "+path, logger.F("file", path))
+	case "load-schema":
+		// Set up a fresh database from a snapshot instead of replaying every migration
+		path := flag.Arg(1)
+		if path == "" {
+			path = "schema.sql"
+		}
+
+		snapshot, err := ioutil.ReadFile(path)
+		if err != nil {
+			cliLogger.Error(err.Error())
+			exit(16)
+		}
+
+		err = migrator.LoadSchema(snapshot)
+		if err != nil {
+			cliLogger.Error(err.Error())
+			exit(16)
+		}
+	case "diff":
+		// Find changes made to the database by hand
+		diffFlags := flag.NewFlagSet("diff", flag.ExitOnError)
+		draftVersion := diffFlags.String("draft", "", "Write a draft migration with this version capturing the differences")
+		diffFlags.Parse(flag.Args()[1:])
+
+		expected, actual, err := schemaDrift(cliLogger, migrator, sqlHooks, pgConfig, db)
+		if err != nil {
+			cliLogger.Error(err.Error())
+			exit(18)
+		}
+
+		diffs := catalog.Diff(expected, actual)
+		if len(diffs) == 0 {
+			cliLogger.Info("Database matches the migrations")
+			break
+		}
+		printDrift(cliLogger, diffs)
+
+		if *draftVersion != "" {
+			err = writeDraft(*sqlDir, *draftVersion, expected, actual)
+			if err != nil {
+				cliLogger.Error(err.Error())
+				exit(18)
+			}
+			cliLogger.Info("Wrote draft migration "+*draftVersion, logger.Version(*draftVersion))
+		}
+
+		exit(19)
+	case "squash":
+		// Replace the oldest migrations with a single one
+		squashFlags := flag.NewFlagSet("squash", flag.ExitOnError)
+		through := squashFlags.String("through", "", "The last version to squash")
+		fromSnapshot := squashFlags.Bool("snapshot", false, "Build the squashed up script from a schema snapshot instead of concatenating the originals")
+		archiveDir := squashFlags.String("archive", "", "Move the original files into this directory instead of deleting them")
+		squashFlags.Parse(flag.Args()[1:])
+
+		if *through == "" {
+			usage()
+		}
+
+		squashed, err := migrator.Squash(*through)
+		if err != nil {
+			cliLogger.Error(err.Error())
+			exit(17)
+		}
+
+		if *fromSnapshot {
+			squashed.Up, err = snapshotThrough(cliLogger, migrator, sqlHooks, pgConfig, *through)
+			if err != nil {
+				cliLogger.Error(err.Error())
+				exit(17)
+			}
+		}
+
+		err = replaceSquashedFiles(cliLogger, *sqlDir, *archiveDir, versionFiles, squashed)
+		if err != nil {
+			cliLogger.Error(err.Error())
+			exit(17)
+		}
+		cliLogger.Info(fmt.Sprintf("Squashed %d versions into %s", len(squashed.Squashes), squashed.Version), logger.Version(squashed.Version))
+	case "lint":
+		// Statically check the scripts for dangerous operations
+		findings := migrator.LintVersions(lintRules, nil)
+		printFindings(cliLogger, *sqlDir, findings)
+
+		if lint.HasErrors(findings) {
+			exit(20)
+		}
+	case "test":
+		// Check that every down script exactly reverses its up script
+		roundTrip := roundTripDatabase
+		if *scratchSchema {
+			roundTrip = roundTripSchema
+		}
+
+		results, err := roundTrip(cliLogger, migrator, pgConfig)
+		if err != nil {
+			cliLogger.Error(err.Error())
+			exit(12)
+		}
+
+		if !printRoundTrip(cliLogger, results) {
+			exit(13)
+		}
+	case "wait":
+		// Block until the database has been migrated far enough, e.g. in an init container
+		exit(runWait(cliLogger, migrator, flag.Args()[1:]))
+	case "serve":
+		// Answer readiness checks, and migrate on request if a token is set
+		serveFlags := flag.NewFlagSet("serve", flag.ExitOnError)
+		listen := serveFlags.String("listen", ":8080", "Address to listen on")
+		serveFlags.Parse(flag.Args()[1:])
+
+		err := serve(cliLogger, migrator, registry, *listen, os.Getenv(serveTokenEnv))
+		if err != nil {
+			cliLogger.Error(err.Error())
+			exit(26)
+		}
 	default:
 		// If we don't find a subcommand of some sort just print out the help info
 		usage()
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/crgwilson/pgm/pkg/logger"
+	"github.com/crgwilson/pgm/pkg/migrate"
+)
+
+const progressInterval = 500 * time.Millisecond
+
+const clearLine = "\r\033[K"
+
+// isTerminal reports whether f is a terminal rather than a file or pipe
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// openEvents opens the file the JSON event stream is written to, with "-"
+// meaning stdout. Tenants migrated in parallel share it, so writes are
+// serialized to keep each event on a line of its own.
+func openEvents(path string) (io.Writer, error) {
+	if path == "-" {
+		return &lockedWriter{w: os.Stdout}, nil
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &lockedWriter{w: f}, nil
+}
+
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.w.Write(p)
+}
+
+// progressLine keeps a line at the bottom of a terminal up to date with the
+// step being run, how far through the run it is and how long it has taken so
+// far. Loggers wrapped with Logger clear it before each line they write and
+// redraw it afterwards, so log lines never end up on the same line.
+type progressLine struct {
+	w io.Writer
+
+	mu      sync.Mutex
+	planned int
+	step    migrate.StepInfo
+	started time.Time
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+func newProgressLine(w io.Writer) *progressLine {
+	return &progressLine{w: w}
+}
+
+func (p *progressLine) Hooks() migrate.Hooks {
+	return migrate.Hooks{
+		BeforeRun: func(run migrate.RunInfo) error {
+			p.mu.Lock()
+			p.planned = run.Planned
+			p.mu.Unlock()
+
+			return nil
+		},
+		BeforeStep: func(step migrate.StepInfo) error {
+			p.start(step)
+			return nil
+		},
+		AfterStep: func(step migrate.StepInfo) error {
+			p.finish()
+			return nil
+		},
+		AfterRun: func(run migrate.RunInfo) error {
+			p.finish()
+			return nil
+		},
+	}
+}
+
+func (p *progressLine) start(step migrate.StepInfo) {
+	p.finish()
+
+	p.mu.Lock()
+	p.step = step
+	p.started = time.Now()
+	p.stop = make(chan struct{})
+	p.stopped = make(chan struct{})
+	stop, stopped := p.stop, p.stopped
+	p.mu.Unlock()
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				p.draw()
+			}
+		}
+	}()
+}
+
+// finish stops redrawing the line and clears it
+func (p *progressLine) finish() {
+	p.mu.Lock()
+	stop, stopped := p.stop, p.stopped
+	p.stop, p.stopped = nil, nil
+	p.mu.Unlock()
+
+	if stop == nil {
+		return
+	}
+
+	close(stop)
+	<-stopped
+	fmt.Fprint(p.w, clearLine)
+}
+
+func (p *progressLine) draw() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	fmt.Fprint(p.w, clearLine+p.text(time.Since(p.started)))
+}
+
+// Logger wraps a logger so that its lines are written above the progress line
+func (p *progressLine) Logger(base logger.BaseLogger) logger.BaseLogger {
+	return progressLogger{line: p, base: base}
+}
+
+type progressLogger struct {
+	line *progressLine
+	base logger.BaseLogger
+}
+
+func (l progressLogger) Println(v ...interface{}) {
+	l.line.mu.Lock()
+	defer l.line.mu.Unlock()
+
+	active := l.line.stop != nil
+	if active {
+		fmt.Fprint(l.line.w, clearLine)
+	}
+
+	l.base.Println(v...)
+
+	if active {
+		fmt.Fprint(l.line.w, l.line.text(time.Since(l.line.started)))
+	}
+}
+
+func (p *progressLine) text(elapsed time.Duration) string {
+	position := fmt.Sprintf("[%d]", p.step.Index)
+	if p.planned >= p.step.Index {
+		position = fmt.Sprintf("[%d/%d]", p.step.Index, p.planned)
+	}
+
+	return fmt.Sprintf("%s %s %s %s", position, p.step.Version, p.step.Direction, elapsed.Truncate(time.Second))
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"github.com/crgwilson/pgm/pkg/catalog"
+	"github.com/crgwilson/pgm/pkg/logger"
+	"github.com/crgwilson/pgm/pkg/migrate"
+	"github.com/crgwilson/pgm/pkg/pg"
+)
+
+// roundTripDatabase round trips every migration in a freshly created scratch
+// database on the same server, dropping it again afterwards
+func roundTripDatabase(l logger.CliLogger, migrator *migrate.MigrationManager, config pg.PostgresConfig) ([]migrate.RoundTripResult, error) {
+	scratchConfig, drop, err := pg.CreateScratchDatabase(config)
+	if err != nil {
+		return nil, err
+	}
+	l.Debug("Created scratch database "+scratchConfig.Database, logger.F("database", scratchConfig.Database))
+
+	defer func() {
+		err := drop()
+		if err != nil {
+			l.Warn("Unable to drop scratch database "+scratchConfig.Database+": "+err.Error(), logger.F("database", scratchConfig.Database))
+		}
+	}()
+
+	db, err := pg.OpenDb(scratchConfig)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	return migrator.RoundTrip(db, catalog.Options{})
+}
+
+// roundTripSchema round trips every migration inside a scratch schema of the
+// target database, for when creating databases is not allowed. Only scripts
+// which rely on search_path rather than naming a schema can be checked this way.
+func roundTripSchema(l logger.CliLogger, migrator *migrate.MigrationManager, config pg.PostgresConfig) ([]migrate.RoundTripResult, error) {
+	schema, err := pg.RandomName("pgm_scratch_")
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := pg.OpenDb(config)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	conn, err := pg.NewSingleConn(db)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	_, err = conn.Exec("CREATE SCHEMA " + schema)
+	if err != nil {
+		return nil, err
+	}
+	l.Debug("Created scratch schema "+schema, logger.F("schema", schema))
+
+	defer func() {
+		_, err := conn.Exec("DROP SCHEMA " + schema + " CASCADE")
+		if err != nil {
+			l.Warn("Unable to drop scratch schema "+schema+": "+err.Error(), logger.F("schema", schema))
+		}
+	}()
+
+	_, err = conn.Exec("SET search_path TO " + schema)
+	if err != nil {
+		return nil, err
+	}
+
+	return migrator.RoundTrip(conn, catalog.Options{Schemas: []string{schema}})
+}
+
+// printRoundTrip logs the outcome of every version, returning false if any of
+// them failed to round trip cleanly
+func printRoundTrip(l logger.CliLogger, results []migrate.RoundTripResult) bool {
+	ok := true
+	for _, result := range results {
+		version := logger.Version(result.Version)
+
+		if result.Ok() {
+			l.Info("Version "+result.Version+" round trips cleanly", version)
+			continue
+		}
+		ok = false
+
+		if result.Err != nil {
+			l.Error("Version "+result.Version+" failed: "+result.Err.Error(), version)
+		}
+
+		for _, d := range result.Residue {
+			l.Error("Version "+result.Version+" down left behind: "+d.String(), version, logger.F("kind", d.Kind), logger.F("object", d.Name), logger.F("change", d.Change))
+		}
+
+		for _, d := range result.Drift {
+			l.Error("Version "+result.Version+" up is not repeatable: "+d.String(), version, logger.F("kind", d.Kind), logger.F("object", d.Name), logger.F("change", d.Change))
+		}
+	}
+
+	return ok
+}
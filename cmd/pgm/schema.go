@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"io/ioutil"
+
+	"github.com/crgwilson/pgm/pkg/logger"
+	"github.com/crgwilson/pgm/pkg/migrate"
+	"github.com/crgwilson/pgm/pkg/pg"
+)
+
+// withScratchMigrations applies every migration up to and including target to
+// a scratch database on the same server, hands it to f and drops it again.
+// This gives the schema the migration scripts alone produce, regardless of any
+// drift in the target database. The SQL hooks run against the scratch database
+// as well, since whatever they create is part of that schema too.
+func withScratchMigrations(l logger.CliLogger, migrator *migrate.MigrationManager, sqlHooks migrate.SqlHooks, config pg.PostgresConfig, target string, f func(db *sql.DB, scratch *migrate.MigrationManager) error) error {
+	scratchConfig, drop, err := pg.CreateScratchDatabase(config)
+	if err != nil {
+		return err
+	}
+	l.Debug("Created scratch database "+scratchConfig.Database, logger.F("database", scratchConfig.Database))
+
+	defer func() {
+		err := drop()
+		if err != nil {
+			l.Warn("Unable to drop scratch database "+scratchConfig.Database+": "+err.Error(), logger.F("database", scratchConfig.Database))
+		}
+	}()
+
+	db, err := pg.OpenDb(scratchConfig)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	scratch := migrator.WithDatastore(migrate.NewSchemaMigrationStore(db))
+	scratch.AddHooks(sqlHooks.Hooks(db))
+	err = scratch.InitDb()
+	if err != nil {
+		return err
+	}
+
+	err = scratch.Up(target)
+	if err != nil {
+		return err
+	}
+
+	return f(db, scratch)
+}
+
+// dumpSchema renders the schema produced by applying every migration
+func dumpSchema(l logger.CliLogger, migrator *migrate.MigrationManager, sqlHooks migrate.SqlHooks, config pg.PostgresConfig) (string, error) {
+	var ddl string
+	err := withScratchMigrations(l, migrator, sqlHooks, config, migrator.HighestAvailableVersion(), func(db *sql.DB, scratch *migrate.MigrationManager) error {
+		var err error
+		ddl, err = scratch.DumpSchema(db)
+		return err
+	})
+
+	return ddl, err
+}
+
+// schemaIsCurrent reports whether the snapshot at path matches ddl
+func schemaIsCurrent(path, ddl string) (bool, error) {
+	existing, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+
+	return bytes.Equal(existing, []byte(ddl)), nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/crgwilson/pgm/pkg/logger"
+	"github.com/crgwilson/pgm/pkg/metrics"
+	"github.com/crgwilson/pgm/pkg/migrate"
+	"github.com/crgwilson/pgm/pkg/server"
+)
+
+// serveTokenEnv names the variable holding the /migrate token, which is kept
+// out of the command line so it doesn't show up in process listings
+const serveTokenEnv = "PGM_SERVE_TOKEN"
+
+const shutdownTimeout = 30 * time.Second
+
+// serve answers HTTP requests, including Prometheus scrapes at /metrics, until
+// pgm is interrupted or terminated
+func serve(l logger.CliLogger, migrator *migrate.MigrationManager, registry *metrics.Registry, addr, token string) error {
+	if registry == nil {
+		registry = metrics.NewRegistry()
+		migrator.AddHooks(registry.Hooks(migrator))
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", server.NewServer(migrator, token))
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		// Scrapes see the version as it is now, not as of the last run
+		err := registry.Observe(migrator)
+		if err != nil {
+			l.Warn("Unable to read migration metrics", logger.F("error", err))
+		}
+
+		registry.ServeHTTP(w, r)
+	})
+
+	httpServer := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- httpServer.ListenAndServe()
+	}()
+
+	l.Info("Serving migration status on "+addr, logger.F("address", addr), logger.F("migrate", token != ""))
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	// Let a triggered migration finish rather than cutting it off
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	err := httpServer.Shutdown(shutdownCtx)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/crgwilson/pgm/pkg/logger"
+	"github.com/crgwilson/pgm/pkg/migrate"
+	"github.com/crgwilson/pgm/pkg/pg"
+)
+
+// snapshotThrough renders the schema produced by applying every migration up
+// to and including through as DDL
+func snapshotThrough(l logger.CliLogger, migrator *migrate.MigrationManager, sqlHooks migrate.SqlHooks, config pg.PostgresConfig, through string) (string, error) {
+	var ddl string
+	err := withScratchMigrations(l, migrator, sqlHooks, config, through, func(db *sql.DB, scratch *migrate.MigrationManager) error {
+		snapshot, err := migrate.SnapshotSchema(db)
+		if err != nil {
+			return err
+		}
+
+		ddl = snapshot.DDL()
+		return nil
+	})
+
+	return ddl, err
+}
+
+// replaceSquashedFiles writes the squashed migration, then archives (or
+// deletes, if archiveDir is empty) the files of every squashed version. The
+// new files are written in full before anything is removed, so a failure
+// part way through never loses a migration.
+func replaceSquashedFiles(l logger.CliLogger, sqlDir, archiveDir string, versionFiles map[string][]string, squashed migrate.SquashedMigration) error {
+	if archiveDir != "" {
+		err := os.MkdirAll(archiveDir, 0755)
+		if err != nil {
+			return err
+		}
+	}
+
+	contents := map[string]string{
+		squashed.Version + ".up.sql":   squashed.UpFile(),
+		squashed.Version + ".down.sql": squashed.DownFile(),
+	}
+
+	staged := make(map[string]string)
+	defer func() {
+		for _, tmp := range staged {
+			os.Remove(tmp)
+		}
+	}()
+
+	for fileName, sql := range contents {
+		tmp, err := stageFile(sqlDir, fileName, sql)
+		if err != nil {
+			return err
+		}
+		staged[fileName] = tmp
+	}
+
+	// The squashed version's own files are about to be replaced, so archive a
+	// copy of them first
+	if archiveDir != "" {
+		for fileName := range contents {
+			err := copyFile(filepath.Join(sqlDir, fileName), filepath.Join(archiveDir, fileName))
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			l.Debug("Archived "+fileName, logger.F("file", fileName))
+		}
+	}
+
+	for fileName, tmp := range staged {
+		err := os.Rename(tmp, filepath.Join(sqlDir, fileName))
+		if err != nil {
+			return err
+		}
+		delete(staged, fileName)
+		l.Debug("Wrote "+fileName, logger.F("file", fileName))
+	}
+
+	for _, version := range squashed.Squashes {
+		for _, fileName := range versionFiles[version] {
+			if _, replaced := contents[fileName]; replaced {
+				continue
+			}
+
+			path := filepath.Join(sqlDir, fileName)
+
+			var err error
+			if archiveDir != "" {
+				err = os.Rename(path, filepath.Join(archiveDir, fileName))
+				l.Debug("Archived "+fileName, logger.F("file", fileName))
+			} else {
+				err = os.Remove(path)
+				l.Debug("Deleted "+fileName, logger.F("file", fileName))
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// stageFile writes contents to a temporary file next to where fileName will
+// go, returning its path. Temporary files don't end in .sql, so pgm never
+// mistakes one for a migration.
+func stageFile(dir, fileName, contents string) (string, error) {
+	tmp, err := os.CreateTemp(dir, "."+fileName+".*")
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tmp.WriteString(contents)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+
+	return tmp.Name(), nil
+}
+
+func copyFile(from, to string) error {
+	contents, err := ioutil.ReadFile(from)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(to, contents, 0644)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/crgwilson/pgm/pkg/logger"
+	"github.com/crgwilson/pgm/pkg/migrate"
+	"github.com/crgwilson/pgm/pkg/pg"
+	"github.com/crgwilson/pgm/pkg/tenant"
+)
+
+var errTenantCommand = errors.New("Only init, up, down and version can be run against tenants")
+
+// tenantOptions holds the flags controlling multi tenant runs
+type tenantOptions struct {
+	Kind   tenant.Kind
+	File   string
+	Query  string
+	Like   string
+	Runner tenant.Runner
+}
+
+func (o tenantOptions) enabled() bool {
+	return o.File != "" || o.Query != "" || o.Like != ""
+}
+
+// list finds the tenants from whichever source was given
+func (o tenantOptions) list(db *sql.DB) ([]string, error) {
+	switch {
+	case o.File != "":
+		return tenant.FromFile(o.File)
+	case o.Query != "":
+		return tenant.FromQuery(db, o.Query)
+	default:
+		return tenant.Like(db, o.Kind, o.Like)
+	}
+}
+
+// runTenants runs command against every tenant, each with a migration table
+// of its own: inside the tenant's schema, or in the tenant's database. Each
+// tenant's manager is set up with configure, as the original manager's hooks
+// are bound to it.
+func runTenants(l logger.CliLogger, migrator *migrate.MigrationManager, configure func(*migrate.MigrationManager), sqlHooks migrate.SqlHooks, config pg.PostgresConfig, db *sql.DB, opts tenantOptions, command string) ([]tenant.Result, error) {
+	switch command {
+	case "init", "up", "down", "version":
+	default:
+		return nil, errTenantCommand
+	}
+
+	tenants, err := opts.list(db)
+	if err != nil {
+		return nil, err
+	}
+	l.Info(fmt.Sprintf("Running %s against %d tenants", command, len(tenants)), logger.F("tenants", len(tenants)))
+
+	results := opts.Runner.Run(tenants, func(result *tenant.Result) error {
+		tenantLogger := l.With(logger.F("tenant", result.Tenant))
+
+		return withTenant(config, db, opts.Kind, result.Tenant, func(conn migrate.DatabaseConnection, store *migrate.SchemaMigrationStore) error {
+			tenantMigrator := migrator.WithDatastore(store)
+			tenantMigrator.Logger = tenantLogger
+			configure(tenantMigrator)
+			tenantMigrator.TemplateVars = mergeVars(migrator.TemplateVars, map[string]string{"tenant": result.Tenant})
+			tenantMigrator.AddHooks(sqlHooks.Hooks(conn))
+
+			// A tenant which hasn't been initialized yet has no version
+			result.FromVersion, _ = tenantMigrator.CurrentVersion()
+
+			err := runTenantCommand(tenantLogger, tenantMigrator, command)
+			if err != nil {
+				return err
+			}
+
+			result.ToVersion, err = tenantMigrator.CurrentVersion()
+			return err
+		})
+	})
+
+	return results, nil
+}
+
+// withTenant connects to a tenant, for schema tenants pinning a connection
+// with the schema first on its search_path so that unqualified names in
+// migrations resolve to it
+func withTenant(config pg.PostgresConfig, db *sql.DB, kind tenant.Kind, name string, f func(conn migrate.DatabaseConnection, store *migrate.SchemaMigrationStore) error) error {
+	if kind == tenant.KindDatabase {
+		tenantConfig := config
+		tenantConfig.Database = name
+
+		tenantDb, err := pg.OpenDb(tenantConfig)
+		if err != nil {
+			return err
+		}
+		defer tenantDb.Close()
+
+		return f(tenantDb, migrate.NewSchemaMigrationStore(tenantDb))
+	}
+
+	conn, err := pg.NewSingleConn(db)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Exec("SET search_path TO " + migrate.QuoteIdent(name))
+	if err != nil {
+		return err
+	}
+	// The connection goes back to the pool afterwards
+	defer conn.Exec("RESET search_path")
+
+	store := migrate.NewSchemaMigrationStore(conn)
+	store.TableName = migrate.QuoteIdent(name) + "." + store.TableName
+
+	return f(conn, store)
+}
+
+func runTenantCommand(l logger.CliLogger, migrator *migrate.MigrationManager, command string) error {
+	switch command {
+	case "init":
+		return migrator.InitDb()
+	case "up":
+		return migrator.Up(migrator.HighestAvailableVersion())
+	case "down":
+		lowest := migrator.LowestAvailableVersion()
+
+		baseline, err := migrator.BaselineVersion()
+		if err != nil {
+			return err
+		}
+
+		if baseline > lowest {
+			l.Warn("Database was baselined, stopping at version "+baseline, logger.Version(baseline))
+			lowest = baseline
+		}
+
+		return migrator.Down(lowest)
+	default:
+		return nil
+	}
+}
+
+// printTenantResults reports how every tenant fared, returning false if any
+// of them failed or was skipped
+func printTenantResults(l logger.CliLogger, results []tenant.Result) bool {
+	for _, result := range results {
+		fields := []logger.Field{logger.F("tenant", result.Tenant), logger.F("from", result.FromVersion), logger.F("to", result.ToVersion), logger.Duration(result.Duration)}
+
+		switch {
+		case result.Ok():
+			l.Info(fmt.Sprintf("%-32s %s -> %s", result.Tenant, result.FromVersion, result.ToVersion), fields...)
+		case result.Skipped():
+			l.Warn(fmt.Sprintf("%-32s skipped", result.Tenant), fields...)
+		default:
+			l.Error(fmt.Sprintf("%-32s failed: %s", result.Tenant, result.Err), append(fields, logger.F("error", result.Err))...)
+		}
+	}
+
+	succeeded, failed, skipped := tenant.Summary(results)
+	l.Info(fmt.Sprintf("%d tenants succeeded, %d failed, %d skipped", succeeded, failed, skipped), logger.F("succeeded", succeeded), logger.F("failed", failed), logger.F("skipped", skipped))
+
+	return failed == 0 && skipped == 0
+}
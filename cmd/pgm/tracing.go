@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	"github.com/crgwilson/pgm/pkg/logger"
+	"github.com/crgwilson/pgm/pkg/migrate"
+	"github.com/crgwilson/pgm/pkg/tracing"
+)
+
+const tracingService = "pgm"
+
+// newTracerProvider exports spans to an OTLP/HTTP collector at endpointUrl
+func newTracerProvider(endpointUrl string) (*sdktrace.TracerProvider, error) {
+	exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(endpointUrl))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", tracingService))),
+	)
+
+	return provider, nil
+}
+
+// traceSpans returns hooks which trace each run of the manager, then send the
+// spans on once the run has finished. As with metrics, failing to send them
+// only warns.
+func traceSpans(l logger.CliLogger, provider *sdktrace.TracerProvider, m *migrate.MigrationManager) []migrate.Hooks {
+	flush := migrate.Hooks{
+		AfterRun: func(run migrate.RunInfo) error {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			err := provider.ForceFlush(ctx)
+			if err != nil {
+				l.Warn("Unable to send traces", logger.F("error", err))
+			}
+
+			return nil
+		},
+	}
+
+	tracer := tracing.NewTracer(provider.Tracer(tracingService))
+	return []migrate.Hooks{m.TraceSpans(context.Background(), tracer), flush}
+}
+
+// shutdownTracing sends any spans still waiting and stops the provider,
+// giving up after a while so an unreachable collector can't keep pgm from
+// exiting
+func shutdownTracing(l logger.CliLogger, provider *sdktrace.TracerProvider) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := provider.Shutdown(ctx)
+	if err != nil {
+		l.Warn("Unable to send traces", logger.F("error", err))
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+
+	"github.com/crgwilson/pgm/pkg/logger"
+	"github.com/crgwilson/pgm/pkg/migrate"
+)
+
+var errWaitTarget = errors.New("Give wait either --version or --latest, not both")
+
+// Exit codes for wait, which scripts and init containers rely on to tell a
+// slow migration apart from a broken setup
+const (
+	exitWaitTimedOut = 27
+	exitWaitFailed   = 28
+)
+
+// runWait waits for the database to reach the version named by args,
+// returning the exit code: 0 once it has, exitWaitTimedOut if the timeout ran
+// out first and exitWaitFailed for anything else
+func runWait(l logger.CliLogger, migrator *migrate.MigrationManager, args []string) int {
+	waitFlags := flag.NewFlagSet("wait", flag.ExitOnError)
+	version := waitFlags.String("version", "", "Wait for this version or a later one")
+	latest := waitFlags.Bool("latest", false, "Wait for the highest available version (the default)")
+	timeout := waitFlags.Duration("timeout", 0, "Give up after this long, e.g. 5m (default waits forever)")
+	interval := waitFlags.Duration("interval", migrate.DefaultWaitInterval, "How often to check the database")
+	waitFlags.Parse(args)
+
+	if *version != "" && *latest {
+		l.Error(errWaitTarget.Error())
+		return exitWaitFailed
+	}
+
+	target := *version
+	if target == "" {
+		target = migrator.HighestAvailableVersion()
+	}
+
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	err := migrator.WaitForVersion(ctx, target, *interval)
+	if errors.Is(err, migrate.ErrWaitTimedOut) {
+		l.Error(err.Error(), logger.Version(target))
+		return exitWaitTimedOut
+	}
+	if err != nil {
+		l.Error(err.Error(), logger.Version(target))
+		return exitWaitFailed
+	}
+
+	return 0
+}
@@ -0,0 +1,507 @@
+// Package catalog takes structural snapshots of a PostgreSQL database by
+// querying pg_catalog, so that schemas can be compared without pg_dump.
+//
+// Every name held by a Catalog is already quoted with quote_ident, ready to be
+// used in SQL.
+package catalog
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrServerTooOld is returned by Snapshot for servers older than PostgreSQL 10,
+// which lack identity columns, pg_sequence and declarative partitioning
+var ErrServerTooOld = errors.New("Reading the catalog needs PostgreSQL 10 or newer")
+
+type Queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// Options narrow down what Snapshot looks at
+type Options struct {
+	// Schemas to include, all non-system schemas when empty
+	Schemas []string
+	// ExcludeTables are left out along with their columns, indexes,
+	// constraints and owned sequences, e.g. the migration table itself
+	ExcludeTables []string
+}
+
+type Column struct {
+	Name      string
+	Type      string
+	NotNull   bool
+	Default   string
+	Identity  string
+	Generated string
+}
+
+type Table struct {
+	Schema  string
+	Name    string
+	Columns []Column
+}
+
+type Index struct {
+	Schema     string
+	Table      string
+	Name       string
+	Definition string
+}
+
+type Constraint struct {
+	Schema     string
+	Table      string
+	Name       string
+	Type       string
+	Definition string
+}
+
+type Sequence struct {
+	Schema    string
+	Name      string
+	Type      string
+	Start     int64
+	Increment int64
+	Min       int64
+	Max       int64
+	Cycle     bool
+	OwnedBy   string
+}
+
+type View struct {
+	Schema       string
+	Name         string
+	Materialized bool
+	Definition   string
+	// DependsOn holds the schema qualified names of other views this one
+	// selects from
+	DependsOn []string
+}
+
+type Function struct {
+	Schema     string
+	Name       string
+	Arguments  string
+	Definition string
+}
+
+type Enum struct {
+	Schema string
+	Name   string
+	Labels []string
+}
+
+// Catalog is a snapshot of the user defined objects in a database, each kind
+// sorted by schema and name
+type Catalog struct {
+	Tables      []Table
+	Indexes     []Index
+	Constraints []Constraint
+	Sequences   []Sequence
+	Views       []View
+	Functions   []Function
+	Enums       []Enum
+}
+
+// Filters shared by every query below: skip system schemas and anything
+// installed by an extension
+const systemSchemaFilter = `n.nspname NOT IN ('pg_catalog', 'information_schema')
+	AND n.nspname NOT LIKE 'pg_toast%' AND n.nspname NOT LIKE 'pg_temp_%'`
+
+const extensionFilter = `NOT EXISTS (
+	SELECT 1 FROM pg_depend e WHERE e.classid = '%s'::regclass AND e.objid = %s AND e.deptype = 'e'
+)`
+
+func filters(catalogTable, oidColumn string) string {
+	return systemSchemaFilter + " AND " + fmt.Sprintf(extensionFilter, catalogTable, oidColumn)
+}
+
+const tablesQuery = `SELECT quote_ident(n.nspname), quote_ident(c.relname)
+	FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+	WHERE c.relkind IN ('r', 'p') AND NOT c.relispartition AND %s
+	ORDER BY n.nspname, c.relname`
+
+const columnsQuery = `SELECT quote_ident(n.nspname), quote_ident(c.relname), quote_ident(a.attname),
+		format_type(a.atttypid, a.atttypmod), a.attnotnull,
+		COALESCE(pg_get_expr(d.adbin, d.adrelid), ''), a.attidentity::text, a.attgenerated::text
+	FROM pg_attribute a
+	JOIN pg_class c ON c.oid = a.attrelid
+	JOIN pg_namespace n ON n.oid = c.relnamespace
+	LEFT JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+	WHERE c.relkind IN ('r', 'p') AND NOT c.relispartition AND a.attnum > 0 AND NOT a.attisdropped AND %s
+	ORDER BY n.nspname, c.relname, a.attnum`
+
+// Indexes backing primary key, unique and exclusion constraints are created by
+// the constraint itself, so they are left out
+const indexesQuery = `SELECT quote_ident(n.nspname), quote_ident(t.relname), quote_ident(c.relname), pg_get_indexdef(c.oid)
+	FROM pg_index x
+	JOIN pg_class c ON c.oid = x.indexrelid
+	JOIN pg_class t ON t.oid = x.indrelid
+	JOIN pg_namespace n ON n.oid = c.relnamespace
+	WHERE t.relkind IN ('r', 'p', 'm') AND NOT t.relispartition AND %s
+	AND NOT EXISTS (
+		SELECT 1 FROM pg_constraint k
+		WHERE k.conindid = x.indexrelid AND k.conrelid = x.indrelid AND k.contype IN ('p', 'u', 'x')
+	)
+	ORDER BY n.nspname, t.relname, c.relname`
+
+const constraintsQuery = `SELECT quote_ident(n.nspname), quote_ident(t.relname), quote_ident(k.conname),
+		k.contype::text, pg_get_constraintdef(k.oid, true)
+	FROM pg_constraint k
+	JOIN pg_class t ON t.oid = k.conrelid
+	JOIN pg_namespace n ON n.oid = t.relnamespace
+	WHERE k.contype IN ('p', 'u', 'f', 'c', 'x') AND t.relkind IN ('r', 'p') AND NOT t.relispartition AND %s
+	ORDER BY n.nspname, t.relname, k.conname`
+
+// Sequences belonging to identity columns are part of the column definition
+const sequencesQuery = `SELECT quote_ident(n.nspname), quote_ident(c.relname), format_type(s.seqtypid, NULL),
+		s.seqstart, s.seqincrement, s.seqmin, s.seqmax, s.seqcycle,
+		COALESCE(o.owner_schema, ''), COALESCE(o.owner_table, ''), COALESCE(o.owner_column, '')
+	FROM pg_sequence s
+	JOIN pg_class c ON c.oid = s.seqrelid
+	JOIN pg_namespace n ON n.oid = c.relnamespace
+	LEFT JOIN LATERAL (
+		SELECT quote_ident(tn.nspname) AS owner_schema, quote_ident(t.relname) AS owner_table,
+			quote_ident(a.attname) AS owner_column
+		FROM pg_depend d
+		JOIN pg_class t ON t.oid = d.refobjid
+		JOIN pg_namespace tn ON tn.oid = t.relnamespace
+		JOIN pg_attribute a ON a.attrelid = d.refobjid AND a.attnum = d.refobjsubid
+		WHERE d.classid = 'pg_class'::regclass AND d.objid = c.oid
+		AND d.refclassid = 'pg_class'::regclass AND d.deptype = 'a'
+		LIMIT 1
+	) o ON true
+	WHERE %s
+	AND NOT EXISTS (
+		SELECT 1 FROM pg_depend d WHERE d.classid = 'pg_class'::regclass AND d.objid = c.oid AND d.deptype = 'i'
+	)
+	ORDER BY n.nspname, c.relname`
+
+const viewsQuery = `SELECT quote_ident(n.nspname), quote_ident(c.relname), c.relkind = 'm', pg_get_viewdef(c.oid, true)
+	FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+	WHERE c.relkind IN ('v', 'm') AND %s
+	ORDER BY n.nspname, c.relname`
+
+const viewDependenciesQuery = `SELECT DISTINCT quote_ident(n.nspname), quote_ident(c.relname),
+		quote_ident(rn.nspname), quote_ident(r.relname)
+	FROM pg_rewrite w
+	JOIN pg_class c ON c.oid = w.ev_class
+	JOIN pg_namespace n ON n.oid = c.relnamespace
+	JOIN pg_depend d ON d.classid = 'pg_rewrite'::regclass AND d.objid = w.oid AND d.refclassid = 'pg_class'::regclass
+	JOIN pg_class r ON r.oid = d.refobjid
+	JOIN pg_namespace rn ON rn.oid = r.relnamespace
+	WHERE c.relkind IN ('v', 'm') AND r.relkind IN ('v', 'm') AND r.oid <> c.oid AND %s
+	ORDER BY 1, 2, 3, 4`
+
+const functionsQuery = `SELECT quote_ident(n.nspname), quote_ident(p.proname),
+		pg_get_function_identity_arguments(p.oid), pg_get_functiondef(p.oid)
+	FROM pg_proc p JOIN pg_namespace n ON n.oid = p.pronamespace
+	WHERE p.prokind IN ('f', 'p') AND %s
+	ORDER BY n.nspname, p.proname, 3`
+
+const enumsQuery = `SELECT quote_ident(n.nspname), quote_ident(t.typname), e.enumlabel
+	FROM pg_type t
+	JOIN pg_namespace n ON n.oid = t.typnamespace
+	JOIN pg_enum e ON e.enumtypid = t.oid
+	WHERE %s
+	ORDER BY n.nspname, t.typname, e.enumsortorder`
+
+// Catalog columns which are missing on older servers, along with what to read
+// in their place
+const (
+	// pg_attribute.attgenerated was added in PostgreSQL 12
+	generatedColumn       = "a.attgenerated::text"
+	generatedColumnBefore = "''"
+	// pg_proc.prokind replaced proisagg and proiswindow in PostgreSQL 11
+	functionKinds       = "p.prokind IN ('f', 'p')"
+	functionKindsBefore = "NOT p.proisagg AND NOT p.proiswindow"
+)
+
+// Snapshot reads every user defined table, column, index, constraint,
+// sequence, view, function and enum from the database. It needs PostgreSQL 10
+// or newer and returns ErrServerTooOld otherwise.
+func Snapshot(db Queryer, opts Options) (*Catalog, error) {
+	version, err := serverVersion(db)
+	if err != nil {
+		return nil, err
+	}
+
+	if version < 100000 {
+		return nil, ErrServerTooOld
+	}
+
+	s := snapshotter{
+		db:            db,
+		opts:          opts,
+		serverVersion: version,
+	}
+
+	c := Catalog{}
+
+	steps := []func(c *Catalog) error{
+		s.tables,
+		s.indexes,
+		s.constraints,
+		s.sequences,
+		s.views,
+		s.functions,
+		s.enums,
+	}
+	for _, step := range steps {
+		err = step(&c)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &c, nil
+}
+
+// serverVersion reads the server's version as a number, e.g. 120004 for 12.4
+func serverVersion(db Queryer) (int, error) {
+	rows, err := db.Query("SELECT current_setting('server_version_num')::int")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var version int
+	if rows.Next() {
+		err = rows.Scan(&version)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return version, rows.Err()
+}
+
+type snapshotter struct {
+	db            Queryer
+	opts          Options
+	serverVersion int
+}
+
+// columnsQuery returns the query for table columns the server understands
+func (s snapshotter) columnsQuery() string {
+	if s.serverVersion < 120000 {
+		return strings.Replace(columnsQuery, generatedColumn, generatedColumnBefore, 1)
+	}
+
+	return columnsQuery
+}
+
+// functionsQuery returns the query for functions the server understands
+func (s snapshotter) functionsQuery() string {
+	if s.serverVersion < 110000 {
+		return strings.Replace(functionsQuery, functionKinds, functionKindsBefore, 1)
+	}
+
+	return functionsQuery
+}
+
+// include reports whether an object in the given schema, optionally belonging
+// to the given table, is covered by the snapshot options
+func (s snapshotter) include(schema, table string) bool {
+	if len(s.opts.Schemas) > 0 && !containsIdent(s.opts.Schemas, schema) {
+		return false
+	}
+
+	return table == "" || !containsIdent(s.opts.ExcludeTables, table)
+}
+
+func containsIdent(names []string, ident string) bool {
+	unquoted := strings.Trim(ident, `"`)
+	for _, name := range names {
+		if name == ident || name == unquoted {
+			return true
+		}
+	}
+
+	return false
+}
+
+// query runs one of the catalog queries and hands each row to scan
+func (s snapshotter) query(query, catalogTable, oidColumn string, scan func(rows *sql.Rows) error) error {
+	rows, err := s.db.Query(fmt.Sprintf(query, filters(catalogTable, oidColumn)))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		err = scan(rows)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func (s snapshotter) tables(c *Catalog) error {
+	err := s.query(tablesQuery, "pg_class", "c.oid", func(rows *sql.Rows) error {
+		var t Table
+		err := rows.Scan(&t.Schema, &t.Name)
+		if err != nil {
+			return err
+		}
+
+		if s.include(t.Schema, t.Name) {
+			c.Tables = append(c.Tables, t)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	tableIndex := make(map[string]int)
+	for i, t := range c.Tables {
+		tableIndex[t.Schema+"."+t.Name] = i
+	}
+
+	return s.query(s.columnsQuery(), "pg_class", "c.oid", func(rows *sql.Rows) error {
+		var schema, table string
+		var col Column
+		err := rows.Scan(&schema, &table, &col.Name, &col.Type, &col.NotNull, &col.Default, &col.Identity, &col.Generated)
+		if err != nil {
+			return err
+		}
+
+		i, ok := tableIndex[schema+"."+table]
+		if ok {
+			c.Tables[i].Columns = append(c.Tables[i].Columns, col)
+		}
+		return nil
+	})
+}
+
+func (s snapshotter) indexes(c *Catalog) error {
+	return s.query(indexesQuery, "pg_class", "c.oid", func(rows *sql.Rows) error {
+		var i Index
+		err := rows.Scan(&i.Schema, &i.Table, &i.Name, &i.Definition)
+		if err != nil {
+			return err
+		}
+
+		if s.include(i.Schema, i.Table) {
+			c.Indexes = append(c.Indexes, i)
+		}
+		return nil
+	})
+}
+
+func (s snapshotter) constraints(c *Catalog) error {
+	return s.query(constraintsQuery, "pg_constraint", "k.oid", func(rows *sql.Rows) error {
+		var k Constraint
+		err := rows.Scan(&k.Schema, &k.Table, &k.Name, &k.Type, &k.Definition)
+		if err != nil {
+			return err
+		}
+
+		if s.include(k.Schema, k.Table) {
+			c.Constraints = append(c.Constraints, k)
+		}
+		return nil
+	})
+}
+
+func (s snapshotter) sequences(c *Catalog) error {
+	return s.query(sequencesQuery, "pg_class", "c.oid", func(rows *sql.Rows) error {
+		var seq Sequence
+		var ownerSchema, ownerTable, ownerColumn string
+		err := rows.Scan(&seq.Schema, &seq.Name, &seq.Type, &seq.Start, &seq.Increment, &seq.Min, &seq.Max, &seq.Cycle, &ownerSchema, &ownerTable, &ownerColumn)
+		if err != nil {
+			return err
+		}
+
+		if ownerTable != "" {
+			seq.OwnedBy = ownerSchema + "." + ownerTable + "." + ownerColumn
+		}
+
+		// Sequences owned by an excluded table go with it
+		if s.include(seq.Schema, ownerTable) {
+			c.Sequences = append(c.Sequences, seq)
+		}
+		return nil
+	})
+}
+
+func (s snapshotter) views(c *Catalog) error {
+	err := s.query(viewsQuery, "pg_class", "c.oid", func(rows *sql.Rows) error {
+		var v View
+		err := rows.Scan(&v.Schema, &v.Name, &v.Materialized, &v.Definition)
+		if err != nil {
+			return err
+		}
+
+		if s.include(v.Schema, "") {
+			c.Views = append(c.Views, v)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	viewIndex := make(map[string]int)
+	for i, v := range c.Views {
+		viewIndex[qualify(v.Schema, v.Name)] = i
+	}
+
+	return s.query(viewDependenciesQuery, "pg_class", "c.oid", func(rows *sql.Rows) error {
+		var schema, name, depSchema, depName string
+		err := rows.Scan(&schema, &name, &depSchema, &depName)
+		if err != nil {
+			return err
+		}
+
+		i, ok := viewIndex[qualify(schema, name)]
+		if ok {
+			c.Views[i].DependsOn = append(c.Views[i].DependsOn, qualify(depSchema, depName))
+		}
+		return nil
+	})
+}
+
+func (s snapshotter) functions(c *Catalog) error {
+	return s.query(s.functionsQuery(), "pg_proc", "p.oid", func(rows *sql.Rows) error {
+		var f Function
+		err := rows.Scan(&f.Schema, &f.Name, &f.Arguments, &f.Definition)
+		if err != nil {
+			return err
+		}
+
+		if s.include(f.Schema, "") {
+			c.Functions = append(c.Functions, f)
+		}
+		return nil
+	})
+}
+
+func (s snapshotter) enums(c *Catalog) error {
+	return s.query(enumsQuery, "pg_type", "t.oid", func(rows *sql.Rows) error {
+		var schema, name, label string
+		err := rows.Scan(&schema, &name, &label)
+		if err != nil {
+			return err
+		}
+
+		if !s.include(schema, "") {
+			return nil
+		}
+
+		last := len(c.Enums) - 1
+		if last < 0 || c.Enums[last].Schema != schema || c.Enums[last].Name != name {
+			c.Enums = append(c.Enums, Enum{Schema: schema, Name: name})
+			last++
+		}
+		c.Enums[last].Labels = append(c.Enums[last].Labels, label)
+
+		return nil
+	})
+}
@@ -0,0 +1,157 @@
+package catalog_test
+
+import (
+	"testing"
+
+	"github.com/crgwilson/pgm/pkg/catalog"
+	"github.com/crgwilson/pgm/pkg/pgtest"
+)
+
+func TestMain(m *testing.M) {
+	pgtest.Main(m)
+}
+
+const testSchema = `
+CREATE TYPE mood AS ENUM ('happy', 'sad');
+CREATE TABLE account (
+	id SERIAL PRIMARY KEY,
+	name TEXT NOT NULL DEFAULT 'anonymous',
+	feeling mood
+);
+CREATE INDEX account_name ON account(name);
+CREATE VIEW happy_account AS SELECT id, name FROM account WHERE feeling = 'happy';
+CREATE FUNCTION account_count() RETURNS BIGINT LANGUAGE sql AS 'SELECT COUNT(*) FROM account';
+CREATE TABLE pgm_schema_migration (id SERIAL PRIMARY KEY);
+`
+
+func TestSnapshot(t *testing.T) {
+	db, _ := pgtest.NewDatabase(t)
+
+	_, err := db.Exec(testSchema)
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	snapshot, err := catalog.Snapshot(db, catalog.Options{ExcludeTables: []string{"pgm_schema_migration"}})
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	counts := []struct {
+		Kind     string
+		Got      int
+		Expected int
+	}{
+		{"tables", len(snapshot.Tables), 1},
+		{"indexes", len(snapshot.Indexes), 1},
+		{"constraints", len(snapshot.Constraints), 1},
+		{"sequences", len(snapshot.Sequences), 1},
+		{"views", len(snapshot.Views), 1},
+		{"functions", len(snapshot.Functions), 1},
+		{"enums", len(snapshot.Enums), 1},
+	}
+
+	for _, count := range counts {
+		if count.Got != count.Expected {
+			t.Errorf("got %d %s, want %d", count.Got, count.Kind, count.Expected)
+		}
+	}
+
+	if len(snapshot.Tables) == 1 && len(snapshot.Tables[0].Columns) != 3 {
+		t.Errorf("got %d columns, want 3", len(snapshot.Tables[0].Columns))
+	}
+
+	again, err := catalog.Snapshot(db, catalog.Options{ExcludeTables: []string{"pgm_schema_migration"}})
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	diffs := catalog.Diff(snapshot, again)
+	if len(diffs) != 0 {
+		t.Errorf("got %v, want no differences", diffs)
+	}
+}
+
+func TestDDLRecreatesSchema(t *testing.T) {
+	source, _ := pgtest.NewDatabase(t)
+	target, _ := pgtest.NewDatabase(t)
+
+	_, err := source.Exec(testSchema + "CREATE VIEW happy_names AS SELECT name FROM happy_account;")
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	snapshot, err := catalog.Snapshot(source, catalog.Options{})
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	_, err = target.Exec(snapshot.DDL())
+	if err != nil {
+		t.Fatalf("got %v, want no error loading:\n%s", err, snapshot.DDL())
+	}
+
+	recreated, err := catalog.Snapshot(target, catalog.Options{})
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	diffs := catalog.Diff(snapshot, recreated)
+	if len(diffs) != 0 {
+		t.Errorf("got %v, want no differences", diffs)
+	}
+
+	if recreated.DDL() != snapshot.DDL() {
+		t.Errorf("got different DDL after recreating the schema")
+	}
+}
+
+func TestDraftAppliesChanges(t *testing.T) {
+	db, _ := pgtest.NewDatabase(t)
+
+	_, err := db.Exec(testSchema)
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	from, err := catalog.Snapshot(db, catalog.Options{})
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	_, err = db.Exec(`DROP INDEX account_name;
+		ALTER TABLE account ADD COLUMN email TEXT;
+		ALTER TABLE account ALTER COLUMN name DROP DEFAULT;
+		ALTER TYPE mood ADD VALUE 'ok';
+		CREATE TABLE audit (id INT PRIMARY KEY, account INT REFERENCES account(id));`)
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	to, err := catalog.Snapshot(db, catalog.Options{})
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	target, _ := pgtest.NewDatabase(t)
+	_, err = target.Exec(from.DDL())
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	draft := catalog.Draft(from, to)
+	_, err = target.Exec(draft)
+	if err != nil {
+		t.Fatalf("got %v, want no error running:\n%s", err, draft)
+	}
+
+	got, err := catalog.Snapshot(target, catalog.Options{})
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	diffs := catalog.Diff(to, got)
+	if len(diffs) != 0 {
+		t.Errorf("got %v, want no differences after running:\n%s", diffs, draft)
+	}
+}
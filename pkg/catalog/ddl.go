@@ -0,0 +1,196 @@
+package catalog
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DDL renders the catalog as a SQL script which recreates it in an empty
+// database. The output only depends on the contents of the catalog, so two
+// databases with the same schema always render identically.
+//
+// Objects are created in dependency order: schemas, enums, functions,
+// sequences, tables, sequence ownership, constraints (foreign keys last),
+// indexes and finally views, each view after the views it selects from.
+// Function bodies are not validated while loading, as with pg_dump, since they
+// may refer to tables which are created further down. The setting is reset at
+// the end so it doesn't outlive the script on a pooled connection.
+func (c *Catalog) DDL() string {
+	var b strings.Builder
+
+	b.WriteString("SET check_function_bodies = false;\n")
+
+	for _, schema := range c.schemas() {
+		fmt.Fprintf(&b, "\nCREATE SCHEMA IF NOT EXISTS %s;\n", schema)
+	}
+
+	for _, e := range c.Enums {
+		fmt.Fprintf(&b, "\n%s\n", e.create())
+	}
+
+	for _, f := range c.Functions {
+		fmt.Fprintf(&b, "\n%s\n", f.create())
+	}
+
+	for _, s := range c.Sequences {
+		fmt.Fprintf(&b, "\n%s\n", s.create())
+	}
+
+	for _, t := range c.Tables {
+		fmt.Fprintf(&b, "\n%s\n", t.create())
+	}
+
+	for _, s := range c.Sequences {
+		if s.OwnedBy != "" {
+			fmt.Fprintf(&b, "\n%s\n", s.own())
+		}
+	}
+
+	// Foreign keys need the unique constraints they reference to exist first
+	for _, foreign := range []bool{false, true} {
+		for _, k := range c.Constraints {
+			if k.foreign() == foreign {
+				fmt.Fprintf(&b, "\n%s\n", k.add())
+			}
+		}
+	}
+
+	for _, i := range c.Indexes {
+		fmt.Fprintf(&b, "\n%s\n", i.create())
+	}
+
+	for _, v := range c.sortedViews() {
+		fmt.Fprintf(&b, "\n%s\n", v.create())
+	}
+
+	b.WriteString("\nRESET check_function_bodies;\n")
+
+	return b.String()
+}
+
+func (e Enum) create() string {
+	return fmt.Sprintf("CREATE TYPE %s AS %s;", qualify(e.Schema, e.Name), e.definition())
+}
+
+func (f Function) create() string {
+	return strings.TrimSpace(f.Definition) + ";"
+}
+
+func (s Sequence) create() string {
+	return fmt.Sprintf("CREATE SEQUENCE %s %s;", qualify(s.Schema, s.Name), s.options())
+}
+
+func (s Sequence) options() string {
+	options := fmt.Sprintf("AS %s START WITH %d INCREMENT BY %d MINVALUE %d MAXVALUE %d", s.Type, s.Start, s.Increment, s.Min, s.Max)
+	if s.Cycle {
+		options += " CYCLE"
+	}
+
+	return options
+}
+
+func (s Sequence) own() string {
+	return fmt.Sprintf("ALTER SEQUENCE %s OWNED BY %s;", qualify(s.Schema, s.Name), s.OwnedBy)
+}
+
+func (t Table) create() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "CREATE TABLE %s (", qualify(t.Schema, t.Name))
+	for i, col := range t.Columns {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		fmt.Fprintf(&b, "\n    %s %s", col.Name, col.definition())
+	}
+	b.WriteString("\n);")
+
+	return b.String()
+}
+
+func (k Constraint) foreign() bool {
+	return k.Type == "f"
+}
+
+func (k Constraint) add() string {
+	return fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s %s;", qualify(k.Schema, k.Table), k.Name, k.Definition)
+}
+
+func (i Index) create() string {
+	return i.Definition + ";"
+}
+
+func (v View) kind() string {
+	if v.Materialized {
+		return "MATERIALIZED VIEW"
+	}
+
+	return "VIEW"
+}
+
+func (v View) create() string {
+	definition := strings.TrimSuffix(strings.TrimSpace(v.Definition), ";")
+	return fmt.Sprintf("CREATE %s %s AS\n%s;", v.kind(), qualify(v.Schema, v.Name), definition)
+}
+
+// schemas lists every schema other than public which holds an object
+func (c *Catalog) schemas() []string {
+	found := make(map[string]bool)
+	for _, t := range c.Tables {
+		found[t.Schema] = true
+	}
+	for _, s := range c.Sequences {
+		found[s.Schema] = true
+	}
+	for _, v := range c.Views {
+		found[v.Schema] = true
+	}
+	for _, f := range c.Functions {
+		found[f.Schema] = true
+	}
+	for _, e := range c.Enums {
+		found[e.Schema] = true
+	}
+	delete(found, "public")
+
+	schemas := make([]string, 0, len(found))
+	for schema := range found {
+		schemas = append(schemas, schema)
+	}
+	sort.Strings(schemas)
+
+	return schemas
+}
+
+// sortedViews orders views so that each comes after every view it depends on,
+// otherwise keeping them sorted by schema and name
+func (c *Catalog) sortedViews() []View {
+	views := make(map[string]View)
+	for _, v := range c.Views {
+		views[qualify(v.Schema, v.Name)] = v
+	}
+
+	sorted := make([]View, 0, len(c.Views))
+	visited := make(map[string]bool)
+
+	var visit func(name string)
+	visit = func(name string) {
+		v, ok := views[name]
+		if !ok || visited[name] {
+			return
+		}
+		visited[name] = true
+
+		for _, dep := range v.DependsOn {
+			visit(dep)
+		}
+		sorted = append(sorted, v)
+	}
+
+	for _, v := range c.Views {
+		visit(qualify(v.Schema, v.Name))
+	}
+
+	return sorted
+}
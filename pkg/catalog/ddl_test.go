@@ -0,0 +1,69 @@
+package catalog
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDDL(t *testing.T) {
+	c := testCatalog()
+	c.Sequences = []Sequence{
+		{Schema: "public", Name: "account_id_seq", Type: "integer", Start: 1, Increment: 1, Min: 1, Max: 2147483647, OwnedBy: "public.account.id"},
+	}
+	c.Constraints = append(c.Constraints, Constraint{Schema: "audit", Table: "entry", Name: "entry_account_fkey", Type: "f", Definition: "FOREIGN KEY (account) REFERENCES public.account(id)"})
+	c.Views = []View{{Schema: "audit", Name: "everything", Definition: " SELECT 1;"}}
+
+	want := `SET check_function_bodies = false;
+
+CREATE SCHEMA IF NOT EXISTS audit;
+
+CREATE TYPE public.mood AS ENUM ('happy', 'sad');
+
+CREATE SEQUENCE public.account_id_seq AS integer START WITH 1 INCREMENT BY 1 MINVALUE 1 MAXVALUE 2147483647;
+
+CREATE TABLE public.account (
+    id integer GENERATED BY DEFAULT AS IDENTITY NOT NULL,
+    name text
+);
+
+ALTER SEQUENCE public.account_id_seq OWNED BY public.account.id;
+
+ALTER TABLE public.account ADD CONSTRAINT account_pkey PRIMARY KEY (id);
+
+ALTER TABLE audit.entry ADD CONSTRAINT entry_account_fkey FOREIGN KEY (account) REFERENCES public.account(id);
+
+CREATE INDEX account_name ON public.account USING btree (name);
+
+CREATE VIEW audit.everything AS
+SELECT 1;
+
+RESET check_function_bodies;
+`
+
+	got := c.DDL()
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestDDLViewOrder(t *testing.T) {
+	c := &Catalog{
+		Views: []View{
+			{Schema: "public", Name: "a", Definition: "SELECT * FROM public.c", DependsOn: []string{"public.c"}},
+			{Schema: "public", Name: "b", Definition: "SELECT 1"},
+			{Schema: "public", Name: "c", Definition: "SELECT * FROM public.b", DependsOn: []string{"public.b"}},
+		},
+	}
+
+	ddl := c.DDL()
+	positions := make([]int, 0)
+	for _, name := range []string{"public.b", "public.c", "public.a"} {
+		positions = append(positions, strings.Index(ddl, "CREATE VIEW "+name+" "))
+	}
+
+	for i := 1; i < len(positions); i++ {
+		if positions[i-1] < 0 || positions[i] < positions[i-1] {
+			t.Fatalf("got views out of dependency order:\n%s", ddl)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package catalog
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	KindEnum       = "enum"
+	KindSequence   = "sequence"
+	KindTable      = "table"
+	KindColumn     = "column"
+	KindConstraint = "constraint"
+	KindIndex      = "index"
+	KindView       = "view"
+	KindFunction   = "function"
+)
+
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeChanged = "changed"
+)
+
+// Object is a single catalog entry flattened for comparison. Definition holds
+// everything about the object which matters when comparing two snapshots.
+type Object struct {
+	Kind       string
+	Name       string
+	Definition string
+}
+
+func (o Object) key() string {
+	return o.Kind + " " + o.Name
+}
+
+func qualify(parts ...string) string {
+	return strings.Join(parts, ".")
+}
+
+func (c Column) definition() string {
+	def := c.Type
+	if c.Generated == "s" {
+		def += " GENERATED ALWAYS AS (" + c.Default + ") STORED"
+	} else if c.Default != "" {
+		def += " DEFAULT " + c.Default
+	}
+
+	switch c.Identity {
+	case "a":
+		def += " GENERATED ALWAYS AS IDENTITY"
+	case "d":
+		def += " GENERATED BY DEFAULT AS IDENTITY"
+	}
+
+	if c.NotNull {
+		def += " NOT NULL"
+	}
+
+	return def
+}
+
+func (e Enum) definition() string {
+	quoted := make([]string, 0, len(e.Labels))
+	for _, label := range e.Labels {
+		quoted = append(quoted, quoteLiteral(label))
+	}
+
+	return "ENUM (" + strings.Join(quoted, ", ") + ")"
+}
+
+func (s Sequence) definition() string {
+	def := s.options()
+	if s.OwnedBy != "" {
+		def += " OWNED BY " + s.OwnedBy
+	}
+
+	return def
+}
+
+// Objects flattens the catalog into a sorted list of comparable objects
+func (c *Catalog) Objects() []Object {
+	objects := make([]Object, 0)
+
+	for _, e := range c.Enums {
+		objects = append(objects, Object{KindEnum, qualify(e.Schema, e.Name), e.definition()})
+	}
+
+	for _, s := range c.Sequences {
+		objects = append(objects, Object{KindSequence, qualify(s.Schema, s.Name), s.definition()})
+	}
+
+	for _, t := range c.Tables {
+		objects = append(objects, Object{KindTable, qualify(t.Schema, t.Name), ""})
+		for _, col := range t.Columns {
+			objects = append(objects, Object{KindColumn, qualify(t.Schema, t.Name, col.Name), col.definition()})
+		}
+	}
+
+	for _, k := range c.Constraints {
+		objects = append(objects, Object{KindConstraint, qualify(k.Schema, k.Table, k.Name), k.Definition})
+	}
+
+	for _, i := range c.Indexes {
+		objects = append(objects, Object{KindIndex, qualify(i.Schema, i.Name), i.Definition})
+	}
+
+	for _, v := range c.Views {
+		def := v.Definition
+		if v.Materialized {
+			def = "MATERIALIZED " + def
+		}
+		objects = append(objects, Object{KindView, qualify(v.Schema, v.Name), def})
+	}
+
+	for _, f := range c.Functions {
+		objects = append(objects, Object{KindFunction, qualify(f.Schema, f.Name) + "(" + f.Arguments + ")", f.Definition})
+	}
+
+	sort.SliceStable(objects, func(i, j int) bool {
+		return objects[i].key() < objects[j].key()
+	})
+
+	return objects
+}
+
+// Difference is a single object which differs between two snapshots
+type Difference struct {
+	Kind   string
+	Name   string
+	Change string
+	Before string
+	After  string
+}
+
+func (d Difference) String() string {
+	switch d.Change {
+	case ChangeAdded:
+		return fmt.Sprintf("%s %s added", d.Kind, d.Name)
+	case ChangeRemoved:
+		return fmt.Sprintf("%s %s removed", d.Kind, d.Name)
+	default:
+		return fmt.Sprintf("%s %s changed from %s to %s", d.Kind, d.Name, strconv.Quote(d.Before), strconv.Quote(d.After))
+	}
+}
+
+// Diff lists every object which was added, removed or changed going from the
+// before snapshot to the after snapshot, sorted by kind and name
+func Diff(before, after *Catalog) []Difference {
+	beforeObjects := make(map[string]Object)
+	for _, o := range before.Objects() {
+		beforeObjects[o.key()] = o
+	}
+
+	afterObjects := make(map[string]Object)
+	for _, o := range after.Objects() {
+		afterObjects[o.key()] = o
+	}
+
+	diffs := make([]Difference, 0)
+	for key, b := range beforeObjects {
+		a, ok := afterObjects[key]
+		if !ok {
+			diffs = append(diffs, Difference{Kind: b.Kind, Name: b.Name, Change: ChangeRemoved, Before: b.Definition})
+		} else if a.Definition != b.Definition {
+			diffs = append(diffs, Difference{Kind: b.Kind, Name: b.Name, Change: ChangeChanged, Before: b.Definition, After: a.Definition})
+		}
+	}
+
+	for key, a := range afterObjects {
+		if _, ok := beforeObjects[key]; !ok {
+			diffs = append(diffs, Difference{Kind: a.Kind, Name: a.Name, Change: ChangeAdded, After: a.Definition})
+		}
+	}
+
+	sort.Slice(diffs, func(i, j int) bool {
+		if diffs[i].Kind != diffs[j].Kind {
+			return diffs[i].Kind < diffs[j].Kind
+		}
+		return diffs[i].Name < diffs[j].Name
+	})
+
+	return diffs
+}
+
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
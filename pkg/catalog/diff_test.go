@@ -0,0 +1,77 @@
+package catalog
+
+import (
+	"reflect"
+	"testing"
+)
+
+func testCatalog() *Catalog {
+	return &Catalog{
+		Tables: []Table{
+			{Schema: "public", Name: "account", Columns: []Column{
+				{Name: "id", Type: "integer", NotNull: true, Identity: "d"},
+				{Name: "name", Type: "text"},
+			}},
+		},
+		Indexes: []Index{
+			{Schema: "public", Table: "account", Name: "account_name", Definition: "CREATE INDEX account_name ON public.account USING btree (name)"},
+		},
+		Constraints: []Constraint{
+			{Schema: "public", Table: "account", Name: "account_pkey", Type: "p", Definition: "PRIMARY KEY (id)"},
+		},
+		Enums: []Enum{
+			{Schema: "public", Name: "mood", Labels: []string{"happy", "sad"}},
+		},
+	}
+}
+
+func TestDiff(t *testing.T) {
+	t.Run("identical", func(t *testing.T) {
+		got := Diff(testCatalog(), testCatalog())
+		if len(got) != 0 {
+			t.Errorf("got %v, want no differences", got)
+		}
+	})
+
+	t.Run("residue", func(t *testing.T) {
+		before := testCatalog()
+		before.Indexes = nil
+		before.Enums = nil
+
+		after := testCatalog()
+		after.Tables[0].Columns[1].NotNull = true
+
+		got := Diff(before, after)
+		want := []Difference{
+			{Kind: KindColumn, Name: "public.account.name", Change: ChangeChanged, Before: "text", After: "text NOT NULL"},
+			{Kind: KindEnum, Name: "public.mood", Change: ChangeAdded, After: "ENUM ('happy', 'sad')"},
+			{Kind: KindIndex, Name: "public.account_name", Change: ChangeAdded, After: "CREATE INDEX account_name ON public.account USING btree (name)"},
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("removed", func(t *testing.T) {
+		after := testCatalog()
+		after.Tables = nil
+
+		got := Diff(testCatalog(), after)
+		want := []string{
+			"column public.account.id removed",
+			"column public.account.name removed",
+			"table public.account removed",
+		}
+
+		if len(got) != len(want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+
+		for i, d := range got {
+			if d.String() != want[i] {
+				t.Errorf("got %q, want %q", d.String(), want[i])
+			}
+		}
+	})
+}
@@ -0,0 +1,355 @@
+package catalog
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Draft writes a script which turns the from schema into the to schema. It is
+// meant as a starting point to be reviewed by hand: renames show up as a drop
+// followed by a create, and changes PostgreSQL can't make in place are left
+// as TODO comments.
+//
+// Everything being dropped goes first, in the reverse of the order DDL
+// creates things, followed by everything being created or altered.
+func Draft(from, to *Catalog) string {
+	d := draft{}
+
+	d.enums(from, to)
+	d.functions(from, to)
+	d.sequences(from, to)
+	d.tables(from, to)
+	d.sequenceOwners(from, to)
+	d.constraints(from, to)
+	d.indexes(from, to)
+	d.views(from, to)
+
+	statements := make([]string, 0, len(d.drops)+len(d.creates))
+	for i := len(d.drops) - 1; i >= 0; i-- {
+		statements = append(statements, d.drops[i])
+	}
+	statements = append(statements, d.creates...)
+
+	if len(statements) == 0 {
+		return ""
+	}
+
+	return strings.Join(statements, "\n\n") + "\n"
+}
+
+type draft struct {
+	drops   []string
+	creates []string
+}
+
+func (d *draft) drop(format string, args ...interface{}) {
+	d.drops = append(d.drops, fmt.Sprintf(format, args...))
+}
+
+func (d *draft) create(format string, args ...interface{}) {
+	d.creates = append(d.creates, fmt.Sprintf(format, args...))
+}
+
+func (d *draft) enums(from, to *Catalog) {
+	before := make(map[string]Enum)
+	for _, e := range from.Enums {
+		before[qualify(e.Schema, e.Name)] = e
+	}
+
+	after := make(map[string]Enum)
+	for _, e := range to.Enums {
+		name := qualify(e.Schema, e.Name)
+		after[name] = e
+
+		old, ok := before[name]
+		if !ok {
+			d.create("%s", e.create())
+			continue
+		}
+
+		for _, label := range e.Labels {
+			if !containsString(old.Labels, label) {
+				d.create("ALTER TYPE %s ADD VALUE %s;", name, quoteLiteral(label))
+			}
+		}
+		for _, label := range old.Labels {
+			if !containsString(e.Labels, label) {
+				d.create("-- TODO: %s no longer has the value %s, which PostgreSQL can't remove from an enum", name, quoteLiteral(label))
+			}
+		}
+	}
+
+	for _, e := range from.Enums {
+		name := qualify(e.Schema, e.Name)
+		if _, ok := after[name]; !ok {
+			d.drop("DROP TYPE %s;", name)
+		}
+	}
+}
+
+func (d *draft) functions(from, to *Catalog) {
+	key := func(f Function) string {
+		return qualify(f.Schema, f.Name) + "(" + f.Arguments + ")"
+	}
+
+	before := make(map[string]Function)
+	for _, f := range from.Functions {
+		before[key(f)] = f
+	}
+
+	after := make(map[string]Function)
+	for _, f := range to.Functions {
+		after[key(f)] = f
+
+		old, ok := before[key(f)]
+		if !ok || old.Definition != f.Definition {
+			d.create("%s", f.create())
+		}
+	}
+
+	for _, f := range from.Functions {
+		if _, ok := after[key(f)]; !ok {
+			d.drop("DROP FUNCTION %s;", key(f))
+		}
+	}
+}
+
+func (d *draft) sequences(from, to *Catalog) {
+	before := make(map[string]Sequence)
+	for _, s := range from.Sequences {
+		before[qualify(s.Schema, s.Name)] = s
+	}
+
+	after := make(map[string]Sequence)
+	for _, s := range to.Sequences {
+		name := qualify(s.Schema, s.Name)
+		after[name] = s
+
+		old, ok := before[name]
+		if !ok {
+			d.create("%s", s.create())
+		} else if old.options() != s.options() {
+			cycle := ""
+			if !s.Cycle {
+				cycle = " NO CYCLE"
+			}
+			d.create("ALTER SEQUENCE %s %s%s;", name, s.options(), cycle)
+		}
+	}
+
+	for _, s := range from.Sequences {
+		name := qualify(s.Schema, s.Name)
+		if _, ok := after[name]; !ok {
+			d.drop("DROP SEQUENCE %s;", name)
+		}
+	}
+}
+
+// sequenceOwners runs once the tables and columns sequences belong to exist
+func (d *draft) sequenceOwners(from, to *Catalog) {
+	before := make(map[string]Sequence)
+	for _, s := range from.Sequences {
+		before[qualify(s.Schema, s.Name)] = s
+	}
+
+	for _, s := range to.Sequences {
+		old, ok := before[qualify(s.Schema, s.Name)]
+		if old.OwnedBy == s.OwnedBy {
+			continue
+		}
+
+		if s.OwnedBy != "" {
+			d.create("%s", s.own())
+		} else if ok {
+			d.create("ALTER SEQUENCE %s OWNED BY NONE;", qualify(s.Schema, s.Name))
+		}
+	}
+}
+
+func (d *draft) tables(from, to *Catalog) {
+	before := make(map[string]Table)
+	for _, t := range from.Tables {
+		before[qualify(t.Schema, t.Name)] = t
+	}
+
+	after := make(map[string]Table)
+	for _, t := range to.Tables {
+		name := qualify(t.Schema, t.Name)
+		after[name] = t
+
+		old, ok := before[name]
+		if !ok {
+			d.create("%s", t.create())
+			continue
+		}
+
+		d.columns(name, old, t)
+	}
+
+	for _, t := range from.Tables {
+		name := qualify(t.Schema, t.Name)
+		if _, ok := after[name]; !ok {
+			d.drop("DROP TABLE %s;", name)
+		}
+	}
+}
+
+func (d *draft) columns(table string, from, to Table) {
+	before := make(map[string]Column)
+	for _, col := range from.Columns {
+		before[col.Name] = col
+	}
+
+	after := make(map[string]Column)
+	for _, col := range to.Columns {
+		after[col.Name] = col
+
+		old, ok := before[col.Name]
+		if !ok {
+			d.create("ALTER TABLE %s ADD COLUMN %s %s;", table, col.Name, col.definition())
+		} else if old.definition() != col.definition() {
+			d.alterColumn(table, old, col)
+		}
+	}
+
+	for _, col := range from.Columns {
+		if _, ok := after[col.Name]; !ok {
+			d.drop("ALTER TABLE %s DROP COLUMN %s;", table, col.Name)
+		}
+	}
+}
+
+func (d *draft) alterColumn(table string, from, to Column) {
+	alter := "ALTER TABLE " + table + " ALTER COLUMN " + to.Name
+
+	if from.Generated != to.Generated || (to.Generated != "" && from.Default != to.Default) {
+		d.create("-- TODO: %s.%s changed from %s to %s, which needs the column to be recreated", table, to.Name, from.definition(), to.definition())
+		return
+	}
+
+	if from.Type != to.Type {
+		d.create("%s TYPE %s; -- TODO: check whether a USING clause is needed", alter, to.Type)
+	}
+
+	if from.Default != to.Default && to.Default == "" {
+		d.create("%s DROP DEFAULT;", alter)
+	} else if from.Default != to.Default {
+		d.create("%s SET DEFAULT %s;", alter, to.Default)
+	}
+
+	generated := map[string]string{"a": "ALWAYS", "d": "BY DEFAULT"}
+	if from.Identity != to.Identity {
+		switch {
+		case to.Identity == "":
+			d.create("%s DROP IDENTITY;", alter)
+		case from.Identity == "":
+			d.create("%s ADD GENERATED %s AS IDENTITY;", alter, generated[to.Identity])
+		default:
+			d.create("%s SET GENERATED %s;", alter, generated[to.Identity])
+		}
+	}
+
+	if from.NotNull && !to.NotNull {
+		d.create("%s DROP NOT NULL;", alter)
+	} else if !from.NotNull && to.NotNull {
+		d.create("%s SET NOT NULL;", alter)
+	}
+}
+
+func (d *draft) constraints(from, to *Catalog) {
+	before := make(map[string]Constraint)
+	for _, k := range from.Constraints {
+		before[qualify(k.Schema, k.Table, k.Name)] = k
+	}
+
+	after := make(map[string]Constraint)
+	for _, k := range to.Constraints {
+		after[qualify(k.Schema, k.Table, k.Name)] = k
+	}
+
+	dropConstraint := func(k Constraint) {
+		d.drop("ALTER TABLE %s DROP CONSTRAINT %s;", qualify(k.Schema, k.Table), k.Name)
+	}
+
+	// Drops are reversed at the end, so foreign keys go in last in order to
+	// be dropped first, and the other way around for creates
+	for _, foreign := range []bool{false, true} {
+		for _, k := range from.Constraints {
+			current, ok := after[qualify(k.Schema, k.Table, k.Name)]
+			if k.foreign() == foreign && (!ok || current.Definition != k.Definition) {
+				dropConstraint(k)
+			}
+		}
+
+		for _, k := range to.Constraints {
+			old, ok := before[qualify(k.Schema, k.Table, k.Name)]
+			if k.foreign() == foreign && (!ok || old.Definition != k.Definition) {
+				d.create("%s", k.add())
+			}
+		}
+	}
+}
+
+func (d *draft) indexes(from, to *Catalog) {
+	before := make(map[string]Index)
+	for _, i := range from.Indexes {
+		before[qualify(i.Schema, i.Name)] = i
+	}
+
+	after := make(map[string]Index)
+	for _, i := range to.Indexes {
+		after[qualify(i.Schema, i.Name)] = i
+
+		old, ok := before[qualify(i.Schema, i.Name)]
+		if !ok || old.Definition != i.Definition {
+			d.create("%s", i.create())
+		}
+	}
+
+	for _, i := range from.Indexes {
+		current, ok := after[qualify(i.Schema, i.Name)]
+		if !ok || current.Definition != i.Definition {
+			d.drop("DROP INDEX %s;", qualify(i.Schema, i.Name))
+		}
+	}
+}
+
+func (d *draft) views(from, to *Catalog) {
+	before := make(map[string]View)
+	for _, v := range from.Views {
+		before[qualify(v.Schema, v.Name)] = v
+	}
+
+	after := make(map[string]View)
+	for _, v := range to.Views {
+		after[qualify(v.Schema, v.Name)] = v
+	}
+
+	changed := func(a, b View) bool {
+		return a.Definition != b.Definition || a.Materialized != b.Materialized
+	}
+
+	for _, v := range from.sortedViews() {
+		current, ok := after[qualify(v.Schema, v.Name)]
+		if !ok || changed(v, current) {
+			d.drop("DROP %s %s;", v.kind(), qualify(v.Schema, v.Name))
+		}
+	}
+
+	for _, v := range to.sortedViews() {
+		old, ok := before[qualify(v.Schema, v.Name)]
+		if !ok || changed(old, v) {
+			d.create("%s", v.create())
+		}
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
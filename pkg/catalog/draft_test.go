@@ -0,0 +1,52 @@
+package catalog
+
+import (
+	"testing"
+)
+
+func TestDraft(t *testing.T) {
+	t.Run("identical", func(t *testing.T) {
+		got := Draft(testCatalog(), testCatalog())
+		if got != "" {
+			t.Errorf("got %q, want no statements", got)
+		}
+	})
+
+	t.Run("changes", func(t *testing.T) {
+		from := testCatalog()
+		from.Views = []View{{Schema: "public", Name: "names", Definition: " SELECT name FROM public.account;"}}
+
+		to := testCatalog()
+		to.Tables[0].Columns[1] = Column{Name: "name", Type: "character varying(64)", NotNull: true, Default: "'anonymous'::character varying"}
+		to.Tables[0].Columns = append(to.Tables[0].Columns, Column{Name: "email", Type: "text"})
+		to.Tables = append(to.Tables, Table{Schema: "public", Name: "audit", Columns: []Column{{Name: "id", Type: "integer"}}})
+		to.Indexes = nil
+		to.Enums[0].Labels = []string{"happy", "ok"}
+
+		want := `DROP VIEW public.names;
+
+DROP INDEX public.account_name;
+
+ALTER TYPE public.mood ADD VALUE 'ok';
+
+-- TODO: public.mood no longer has the value 'sad', which PostgreSQL can't remove from an enum
+
+ALTER TABLE public.account ALTER COLUMN name TYPE character varying(64); -- TODO: check whether a USING clause is needed
+
+ALTER TABLE public.account ALTER COLUMN name SET DEFAULT 'anonymous'::character varying;
+
+ALTER TABLE public.account ALTER COLUMN name SET NOT NULL;
+
+ALTER TABLE public.account ADD COLUMN email text;
+
+CREATE TABLE public.audit (
+    id integer
+);
+`
+
+		got := Draft(from, to)
+		if got != want {
+			t.Errorf("got:\n%s\nwant:\n%s", got, want)
+		}
+	})
+}
@@ -0,0 +1,37 @@
+package catalog
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVersionedQueries(t *testing.T) {
+	tests := []struct {
+		ServerVersion int
+		Generated     bool
+		Prokind       bool
+	}{
+		{100000, false, false},
+		{110005, false, true},
+		{120000, true, true},
+		{160002, true, true},
+	}
+
+	for _, test := range tests {
+		s := snapshotter{serverVersion: test.ServerVersion}
+
+		got := strings.Contains(s.columnsQuery(), "attgenerated")
+		if got != test.Generated {
+			t.Errorf("got attgenerated %v for server %d, want %v", got, test.ServerVersion, test.Generated)
+		}
+
+		got = strings.Contains(s.functionsQuery(), "prokind")
+		if got != test.Prokind {
+			t.Errorf("got prokind %v for server %d, want %v", got, test.ServerVersion, test.Prokind)
+		}
+
+		if !strings.Contains(s.functionsQuery(), "proisagg") && !test.Prokind {
+			t.Errorf("got no proisagg filter for server %d, want one", test.ServerVersion)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+// Package lint statically checks migration scripts for operations which are
+// dangerous to run against a busy database, typically because they hold a
+// heavy lock for as long as it takes to rewrite or scan a whole table.
+//
+// A finding can be suppressed by putting a comment naming its rule in or just
+// before the statement, or anywhere in the file to suppress it for the whole
+// file:
+//
+//	-- +pgm lint-ignore create-index-not-concurrently
+//	-- +pgm lint-ignore-file set-not-null
+package lint
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type Severity string
+
+const (
+	SeverityWarning Severity = "warning"
+	SeverityError   Severity = "error"
+)
+
+var ErrUnknownSeverity = errors.New("Lint severity must be either 'warning' or 'error'")
+var ErrUnknownRule = errors.New("Lint rule is not one of the known rules")
+
+func ParseSeverity(name string) (Severity, error) {
+	switch Severity(name) {
+	case SeverityWarning, SeverityError:
+		return Severity(name), nil
+	default:
+		return "", ErrUnknownSeverity
+	}
+}
+
+const (
+	ignoreDirective     = "-- +pgm lint-ignore "
+	ignoreFileDirective = "-- +pgm lint-ignore-file "
+)
+
+// Finding is a single problem found in a script
+type Finding struct {
+	File     string
+	Line     int
+	Rule     string
+	Severity Severity
+	Message  string
+}
+
+// String formats the finding the way compilers do, which most CI systems know
+// how to annotate
+func (f Finding) String() string {
+	return fmt.Sprintf("%s:%d: %s: %s (%s)", f.File, f.Line, f.Severity, f.Message, f.Rule)
+}
+
+// Linter checks scripts against a set of rules. Scripts should be linted in
+// the order they are applied, since rules remember things like which tables
+// were created by an earlier script.
+type Linter struct {
+	Rules []Rule
+
+	context Context
+}
+
+// Lint checks every statement in a script
+func (l *Linter) Lint(file, sql string) []Finding {
+	findings := make([]Finding, 0)
+
+	statements := Split(sql)
+	l.context.statements = len(statements)
+
+	// File wide suppressions may be anywhere, even after the last statement
+	fileIgnores := ignoredRules(strings.Split(sql, "\n"), ignoreFileDirective)
+
+	for _, stmt := range statements {
+		ignores := ignoredRules(stmt.Comments, ignoreDirective)
+
+		for _, rule := range l.Rules {
+			if fileIgnores[rule.Name] || ignores[rule.Name] {
+				continue
+			}
+
+			message := rule.Check(stmt, &l.context)
+			if message == "" {
+				continue
+			}
+
+			findings = append(findings, Finding{
+				File:     file,
+				Line:     stmt.Line,
+				Rule:     rule.Name,
+				Severity: rule.Severity,
+				Message:  message,
+			})
+		}
+
+		l.context.record(stmt)
+	}
+
+	return findings
+}
+
+// ignoredRules collects the rules named by suppression comments
+func ignoredRules(comments []string, directive string) map[string]bool {
+	ignored := make(map[string]bool)
+	for _, comment := range comments {
+		comment = strings.TrimSpace(comment)
+		if !strings.HasPrefix(comment, directive) {
+			continue
+		}
+
+		for _, rule := range strings.FieldsFunc(strings.TrimPrefix(comment, directive), isRuleSeparator) {
+			ignored[rule] = true
+		}
+	}
+
+	return ignored
+}
+
+func isRuleSeparator(r rune) bool {
+	return r == ',' || r == ' ' || r == '\t'
+}
+
+// HasErrors reports whether any of the findings is an error
+func HasErrors(findings []Finding) bool {
+	for _, f := range findings {
+		if f.Severity == SeverityError {
+			return true
+		}
+	}
+
+	return false
+}
+
+func NewLinter(rules []Rule) *Linter {
+	l := Linter{
+		Rules:   rules,
+		context: newContext(),
+	}
+
+	return &l
+}
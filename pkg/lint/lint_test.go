@@ -0,0 +1,95 @@
+package lint
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Sql      string
+		Expected []string
+	}{
+		{"index", "CREATE INDEX a_b ON a(b)", []string{"create-index-not-concurrently"}},
+		{"unique index", "CREATE UNIQUE INDEX IF NOT EXISTS a_b ON public.a USING btree (b)", []string{"create-index-not-concurrently"}},
+		{"concurrent index", "CREATE INDEX CONCURRENTLY a_b ON a(b)", []string{}},
+		{"concurrent index in transaction", "SELECT 1; CREATE INDEX CONCURRENTLY a_b ON a(b)", []string{"concurrently-in-transaction"}},
+		{"index on new table", "CREATE TABLE a(b INT); CREATE INDEX a_b ON a(b)", []string{}},
+		{"volatile default", "ALTER TABLE a ADD COLUMN b UUID DEFAULT gen_random_uuid()", []string{"add-column-volatile-default"}},
+		{"serial column", "ALTER TABLE a ADD COLUMN b BIGSERIAL", []string{"add-column-volatile-default"}},
+		{"constant default", "ALTER TABLE a ADD COLUMN b INT NOT NULL DEFAULT 0", []string{}},
+		{"default in a literal", "ALTER TABLE a ADD COLUMN b TEXT DEFAULT 'random()'", []string{}},
+		{"column type", "ALTER TABLE a ALTER COLUMN b TYPE BIGINT", []string{"alter-column-type"}},
+		{"column type on new table", "CREATE TABLE a(b INT); ALTER TABLE a ALTER COLUMN b SET DATA TYPE BIGINT", []string{}},
+		{"not null", "ALTER TABLE a ALTER COLUMN b SET NOT NULL", []string{"set-not-null"}},
+		{"not null after check", `ALTER TABLE a ADD CONSTRAINT b_not_null CHECK (b IS NOT NULL) NOT VALID;
+			ALTER TABLE a VALIDATE CONSTRAINT b_not_null;
+			ALTER TABLE a ALTER COLUMN b SET NOT NULL`, []string{}},
+		{"unvalidated check", `ALTER TABLE a ADD CONSTRAINT b_not_null CHECK (b IS NOT NULL) NOT VALID;
+			ALTER TABLE a ALTER COLUMN b SET NOT NULL`, []string{"set-not-null"}},
+		{"foreign key", "ALTER TABLE a ADD CONSTRAINT a_c FOREIGN KEY (c) REFERENCES c(id)", []string{"add-constraint-not-valid"}},
+		{"foreign key not valid", "ALTER TABLE a ADD FOREIGN KEY (c) REFERENCES c(id) NOT VALID", []string{}},
+		{"suppressed", "-- +pgm lint-ignore create-index-not-concurrently\nCREATE INDEX a_b ON a(b)", []string{}},
+		{"suppressed elsewhere", "-- +pgm lint-ignore create-index-not-concurrently\nSELECT 1;\nCREATE INDEX a_b ON a(b)", []string{"create-index-not-concurrently"}},
+		{"suppressed for file", "SELECT 1;\nCREATE INDEX a_b ON a(b);\n-- +pgm lint-ignore-file create-index-not-concurrently, set-not-null", []string{}},
+	}
+
+	for _, test := range cases {
+		t.Run(test.Name, func(t *testing.T) {
+			linter := NewLinter(DefaultRules())
+
+			rules := make([]string, 0)
+			for _, finding := range linter.Lint("001.up.sql", test.Sql) {
+				rules = append(rules, finding.Rule)
+			}
+
+			if !reflect.DeepEqual(rules, test.Expected) {
+				t.Errorf("got %v, want %v", rules, test.Expected)
+			}
+		})
+	}
+}
+
+func TestLintAcrossFiles(t *testing.T) {
+	linter := NewLinter(DefaultRules())
+
+	findings := linter.Lint("001.up.sql", "CREATE TABLE a(b INT)")
+	findings = append(findings, linter.Lint("002.up.sql", "\n\nCREATE INDEX a_b ON a(b);\nCREATE INDEX c_d ON c(d);")...)
+
+	if len(findings) != 1 {
+		t.Fatalf("got %v, want 1 finding", findings)
+	}
+
+	want := "002.up.sql:4: error: CREATE INDEX without CONCURRENTLY blocks writes to c while the index is built (create-index-not-concurrently)"
+	if findings[0].String() != want {
+		t.Errorf("got %q, want %q", findings[0].String(), want)
+	}
+
+	if !HasErrors(findings) {
+		t.Errorf("got no errors, want one")
+	}
+}
+
+func TestWithSeverities(t *testing.T) {
+	rules, err := WithSeverities(DefaultRules(), map[string]Severity{"set-not-null": SeverityError})
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	findings := NewLinter(rules).Lint("001.up.sql", "ALTER TABLE a ALTER COLUMN b SET NOT NULL")
+	if len(findings) != 1 || findings[0].Severity != SeverityError {
+		t.Errorf("got %v, want set-not-null as an error", findings)
+	}
+
+	// The defaults are left as they were
+	if SetNotNull.Severity != SeverityWarning {
+		t.Errorf("got %v, want %v", SetNotNull.Severity, SeverityWarning)
+	}
+
+	_, err = WithSeverities(DefaultRules(), map[string]Severity{"no-such-rule": SeverityError})
+	if !errors.Is(err, ErrUnknownRule) {
+		t.Errorf("got %v, want %v", err, ErrUnknownRule)
+	}
+}
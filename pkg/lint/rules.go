@@ -0,0 +1,237 @@
+package lint
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Rule checks a single statement, returning a message describing the problem
+// or an empty string if there is none
+type Rule struct {
+	Name        string
+	Severity    Severity
+	Description string
+	Check       func(stmt Statement, ctx *Context) string
+}
+
+// Context is what the linter has learned from the statements before the one
+// being checked
+type Context struct {
+	// statements is the number of statements in the current script
+	statements int
+	// createdTables holds tables created by an earlier statement. Locking a
+	// table nobody else can be using yet is harmless.
+	createdTables map[string]bool
+	// checks maps NOT VALID constraints to the column they check for nulls
+	checks map[string]string
+	// notNullChecked holds columns with a validated IS NOT NULL check
+	notNullChecked map[string]bool
+}
+
+func newContext() Context {
+	return Context{
+		createdTables:  make(map[string]bool),
+		checks:         make(map[string]string),
+		notNullChecked: make(map[string]bool),
+	}
+}
+
+var (
+	createTablePattern     = regexp.MustCompile(`^CREATE (?:(?:GLOBAL |LOCAL )?(?:TEMPORARY |TEMP )|UNLOGGED )?TABLE (?:IF NOT EXISTS )?([^ (]+)`)
+	alterTablePattern      = regexp.MustCompile(`^ALTER TABLE (?:IF EXISTS )?(?:ONLY )?([^ ]+)`)
+	createIndexPattern     = regexp.MustCompile(`^CREATE (?:UNIQUE )?INDEX (CONCURRENTLY )?.*? ON (?:ONLY )?([^ (]+)`)
+	concurrentlyPattern    = regexp.MustCompile(`^(?:CREATE (?:UNIQUE )?INDEX|DROP INDEX|REINDEX .*|ALTER TABLE .* DETACH PARTITION .*) CONCURRENTLY\b`)
+	addColumnPattern       = regexp.MustCompile(`ADD (?:COLUMN )?(?:IF NOT EXISTS )?[^ ]+ ([^,]*)`)
+	volatileDefaultPattern = regexp.MustCompile(`\b(?:SERIAL|BIGSERIAL|SMALLSERIAL|SERIAL[248])\b|DEFAULT .*\b(?:RANDOM|CLOCK_TIMESTAMP|TIMEOFDAY|GEN_RANDOM_UUID|UUID_GENERATE_V[14]|NEXTVAL) ?\(`)
+	alterTypePattern       = regexp.MustCompile(`ALTER (?:COLUMN )?([^ ]+) (?:SET DATA )?TYPE\b`)
+	setNotNullPattern      = regexp.MustCompile(`ALTER (?:COLUMN )?([^ ]+) SET NOT NULL`)
+	addCheckPattern        = regexp.MustCompile(`ADD CONSTRAINT ([^ ]+) CHECK ?\(+ ?([^ ()]+) IS NOT NULL ?\)+( NOT VALID)?`)
+	validatePattern        = regexp.MustCompile(`VALIDATE CONSTRAINT ([^ ,]+)`)
+	addConstraintPattern   = regexp.MustCompile(`ADD (?:CONSTRAINT [^ ]+ )?(FOREIGN KEY|CHECK)\b`)
+)
+
+// unquote strips the quotes off a (possibly schema qualified) name so that
+// quoted and unquoted spellings compare equal
+func unquote(name string) string {
+	return strings.ReplaceAll(name, `"`, "")
+}
+
+// alteredTable returns the table an ALTER TABLE statement changes, or an empty
+// string for any other statement
+func alteredTable(stmt Statement) string {
+	match := alterTablePattern.FindStringSubmatch(stmt.Code)
+	if match == nil {
+		return ""
+	}
+
+	return unquote(match[1])
+}
+
+// existingTable returns the table an ALTER TABLE statement changes, unless it
+// was created by an earlier statement
+func (c *Context) existingTable(stmt Statement) string {
+	table := alteredTable(stmt)
+	if c.createdTables[table] {
+		return ""
+	}
+
+	return table
+}
+
+// record updates the context once a statement has been checked
+func (c *Context) record(stmt Statement) {
+	if match := createTablePattern.FindStringSubmatch(stmt.Code); match != nil {
+		c.createdTables[unquote(match[1])] = true
+	}
+
+	table := alteredTable(stmt)
+	if table == "" {
+		return
+	}
+
+	for _, match := range addCheckPattern.FindAllStringSubmatch(stmt.Code, -1) {
+		column := table + "." + unquote(match[2])
+		if match[3] == "" {
+			c.notNullChecked[column] = true
+		} else {
+			c.checks[unquote(match[1])] = column
+		}
+	}
+
+	for _, match := range validatePattern.FindAllStringSubmatch(stmt.Code, -1) {
+		column, ok := c.checks[unquote(match[1])]
+		if ok {
+			c.notNullChecked[column] = true
+		}
+	}
+}
+
+var CreateIndexNotConcurrently = Rule{
+	Name:        "create-index-not-concurrently",
+	Severity:    SeverityError,
+	Description: "CREATE INDEX without CONCURRENTLY blocks writes to the table until the index is built",
+	Check: func(stmt Statement, ctx *Context) string {
+		match := createIndexPattern.FindStringSubmatch(stmt.Code)
+		if match == nil || match[1] != "" || ctx.createdTables[unquote(match[2])] {
+			return ""
+		}
+
+		return "CREATE INDEX without CONCURRENTLY blocks writes to " + strings.ToLower(unquote(match[2])) + " while the index is built"
+	},
+}
+
+var ConcurrentlyInTransaction = Rule{
+	Name:        "concurrently-in-transaction",
+	Severity:    SeverityError,
+	Description: "CONCURRENTLY operations can't run inside a transaction, which every script with more than one statement is",
+	Check: func(stmt Statement, ctx *Context) string {
+		if ctx.statements < 2 || !concurrentlyPattern.MatchString(stmt.Code) {
+			return ""
+		}
+
+		return "CONCURRENTLY can't run inside a transaction, move this statement into a migration of its own"
+	},
+}
+
+var AddColumnVolatileDefault = Rule{
+	Name:        "add-column-volatile-default",
+	Severity:    SeverityError,
+	Description: "Adding a column with a volatile default rewrites the whole table under an exclusive lock",
+	Check: func(stmt Statement, ctx *Context) string {
+		if ctx.existingTable(stmt) == "" {
+			return ""
+		}
+
+		for _, match := range addColumnPattern.FindAllStringSubmatch(stmt.Code, -1) {
+			if volatileDefaultPattern.MatchString(match[1]) {
+				return "Adding a column with a volatile default rewrites " + strings.ToLower(alteredTable(stmt)) + " under an exclusive lock, add it without a default and backfill in batches"
+			}
+		}
+
+		return ""
+	},
+}
+
+var AlterColumnType = Rule{
+	Name:        "alter-column-type",
+	Severity:    SeverityError,
+	Description: "Changing a column's type usually rewrites the whole table under an exclusive lock",
+	Check: func(stmt Statement, ctx *Context) string {
+		table := ctx.existingTable(stmt)
+		if table == "" || !alterTypePattern.MatchString(stmt.Code) {
+			return ""
+		}
+
+		return "Changing a column's type usually rewrites " + strings.ToLower(table) + " under an exclusive lock, consider adding a new column instead"
+	},
+}
+
+var SetNotNull = Rule{
+	Name:        "set-not-null",
+	Severity:    SeverityWarning,
+	Description: "SET NOT NULL scans the whole table under an exclusive lock unless a validated CHECK (column IS NOT NULL) constraint already exists",
+	Check: func(stmt Statement, ctx *Context) string {
+		table := ctx.existingTable(stmt)
+		if table == "" {
+			return ""
+		}
+
+		for _, match := range setNotNullPattern.FindAllStringSubmatch(stmt.Code, -1) {
+			if !ctx.notNullChecked[table+"."+unquote(match[1])] {
+				return "SET NOT NULL scans " + strings.ToLower(table) + " under an exclusive lock, add a CHECK (" + strings.ToLower(unquote(match[1])) + " IS NOT NULL) NOT VALID constraint and validate it first"
+			}
+		}
+
+		return ""
+	},
+}
+
+var AddConstraintNotValid = Rule{
+	Name:        "add-constraint-not-valid",
+	Severity:    SeverityWarning,
+	Description: "Adding a foreign key or check constraint without NOT VALID scans the whole table while holding a lock",
+	Check: func(stmt Statement, ctx *Context) string {
+		table := ctx.existingTable(stmt)
+		if table == "" || !addConstraintPattern.MatchString(stmt.Code) || strings.Contains(stmt.Code, " NOT VALID") {
+			return ""
+		}
+
+		return "Adding a constraint scans " + strings.ToLower(table) + " while holding a lock, add it NOT VALID and VALIDATE CONSTRAINT separately"
+	},
+}
+
+// WithSeverities returns a copy of rules with the severities of some of them
+// changed, keyed by rule name
+func WithSeverities(rules []Rule, severities map[string]Severity) ([]Rule, error) {
+	configured := make([]Rule, len(rules))
+	copy(configured, rules)
+
+	for name, severity := range severities {
+		found := false
+		for i := range configured {
+			if configured[i].Name == name {
+				configured[i].Severity = severity
+				found = true
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownRule, name)
+		}
+	}
+
+	return configured, nil
+}
+
+// DefaultRules is every rule, each at its default severity
+func DefaultRules() []Rule {
+	return []Rule{
+		CreateIndexNotConcurrently,
+		ConcurrentlyInTransaction,
+		AddColumnVolatileDefault,
+		AlterColumnType,
+		SetNotNull,
+		AddConstraintNotValid,
+	}
+}
@@ -0,0 +1,246 @@
+package lint
+
+import (
+	"strings"
+)
+
+// Statement is a single SQL statement from a migration script
+type Statement struct {
+	// Text is the statement as written, including any comments before it
+	Text string
+	// Code is the statement with comments removed, the contents of string
+	// literals blanked out, whitespace collapsed and everything upper cased,
+	// ready for rules to match against
+	Code string
+	// Line is the line the statement starts on, counting from 1
+	Line int
+	// Comments holds the text of every comment in or before the statement
+	Comments []string
+}
+
+// Split breaks a script into statements on semicolons, skipping over those
+// in comments, quoted identifiers, string literals and dollar quoted bodies
+func Split(sql string) []Statement {
+	s := splitter{src: sql, line: 1}
+	s.reset()
+
+	for s.pos < len(s.src) {
+		c := s.src[s.pos]
+
+		switch {
+		case c == '-' && s.peek(1) == '-':
+			s.lineComment()
+		case c == '/' && s.peek(1) == '*':
+			s.blockComment()
+		case c == '\'':
+			escapes := s.pos > 0 && (s.src[s.pos-1] == 'e' || s.src[s.pos-1] == 'E') && !isIdentChar(s.peekBack(2))
+			s.literal(escapes)
+		case c == '"':
+			s.quotedIdent()
+		case c == '$' && !isIdentChar(s.peekBack(1)):
+			s.dollarQuote()
+		case c == ';':
+			s.pos++
+			s.finish()
+		default:
+			s.code(c)
+			s.advance(1)
+		}
+	}
+	s.finish()
+
+	return s.statements
+}
+
+type splitter struct {
+	src  string
+	pos  int
+	line int
+
+	start      int
+	startLine  int
+	buf        []byte
+	comments   []string
+	statements []Statement
+}
+
+func (s *splitter) reset() {
+	s.start = s.pos
+	s.startLine = 0
+	s.buf = s.buf[:0]
+	s.comments = nil
+}
+
+func (s *splitter) peek(offset int) byte {
+	if s.pos+offset >= len(s.src) {
+		return 0
+	}
+
+	return s.src[s.pos+offset]
+}
+
+func (s *splitter) peekBack(offset int) byte {
+	if s.pos-offset < 0 {
+		return 0
+	}
+
+	return s.src[s.pos-offset]
+}
+
+// advance moves past n bytes, counting any new lines
+func (s *splitter) advance(n int) {
+	end := s.pos + n
+	if end > len(s.src) {
+		end = len(s.src)
+	}
+
+	s.line += strings.Count(s.src[s.pos:end], "\n")
+	s.pos = end
+}
+
+// code records a byte of actual SQL, collapsing whitespace
+func (s *splitter) code(c byte) {
+	if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
+		if len(s.buf) > 0 && s.buf[len(s.buf)-1] != ' ' {
+			s.buf = append(s.buf, ' ')
+		}
+		return
+	}
+
+	if s.startLine == 0 {
+		s.startLine = s.line
+	}
+	s.buf = append(s.buf, c)
+}
+
+func (s *splitter) lineComment() {
+	end := strings.IndexByte(s.src[s.pos:], '\n')
+	if end < 0 {
+		end = len(s.src) - s.pos
+	}
+
+	s.comments = append(s.comments, s.src[s.pos:s.pos+end])
+	s.advance(end)
+}
+
+func (s *splitter) blockComment() {
+	depth := 0
+	i := s.pos
+	for i < len(s.src) {
+		if strings.HasPrefix(s.src[i:], "/*") {
+			depth++
+			i += 2
+		} else if strings.HasPrefix(s.src[i:], "*/") {
+			depth--
+			i += 2
+			if depth == 0 {
+				break
+			}
+		} else {
+			i++
+		}
+	}
+
+	s.comments = append(s.comments, s.src[s.pos:i])
+	s.code(' ')
+	s.advance(i - s.pos)
+}
+
+// literal skips over a string literal, keeping only its quotes in the code
+func (s *splitter) literal(escapes bool) {
+	i := s.pos + 1
+	for i < len(s.src) {
+		if escapes && s.src[i] == '\\' {
+			i += 2
+			continue
+		}
+
+		if s.src[i] == '\'' {
+			if i+1 < len(s.src) && s.src[i+1] == '\'' {
+				i += 2
+				continue
+			}
+			i++
+			break
+		}
+		i++
+	}
+
+	s.code('\'')
+	s.code('\'')
+	s.advance(i - s.pos)
+}
+
+func (s *splitter) quotedIdent() {
+	i := s.pos + 1
+	for i < len(s.src) {
+		if s.src[i] == '"' {
+			if i+1 < len(s.src) && s.src[i+1] == '"' {
+				i += 2
+				continue
+			}
+			i++
+			break
+		}
+		i++
+	}
+
+	for _, c := range []byte(strings.ToUpper(s.src[s.pos:i])) {
+		s.code(c)
+	}
+	s.advance(i - s.pos)
+}
+
+// dollarQuote skips over a $tag$ ... $tag$ body, which is treated like a
+// string literal. A lone $ (as in a $1 parameter) is ordinary code.
+func (s *splitter) dollarQuote() {
+	end := strings.IndexByte(s.src[s.pos+1:], '$')
+	tag := ""
+	if end >= 0 {
+		tag = s.src[s.pos : s.pos+end+2]
+	}
+
+	if tag == "" || !isDollarTag(tag[1:len(tag)-1]) {
+		s.code('$')
+		s.advance(1)
+		return
+	}
+
+	closing := strings.Index(s.src[s.pos+len(tag):], tag)
+	length := len(s.src) - s.pos
+	if closing >= 0 {
+		length = len(tag) + closing + len(tag)
+	}
+
+	s.code('$')
+	s.code('$')
+	s.advance(length)
+}
+
+func (s *splitter) finish() {
+	code := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(string(s.buf)), ";"))
+	if code != "" {
+		s.statements = append(s.statements, Statement{
+			Text:     strings.TrimSpace(s.src[s.start:s.pos]),
+			Code:     strings.ToUpper(code),
+			Line:     s.startLine,
+			Comments: s.comments,
+		})
+	}
+
+	s.reset()
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func isDollarTag(tag string) bool {
+	for i := 0; i < len(tag); i++ {
+		if !isIdentChar(tag[i]) || (i == 0 && tag[i] >= '0' && tag[i] <= '9') {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,44 @@
+package lint
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	cases := []struct {
+		Name          string
+		Sql           string
+		ExpectedCode  []string
+		ExpectedLines []int
+	}{
+		{"single", "CREATE TABLE a()", []string{"CREATE TABLE A()"}, []int{1}},
+		{"several", "SELECT 1;\n\nSELECT 2;\nSELECT 3;", []string{"SELECT 1", "SELECT 2", "SELECT 3"}, []int{1, 3, 4}},
+		{"literals", "SELECT 'a;b', 'it''s';", []string{"SELECT '', ''"}, []int{1}},
+		{"escaped literal", `SELECT E'\';';`, []string{"SELECT E''"}, []int{1}},
+		{"quoted identifier", `CREATE TABLE "semi;colon"();`, []string{`CREATE TABLE "SEMI;COLON"()`}, []int{1}},
+		{"comments", "-- one;\nSELECT /* two; /* nested; */ */ 1;", []string{"SELECT 1"}, []int{2}},
+		{"dollar quotes", "CREATE FUNCTION f() RETURNS INT AS $body$ SELECT 1; $body$ LANGUAGE sql;\nSELECT $1;", []string{"CREATE FUNCTION F() RETURNS INT AS $$ LANGUAGE SQL", "SELECT $1"}, []int{1, 2}},
+		{"whitespace", "ALTER   TABLE a\n\tADD COLUMN b INT;", []string{"ALTER TABLE A ADD COLUMN B INT"}, []int{1}},
+		{"empty statements", ";;\n-- nothing\n", []string{}, []int{}},
+	}
+
+	for _, test := range cases {
+		t.Run(test.Name, func(t *testing.T) {
+			code := make([]string, 0)
+			lines := make([]int, 0)
+			for _, stmt := range Split(test.Sql) {
+				code = append(code, stmt.Code)
+				lines = append(lines, stmt.Line)
+			}
+
+			if !reflect.DeepEqual(code, test.ExpectedCode) {
+				t.Errorf("got %q, want %q", code, test.ExpectedCode)
+			}
+
+			if !reflect.DeepEqual(lines, test.ExpectedLines) {
+				t.Errorf("got %v, want %v", lines, test.ExpectedLines)
+			}
+		})
+	}
+}
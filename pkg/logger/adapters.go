@@ -0,0 +1,114 @@
+package logger
+
+import (
+	"log"
+	"log/slog"
+	"strings"
+)
+
+// Logger is the small logging interface the rest of pgm depends on. CliLogger
+// satisfies it, and the adapters below let applications embedding pgm route
+// its messages into their own logging setup, or drop them entirely.
+type Logger interface {
+	Debug(message string, fields ...Field)
+	Info(message string, fields ...Field)
+	Warn(message string, fields ...Field)
+	Error(message string, fields ...Field)
+}
+
+// NopLogger discards every message
+type NopLogger struct{}
+
+func (NopLogger) Debug(message string, fields ...Field) {}
+func (NopLogger) Info(message string, fields ...Field)  {}
+func (NopLogger) Warn(message string, fields ...Field)  {}
+func (NopLogger) Error(message string, fields ...Field) {}
+
+// StdAdapter writes messages to a standard library *log.Logger, prefixed with
+// their level name
+type StdAdapter struct {
+	Logger   *log.Logger
+	LogLevel LogLevel
+}
+
+func (s StdAdapter) log(logLevel LogLevel, message string, fields []Field) {
+	if s.LogLevel.Value < logLevel.Value {
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString(logLevel.Name)
+	b.WriteString(" ")
+	b.WriteString(message)
+	for _, field := range fields {
+		b.WriteString(" ")
+		b.WriteString(field.Key)
+		b.WriteString("=")
+		b.WriteString(formatTextValue(field.Value))
+	}
+
+	s.Logger.Println(b.String())
+}
+
+func (s StdAdapter) Debug(message string, fields ...Field) {
+	s.log(DebugLogLevel(), message, fields)
+}
+
+func (s StdAdapter) Info(message string, fields ...Field) {
+	s.log(InfoLogLevel(), message, fields)
+}
+
+func (s StdAdapter) Warn(message string, fields ...Field) {
+	s.log(WarnLogLevel(), message, fields)
+}
+
+func (s StdAdapter) Error(message string, fields ...Field) {
+	s.log(ErrorLogLevel(), message, fields)
+}
+
+func NewStdAdapter(l *log.Logger, logLevel LogLevel) StdAdapter {
+	adapter := StdAdapter{
+		Logger:   l,
+		LogLevel: logLevel,
+	}
+
+	return adapter
+}
+
+// SlogAdapter writes messages to a *slog.Logger, with fields as attributes
+type SlogAdapter struct {
+	Logger *slog.Logger
+}
+
+func slogArgs(fields []Field) []interface{} {
+	args := make([]interface{}, 0, len(fields))
+	for _, field := range fields {
+		args = append(args, slog.Any(field.Key, field.Value))
+	}
+
+	return args
+}
+
+func (s SlogAdapter) Debug(message string, fields ...Field) {
+	s.Logger.Debug(message, slogArgs(fields)...)
+}
+
+func (s SlogAdapter) Info(message string, fields ...Field) {
+	s.Logger.Info(message, slogArgs(fields)...)
+}
+
+func (s SlogAdapter) Warn(message string, fields ...Field) {
+	s.Logger.Warn(message, slogArgs(fields)...)
+}
+
+func (s SlogAdapter) Error(message string, fields ...Field) {
+	s.Logger.Error(message, slogArgs(fields)...)
+}
+
+func NewSlogAdapter(l *slog.Logger) SlogAdapter {
+	adapter := SlogAdapter{
+		Logger: l,
+	}
+
+	return adapter
+}
@@ -0,0 +1,49 @@
+package logger
+
+import (
+	"bytes"
+	"log"
+	"log/slog"
+	"testing"
+)
+
+var _ Logger = CliLogger{}
+var _ Logger = NopLogger{}
+var _ Logger = StdAdapter{}
+var _ Logger = SlogAdapter{}
+
+func TestStdAdapter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	adapter := NewStdAdapter(log.New(buf, "", 0), InfoLogLevel())
+
+	adapter.Debug("hidden")
+	adapter.Info("applied", Version("001"))
+	adapter.Error("failed", F("error", "boom"))
+
+	want := "INFO applied version=001\nERROR failed error=boom\n"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestSlogAdapter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	handler := slog.NewTextHandler(buf, &slog.HandlerOptions{
+		Level: slog.LevelWarn,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+	adapter := NewSlogAdapter(slog.New(handler))
+
+	adapter.Info("hidden")
+	adapter.Warn("careful", Version("002"), Direction("down"))
+
+	want := "level=WARN msg=careful version=002 direction=down\n"
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
@@ -1,8 +1,15 @@
 package logger
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 const (
@@ -19,6 +26,16 @@ const (
 	LogLevelErrorValue = 1
 )
 
+const (
+	TextLogFormat LogFormat = "text"
+	JsonLogFormat LogFormat = "json"
+)
+
+const timestampFormat = time.RFC3339
+
+var ErrUnknownLogLevel = errors.New("Log level must be one of 'debug', 'info', 'warn' or 'error'")
+var ErrUnknownLogFormat = errors.New("Log format must be either 'text' or 'json'")
+
 // There is probably a better way to handle log levels
 type LogLevel struct {
 	Name  string
@@ -59,47 +76,255 @@ func ErrorLogLevel() LogLevel {
 	return error
 }
 
+// ParseLogLevel looks up a log level by name, ignoring case
+func ParseLogLevel(name string) (LogLevel, error) {
+	switch strings.ToUpper(name) {
+	case LogLevelDebugName:
+		return DebugLogLevel(), nil
+	case LogLevelInfoName:
+		return InfoLogLevel(), nil
+	case LogLevelWarnName, "WARNING":
+		return WarnLogLevel(), nil
+	case LogLevelErrorName:
+		return ErrorLogLevel(), nil
+	default:
+		return LogLevel{}, ErrUnknownLogLevel
+	}
+}
+
+// LogFormat controls how a CliLogger renders each log line
+type LogFormat string
+
+// ParseLogFormat looks up a log format by name, ignoring case
+func ParseLogFormat(name string) (LogFormat, error) {
+	switch LogFormat(strings.ToLower(name)) {
+	case TextLogFormat:
+		return TextLogFormat, nil
+	case JsonLogFormat:
+		return JsonLogFormat, nil
+	default:
+		return "", ErrUnknownLogFormat
+	}
+}
+
+// Field is a key/value pair attached to a log message
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+func F(key string, value interface{}) Field {
+	field := Field{
+		Key:   key,
+		Value: value,
+	}
+
+	return field
+}
+
+func Version(version string) Field {
+	return F("version", version)
+}
+
+func Direction(direction string) Field {
+	return F("direction", direction)
+}
+
+func Duration(duration time.Duration) Field {
+	return F("duration", duration)
+}
+
 type BaseLogger interface {
 	Println(v ...interface{})
 }
 
 type CliLogger struct {
-	Logger   BaseLogger
-	LogLevel LogLevel
+	Logger     BaseLogger
+	ErrLogger  BaseLogger
+	LogLevel   LogLevel
+	Format     LogFormat
+	Timestamps bool
+	Fields     []Field
+
+	now func() time.Time
 }
 
-func (c CliLogger) log(message string, logLevelValue int) {
-	// TODO: it would be best if this were variadic
-	if c.LogLevel.Value >= logLevelValue {
-		c.Logger.Println(message)
+// Enabled reports whether messages of the given level would be written
+func (c CliLogger) Enabled(logLevel LogLevel) bool {
+	return c.LogLevel.Value >= logLevel.Value
+}
+
+func (c CliLogger) timestamp() time.Time {
+	if c.now != nil {
+		return c.now()
 	}
+
+	return time.Now()
+}
+
+func (c CliLogger) log(logLevel LogLevel, message string, fields []Field) {
+	if !c.Enabled(logLevel) {
+		return
+	}
+
+	allFields := make([]Field, 0, len(c.Fields)+len(fields))
+	allFields = append(allFields, c.Fields...)
+	allFields = append(allFields, fields...)
+
+	var line string
+	if c.Format == JsonLogFormat {
+		line = c.formatJson(logLevel, message, allFields)
+	} else {
+		line = c.formatText(message, allFields)
+	}
+
+	// Errors go to their own writer (stderr for the CLI) when one is configured
+	if logLevel.Value <= LogLevelErrorValue && c.ErrLogger != nil {
+		c.ErrLogger.Println(line)
+		return
+	}
+
+	c.Logger.Println(line)
+}
+
+func (c CliLogger) formatText(message string, fields []Field) string {
+	var b strings.Builder
+
+	if c.Timestamps {
+		b.WriteString(c.timestamp().Format(timestampFormat))
+		b.WriteString(" ")
+	}
+
+	b.WriteString(message)
+
+	for _, field := range fields {
+		b.WriteString(" ")
+		b.WriteString(field.Key)
+		b.WriteString("=")
+		b.WriteString(formatTextValue(field.Value))
+	}
+
+	return b.String()
+}
+
+func formatTextValue(value interface{}) string {
+	var s string
+	switch v := value.(type) {
+	case string:
+		s = v
+	case error:
+		s = v.Error()
+	case fmt.Stringer:
+		s = v.String()
+	default:
+		s = fmt.Sprint(v)
+	}
+
+	if s == "" || strings.ContainsAny(s, " =\"\t\n") {
+		return strconv.Quote(s)
+	}
+
+	return s
+}
+
+func (c CliLogger) formatJson(logLevel LogLevel, message string, fields []Field) string {
+	var b bytes.Buffer
+
+	b.WriteString("{")
+	if c.Timestamps {
+		writeJsonPair(&b, "time", c.timestamp().Format(timestampFormat))
+		b.WriteString(",")
+	}
+	writeJsonPair(&b, "level", logLevel.Name)
+	b.WriteString(",")
+	writeJsonPair(&b, "msg", message)
+
+	for _, field := range fields {
+		b.WriteString(",")
+		writeJsonPair(&b, field.Key, jsonValue(field.Value))
+	}
+	b.WriteString("}")
+
+	return b.String()
+}
+
+func jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case error:
+		return v.Error()
+	case time.Duration:
+		return v.String()
+	default:
+		return v
+	}
+}
+
+func writeJsonPair(b *bytes.Buffer, key string, value interface{}) {
+	encodedKey, _ := json.Marshal(key)
+	encodedValue, err := json.Marshal(value)
+	if err != nil {
+		encodedValue, _ = json.Marshal(fmt.Sprint(value))
+	}
+
+	b.Write(encodedKey)
+	b.WriteString(":")
+	b.Write(encodedValue)
+}
+
+// With returns a copy of the logger which attaches the given fields to every message
+func (c CliLogger) With(fields ...Field) CliLogger {
+	newFields := make([]Field, 0, len(c.Fields)+len(fields))
+	newFields = append(newFields, c.Fields...)
+	newFields = append(newFields, fields...)
+
+	c.Fields = newFields
+
+	return c
+}
+
+func (c CliLogger) Debug(message string, fields ...Field) {
+	c.log(DebugLogLevel(), message, fields)
+}
+
+func (c CliLogger) Info(message string, fields ...Field) {
+	c.log(InfoLogLevel(), message, fields)
+}
+
+func (c CliLogger) Warn(message string, fields ...Field) {
+	c.log(WarnLogLevel(), message, fields)
+}
+
+func (c CliLogger) Error(message string, fields ...Field) {
+	c.log(ErrorLogLevel(), message, fields)
 }
 
-func (c CliLogger) Debug(message string) {
-	c.log(message, LogLevelDebugValue)
+func (c CliLogger) Debugf(format string, v ...interface{}) {
+	c.log(DebugLogLevel(), fmt.Sprintf(format, v...), nil)
 }
 
-func (c CliLogger) Info(message string) {
-	c.log(message, LogLevelInfoValue)
+func (c CliLogger) Infof(format string, v ...interface{}) {
+	c.log(InfoLogLevel(), fmt.Sprintf(format, v...), nil)
 }
 
-func (c CliLogger) Warn(message string) {
-	c.log(message, LogLevelWarnValue)
+func (c CliLogger) Warnf(format string, v ...interface{}) {
+	c.log(WarnLogLevel(), fmt.Sprintf(format, v...), nil)
 }
 
-func (c CliLogger) Error(message string) {
-	c.log(message, LogLevelErrorValue)
+func (c CliLogger) Errorf(format string, v ...interface{}) {
+	c.log(ErrorLogLevel(), fmt.Sprintf(format, v...), nil)
 }
 
 func NewCliLogger(logLevel LogLevel) CliLogger {
-	writer := os.Stdout
 	loggerFlags := 0
 
-	logger := log.New(writer, "", loggerFlags)
+	logger := log.New(os.Stdout, "", loggerFlags)
+	errLogger := log.New(os.Stderr, "", loggerFlags)
 
 	cliLogger := CliLogger{
-		Logger:   logger,
-		LogLevel: logLevel,
+		Logger:    logger,
+		ErrLogger: errLogger,
+		LogLevel:  logLevel,
+		Format:    TextLogFormat,
 	}
 
 	return cliLogger
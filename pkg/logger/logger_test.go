@@ -1,7 +1,11 @@
 package logger
 
 import (
+	"bytes"
+	"errors"
+	"log"
 	"testing"
+	"time"
 
 	"github.com/crgwilson/pgm/pkg/mocks"
 )
@@ -45,6 +49,10 @@ func TestCliLogger(t *testing.T) {
 		testLogger.Warn("warn")
 		testLogger.Error("error")
 
+		if len(spyLogger.Logs) != len(test.ExpectedLogs) {
+			t.Fatalf("got %d logs, want %d", len(spyLogger.Logs), len(test.ExpectedLogs))
+		}
+
 		for i := range spyLogger.Logs {
 			if spyLogger.Logs[i] != test.ExpectedLogs[i] {
 				t.Errorf("got %q, want %q", spyLogger.Logs[i], test.ExpectedLogs[i])
@@ -52,3 +60,139 @@ func TestCliLogger(t *testing.T) {
 		}
 	}
 }
+
+func newBufferLogger(logLevel LogLevel) (CliLogger, *bytes.Buffer, *bytes.Buffer) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+
+	testLogger := CliLogger{
+		Logger:    log.New(stdout, "", 0),
+		ErrLogger: log.New(stderr, "", 0),
+		LogLevel:  logLevel,
+		now: func() time.Time {
+			return time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+		},
+	}
+
+	return testLogger, stdout, stderr
+}
+
+func TestCliLoggerFormatting(t *testing.T) {
+	cases := []struct {
+		Name           string
+		Format         LogFormat
+		Timestamps     bool
+		Log            func(l CliLogger)
+		ExpectedStdout string
+		ExpectedStderr string
+	}{
+		{
+			"printf style",
+			TextLogFormat,
+			false,
+			func(l CliLogger) { l.Infof("migrated %d steps in %s", 3, time.Second) },
+			"migrated 3 steps in 1s\n",
+			"",
+		},
+		{
+			"text fields",
+			TextLogFormat,
+			false,
+			func(l CliLogger) {
+				l.Info("step done", Version("001"), Direction("up"), Duration(1500*time.Millisecond), F("note", "two words"))
+			},
+			"step done version=001 direction=up duration=1.5s note=\"two words\"\n",
+			"",
+		},
+		{
+			"fields attached with With",
+			TextLogFormat,
+			false,
+			func(l CliLogger) { l.With(Version("002")).Warn("careful", Direction("down")) },
+			"careful version=002 direction=down\n",
+			"",
+		},
+		{
+			"errors routed to error logger",
+			TextLogFormat,
+			false,
+			func(l CliLogger) { l.Errorf("failed: %v", "boom") },
+			"",
+			"failed: boom\n",
+		},
+		{
+			"text timestamps",
+			TextLogFormat,
+			true,
+			func(l CliLogger) { l.Info("hello") },
+			"2020-01-02T03:04:05Z hello\n",
+			"",
+		},
+		{
+			"json",
+			JsonLogFormat,
+			false,
+			func(l CliLogger) { l.Info("step done", Version("001"), Duration(time.Second), F("steps", 2)) },
+			"{\"level\":\"INFO\",\"msg\":\"step done\",\"version\":\"001\",\"duration\":\"1s\",\"steps\":2}\n",
+			"",
+		},
+		{
+			"json timestamps and errors",
+			JsonLogFormat,
+			true,
+			func(l CliLogger) { l.Error("failed", F("error", errors.New("boom"))) },
+			"",
+			"{\"time\":\"2020-01-02T03:04:05Z\",\"level\":\"ERROR\",\"msg\":\"failed\",\"error\":\"boom\"}\n",
+		},
+	}
+
+	for _, test := range cases {
+		t.Run(test.Name, func(t *testing.T) {
+			testLogger, stdout, stderr := newBufferLogger(DebugLogLevel())
+			testLogger.Format = test.Format
+			testLogger.Timestamps = test.Timestamps
+
+			test.Log(testLogger)
+
+			if stdout.String() != test.ExpectedStdout {
+				t.Errorf("got %q, want %q", stdout.String(), test.ExpectedStdout)
+			}
+
+			if stderr.String() != test.ExpectedStderr {
+				t.Errorf("got %q, want %q", stderr.String(), test.ExpectedStderr)
+			}
+		})
+	}
+}
+
+func TestParseLogLevel(t *testing.T) {
+	cases := []struct {
+		Input         string
+		ExpectedLevel LogLevel
+		ExpectedError error
+	}{
+		{"debug", DebugLogLevel(), nil},
+		{"INFO", InfoLogLevel(), nil},
+		{"Warn", WarnLogLevel(), nil},
+		{"error", ErrorLogLevel(), nil},
+		{"verbose", LogLevel{}, ErrUnknownLogLevel},
+	}
+
+	for _, test := range cases {
+		t.Run(test.Input, func(t *testing.T) {
+			got, err := ParseLogLevel(test.Input)
+			if err != test.ExpectedError {
+				t.Errorf("got %v, want %v", err, test.ExpectedError)
+			}
+
+			if got != test.ExpectedLevel {
+				t.Errorf("got %v, want %v", got, test.ExpectedLevel)
+			}
+		})
+	}
+
+	_, err := ParseLogFormat("yaml")
+	if err != ErrUnknownLogFormat {
+		t.Errorf("got %v, want %v", err, ErrUnknownLogFormat)
+	}
+}
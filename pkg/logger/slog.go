@@ -0,0 +1,100 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+)
+
+// SlogHandler is a slog.Handler which writes records through a CliLogger, so
+// code written against log/slog shares the CLI's level, format and outputs
+type SlogHandler struct {
+	Logger CliLogger
+	group  string
+}
+
+func slogLevel(level slog.Level) LogLevel {
+	switch {
+	case level >= slog.LevelError:
+		return ErrorLogLevel()
+	case level >= slog.LevelWarn:
+		return WarnLogLevel()
+	case level >= slog.LevelInfo:
+		return InfoLogLevel()
+	default:
+		return DebugLogLevel()
+	}
+}
+
+func (h SlogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return h.Logger.Enabled(slogLevel(level))
+}
+
+func (h SlogHandler) Handle(_ context.Context, record slog.Record) error {
+	fields := make([]Field, 0, record.NumAttrs())
+	record.Attrs(func(attr slog.Attr) bool {
+		fields = append(fields, h.attrFields(h.group, attr)...)
+		return true
+	})
+
+	h.Logger.log(slogLevel(record.Level), record.Message, fields)
+
+	return nil
+}
+
+func (h SlogHandler) attrFields(prefix string, attr slog.Attr) []Field {
+	value := attr.Value.Resolve()
+
+	key := attr.Key
+	if prefix != "" {
+		key = prefix + "." + key
+	}
+
+	if value.Kind() != slog.KindGroup {
+		return []Field{F(key, value.Any())}
+	}
+
+	// Inline groups have no key of their own
+	if attr.Key == "" {
+		key = prefix
+	}
+
+	fields := make([]Field, 0)
+	for _, groupAttr := range value.Group() {
+		fields = append(fields, h.attrFields(key, groupAttr)...)
+	}
+
+	return fields
+}
+
+func (h SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	fields := make([]Field, 0, len(attrs))
+	for _, attr := range attrs {
+		fields = append(fields, h.attrFields(h.group, attr)...)
+	}
+
+	h.Logger = h.Logger.With(fields...)
+
+	return h
+}
+
+func (h SlogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	if h.group != "" {
+		name = h.group + "." + name
+	}
+	h.group = name
+
+	return h
+}
+
+// NewSlogLogger returns a *slog.Logger which writes through the given CliLogger
+func NewSlogLogger(c CliLogger) *slog.Logger {
+	handler := SlogHandler{
+		Logger: c,
+	}
+
+	return slog.New(handler)
+}
@@ -0,0 +1,24 @@
+package logger
+
+import (
+	"testing"
+)
+
+func TestSlogHandler(t *testing.T) {
+	testLogger, stdout, stderr := newBufferLogger(InfoLogLevel())
+
+	slogger := NewSlogLogger(testLogger).With("component", "billing").WithGroup("step")
+	slogger.Debug("hidden")
+	slogger.Info("applied", "version", "003", "direction", "up")
+	slogger.Error("failed", "version", "004")
+
+	wantStdout := "applied component=billing step.version=003 step.direction=up\n"
+	if stdout.String() != wantStdout {
+		t.Errorf("got %q, want %q", stdout.String(), wantStdout)
+	}
+
+	wantStderr := "failed component=billing step.version=004\n"
+	if stderr.String() != wantStderr {
+		t.Errorf("got %q, want %q", stderr.String(), wantStderr)
+	}
+}
@@ -0,0 +1,305 @@
+// Package metrics exposes how migrations are going in the Prometheus text
+// format, to be scraped, pushed to a Pushgateway or picked up by the node
+// exporter's textfile collector
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/crgwilson/pgm/pkg/logger"
+	"github.com/crgwilson/pgm/pkg/migrate"
+)
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var ErrPushFailed = errors.New("Pushgateway refused the metrics")
+
+// DefaultBuckets are the upper bounds, in seconds, of the step duration
+// histogram. Migrations range from milliseconds to hours.
+var DefaultBuckets = []float64{0.1, 0.5, 1, 5, 15, 60, 300, 900, 3600}
+
+// series identifies a metric by its labels
+type series struct {
+	Component string
+	Direction string
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Registry holds the metrics of every manager it is attached to
+type Registry struct {
+	Buckets []float64
+
+	mu        sync.Mutex
+	versions  map[string]float64
+	pending   map[string]float64
+	durations map[series]*histogram
+	failures  map[series]float64
+}
+
+func NewRegistry() *Registry {
+	registry := Registry{
+		Buckets:   DefaultBuckets,
+		versions:  make(map[string]float64),
+		pending:   make(map[string]float64),
+		durations: make(map[series]*histogram),
+		failures:  make(map[series]float64),
+	}
+
+	return &registry
+}
+
+// Hooks returns hooks which time every step the manager runs, count its
+// failures and bring the version and pending gauges up to date after each run.
+// Failing to read the gauges only warns, since the run itself has finished.
+func (r *Registry) Hooks(m *migrate.MigrationManager) migrate.Hooks {
+	return migrate.Hooks{
+		AfterStep: func(step migrate.StepInfo) error {
+			r.observeStep(m.Component, step)
+			return nil
+		},
+		AfterRun: func(run migrate.RunInfo) error {
+			err := r.Observe(m)
+			if err != nil {
+				m.Logger.Warn("Unable to update schema version metrics", logger.F("error", err))
+			}
+
+			return nil
+		},
+	}
+}
+
+func (r *Registry) observeStep(component string, step migrate.StepInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := series{Component: component, Direction: step.Direction}
+	if step.Err != nil {
+		r.failures[key]++
+		return
+	}
+
+	h, ok := r.durations[key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(r.Buckets))}
+		r.durations[key] = h
+	}
+
+	seconds := step.Duration.Seconds()
+	for i, bound := range r.Buckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// Observe reads the manager's current version and how many migrations are
+// pending into the gauges
+func (r *Registry) Observe(m *migrate.MigrationManager) error {
+	version, err := m.CurrentVersion()
+	if err != nil {
+		return err
+	}
+
+	pending, err := m.PendingVersions()
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.versions[m.Component] = versionValue(version)
+	r.pending[m.Component] = float64(len(pending))
+
+	return nil
+}
+
+// versionValue turns a version into a gauge value by reading its leading
+// digits, so 042 and 042_add_index are both 42
+func versionValue(version string) float64 {
+	end := 0
+	for end < len(version) && version[end] >= '0' && version[end] <= '9' {
+		end++
+	}
+
+	value, err := strconv.ParseFloat(version[:end], 64)
+	if err != nil {
+		return 0
+	}
+
+	return value
+}
+
+// WriteTo writes every metric in the Prometheus text format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var b bytes.Buffer
+
+	b.WriteString("# HELP pgm_schema_version Current schema version of each component.\n")
+	b.WriteString("# TYPE pgm_schema_version gauge\n")
+	for _, component := range sortedKeys(r.versions) {
+		writeSample(&b, "pgm_schema_version", labels("component", component), r.versions[component])
+	}
+
+	b.WriteString("# HELP pgm_pending_migrations Versioned migrations not yet applied to each component.\n")
+	b.WriteString("# TYPE pgm_pending_migrations gauge\n")
+	for _, component := range sortedKeys(r.pending) {
+		writeSample(&b, "pgm_pending_migrations", labels("component", component), r.pending[component])
+	}
+
+	b.WriteString("# HELP pgm_migration_step_duration_seconds How long successful migration steps took.\n")
+	b.WriteString("# TYPE pgm_migration_step_duration_seconds histogram\n")
+	histograms := make([]series, 0, len(r.durations))
+	for key := range r.durations {
+		histograms = append(histograms, key)
+	}
+	for _, key := range sortSeries(histograms) {
+		h := r.durations[key]
+		base := labels("component", key.Component, "direction", key.Direction)
+		for i, bound := range r.Buckets {
+			le := strconv.FormatFloat(bound, 'g', -1, 64)
+			writeSample(&b, "pgm_migration_step_duration_seconds_bucket", base+","+labels("le", le), float64(h.counts[i]))
+		}
+		writeSample(&b, "pgm_migration_step_duration_seconds_bucket", base+","+labels("le", "+Inf"), float64(h.count))
+		writeSample(&b, "pgm_migration_step_duration_seconds_sum", base, h.sum)
+		writeSample(&b, "pgm_migration_step_duration_seconds_count", base, float64(h.count))
+	}
+
+	b.WriteString("# HELP pgm_migration_failures_total Migration steps which failed.\n")
+	b.WriteString("# TYPE pgm_migration_failures_total counter\n")
+	failures := make([]series, 0, len(r.failures))
+	for key := range r.failures {
+		failures = append(failures, key)
+	}
+	for _, key := range sortSeries(failures) {
+		writeSample(&b, "pgm_migration_failures_total", labels("component", key.Component, "direction", key.Direction), r.failures[key])
+	}
+
+	return b.WriteTo(w)
+}
+
+// ServeHTTP serves the metrics for scraping
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", contentType)
+	r.WriteTo(w)
+}
+
+// WriteTextfile writes the metrics to path for the node exporter's textfile
+// collector. The file is replaced in one go so the collector never reads half
+// of it.
+func (r *Registry) WriteTextfile(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = r.WriteTo(tmp)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Chmod(tmp.Name(), 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Push replaces the metrics held by a Pushgateway for the given job
+func (r *Registry) Push(client *http.Client, gatewayUrl, job string) error {
+	var b bytes.Buffer
+	_, err := r.WriteTo(&b)
+	if err != nil {
+		return err
+	}
+
+	url := strings.TrimSuffix(gatewayUrl, "/") + "/metrics/job/" + job
+	req, err := http.NewRequest(http.MethodPut, url, &b)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%w: %s", ErrPushFailed, resp.Status)
+	}
+
+	return nil
+}
+
+func writeSample(b *bytes.Buffer, name, labels string, value float64) {
+	b.WriteString(name)
+	b.WriteString("{")
+	b.WriteString(labels)
+	b.WriteString("} ")
+	b.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	b.WriteString("\n")
+}
+
+// labels renders name/value pairs as the inside of a label set
+func labels(pairs ...string) string {
+	parts := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, pairs[i]+"=\""+escapeLabel(pairs[i+1])+"\"")
+	}
+
+	return strings.Join(parts, ",")
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func sortSeries(keys []series) []series {
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Component != keys[j].Component {
+			return keys[i].Component < keys[j].Component
+		}
+		return keys[i].Direction < keys[j].Direction
+	})
+
+	return keys
+}
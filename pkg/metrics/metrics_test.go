@@ -0,0 +1,148 @@
+package metrics
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/crgwilson/pgm/pkg/migrate"
+)
+
+var migrations = fstest.MapFS{
+	"001.up.sql": {Data: []byte("SELECT 1;")},
+	"002.up.sql": {Data: []byte("SELECT 1;")},
+	"003.up.sql": {Data: []byte("SELECT 1;")},
+}
+
+func TestRegistry(t *testing.T) {
+	migrator := migrate.NewComponentManager(migrate.NewMemoryMigrationStore(migrate.FailOnVersion("003")), "billing", nil)
+	_, _, err := migrator.LoadFS(migrations)
+	if err == nil {
+		err = migrator.InitDb()
+	}
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	registry := NewRegistry()
+	migrator.AddHooks(registry.Hooks(migrator))
+
+	err = migrator.Up("003")
+	if err != migrate.ErrInjectedMigrationFailure {
+		t.Fatalf("got %v, want %v", err, migrate.ErrInjectedMigrationFailure)
+	}
+
+	var b bytes.Buffer
+	_, err = registry.WriteTo(&b)
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	want := []string{
+		`pgm_schema_version{component="billing"} 2`,
+		`pgm_pending_migrations{component="billing"} 1`,
+		`pgm_migration_step_duration_seconds_bucket{component="billing",direction="up",le="0.1"} 2`,
+		`pgm_migration_step_duration_seconds_bucket{component="billing",direction="up",le="+Inf"} 2`,
+		`pgm_migration_step_duration_seconds_count{component="billing",direction="up"} 2`,
+		`pgm_migration_failures_total{component="billing",direction="up"} 1`,
+	}
+	for _, line := range want {
+		if !strings.Contains(b.String(), line+"\n") {
+			t.Errorf("got %q, want it to contain %q", b.String(), line)
+		}
+	}
+}
+
+func TestRegistryObserveFails(t *testing.T) {
+	migrator := migrate.NewComponentManager(migrate.NewMemoryMigrationStore(), "billing", nil)
+	_, _, err := migrator.LoadFS(migrations)
+	if err == nil {
+		err = migrator.InitDb()
+	}
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	registry := NewRegistry()
+	hooks := registry.Hooks(migrator)
+
+	// The run succeeded even if the gauges can't be read afterwards
+	migrator.Datastore = migrate.NewMemoryMigrationStore()
+	err = hooks.AfterRun(migrate.RunInfo{Direction: "up"})
+	if err != nil {
+		t.Errorf("got %v, want no error", err)
+	}
+}
+
+func TestVersionValue(t *testing.T) {
+	tests := map[string]float64{
+		"042":               42,
+		"20240501_accounts": 20240501,
+		"baseline":          0,
+	}
+
+	for version, want := range tests {
+		got := versionValue(version)
+		if got != want {
+			t.Errorf("got %v for %q, want %v", got, version, want)
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	migrator := migrate.NewComponentManager(migrate.NewMemoryMigrationStore(), "billing", nil)
+	_, _, err := migrator.LoadFS(migrations)
+	if err == nil {
+		err = migrator.InitDb()
+	}
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+	registry := NewRegistry()
+
+	err = registry.Observe(migrator)
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	var pushed string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.URL.Path != "/metrics/job/pgm" {
+			t.Errorf("got %s %s, want PUT /metrics/job/pgm", r.Method, r.URL.Path)
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		pushed = string(body)
+	}))
+	defer server.Close()
+
+	err = registry.Push(server.Client(), server.URL+"/", "pgm")
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	if !strings.Contains(pushed, `pgm_pending_migrations{component="billing"} 3`) {
+		t.Errorf("got %q, want the pending gauge", pushed)
+	}
+
+	path := filepath.Join(t.TempDir(), "pgm.prom")
+	err = registry.WriteTextfile(path)
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	written, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	if string(written) != pushed {
+		t.Errorf("got %q, want %q", written, pushed)
+	}
+}
@@ -0,0 +1,36 @@
+package migrate
+
+// DatabaseAheadError is returned when the database has been migrated past
+// every version this manager knows about, usually by a newer release
+type DatabaseAheadError struct {
+	CurrentVersion string
+	LatestVersion  string
+}
+
+func (e *DatabaseAheadError) Error() string {
+	return "Database is at schema version " + e.CurrentVersion + ", which is newer than the latest known migration " + e.LatestVersion
+}
+
+func (e *DatabaseAheadError) Unwrap() error {
+	return ErrDatabaseAhead
+}
+
+// databaseAhead reports whether a database at version current has been
+// migrated past every version this manager knows about
+func (m *MigrationManager) databaseAhead(current string) bool {
+	if len(m.SchemaVersions) == 0 || m.isKnownVersion(current) {
+		return false
+	}
+
+	return current > m.HighestAvailableVersion()
+}
+
+// checkAhead returns a DatabaseAheadError if the database is at a version
+// newer than any this manager knows about
+func (m *MigrationManager) checkAhead(current string) error {
+	if !m.databaseAhead(current) {
+		return nil
+	}
+
+	return &DatabaseAheadError{CurrentVersion: current, LatestVersion: m.HighestAvailableVersion()}
+}
@@ -0,0 +1,66 @@
+package migrate
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDatabaseAhead(t *testing.T) {
+	testMigrator, db := newTestMigrator(t, testPaths)
+
+	err := testMigrator.Up("003")
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	// An older release which only knows about the first two versions
+	older := NewMigrationManager(db, nil)
+	for _, version := range []string{"001", "002"} {
+		for _, action := range []string{"up", "down"} {
+			err := older.RegisterMigrationPath(MigrationPath{Version: version, Action: action, Raw: []byte(version + action)})
+			if err != nil {
+				t.Fatalf("got %v, want no error", err)
+			}
+		}
+	}
+
+	want := "Database is at schema version 003, which is newer than the latest known migration 002"
+
+	var aheadErr *DatabaseAheadError
+	err = older.Up("002")
+	if !errors.As(err, &aheadErr) || !errors.Is(err, ErrDatabaseAhead) || err.Error() != want {
+		t.Errorf("got %v, want %q", err, want)
+	}
+
+	err = older.Down("001")
+	if !errors.Is(err, ErrDatabaseAhead) {
+		t.Errorf("got %v, want %v", err, ErrDatabaseAhead)
+	}
+
+	status, err := older.Status()
+	if err != nil || !status.Ahead || status.LatestVersion != "002" {
+		t.Errorf("got %v %v %v, want the status to say the database is ahead of 002", status.Ahead, status.LatestVersion, err)
+	}
+
+	older.TolerateAhead = true
+	err = older.Up("002")
+	if err != nil {
+		t.Errorf("got %v, want no error", err)
+	}
+
+	// Down still can't revert versions it has no scripts for
+	err = older.Down("001")
+	if !errors.Is(err, ErrDatabaseAhead) {
+		t.Errorf("got %v, want %v", err, ErrDatabaseAhead)
+	}
+
+	version, _ := older.CurrentVersion()
+	if version != "003" {
+		t.Errorf("got %v, want 003", version)
+	}
+
+	status, _ = testMigrator.Status()
+	if status.Ahead {
+		t.Errorf("got ahead, want the newer release to be up to date")
+	}
+}
@@ -0,0 +1,352 @@
+package migrate
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+
+	"github.com/crgwilson/pgm/pkg/lint"
+	"github.com/crgwilson/pgm/pkg/logger"
+)
+
+// Section is part of a migration script. Most scripts are a single section
+// which runs once, but a "-- +pgm batch size=N" directive anywhere in a script
+// makes the statement after it a section of its own, which is run over and
+// over, committing after each run, until it affects no rows:
+//
+//	-- +pgm Batch size=5000
+//	UPDATE account SET region = 'eu'
+//	WHERE id IN (SELECT id FROM account WHERE region IS NULL LIMIT $1);
+//
+// The batch size is passed to the statement as $1, if it uses it. Each of the
+// sections between batches runs in a transaction of its own, but as batches
+// commit as they go, a script with batches is never applied as a whole in one
+// transaction. A failure part way through leaves the sections before it
+// applied, and running the migration again starts from the one which failed,
+// as long as the script hasn't changed in the meantime.
+type Section struct {
+	Sql string
+	// BatchSize is zero for sections which run once
+	BatchSize int
+}
+
+// txBeginner is implemented by *sql.DB
+type txBeginner interface {
+	Begin() (*sql.Tx, error)
+}
+
+// BatchProgress reports on a batched section after each batch
+type BatchProgress struct {
+	// Section counts from 0, in the order sections appear in the script
+	Section int
+	// Batch counts the batches run since the migration last started
+	Batch int
+	// Rows is how many rows the latest batch affected
+	Rows int64
+	// Total is how many rows every batch of the section has affected so far,
+	// including any from before the migration was resumed
+	Total int64
+}
+
+// SplitSections breaks a script into its sections. Scripts without batch
+// directives come back as a single section holding the whole script.
+func SplitSections(sql string) ([]Section, error) {
+	sections := make([]Section, 0, 1)
+
+	// Statements are found with the lint package's splitter, so directives
+	// and semicolons inside string literals and comments are left alone
+	rest, cursor := 0, 0
+	for _, statement := range lint.Split(sql) {
+		start := cursor + strings.Index(sql[cursor:], statement.Text)
+		end := start + len(statement.Text)
+		cursor = end
+
+		size, found, err := batchDirective(statement.Comments)
+		if err != nil {
+			return nil, err
+		}
+
+		if !found {
+			continue
+		}
+
+		if before := sql[rest:start]; strings.TrimSpace(before) != "" {
+			sections = append(sections, Section{Sql: before})
+		}
+		sections = append(sections, Section{Sql: sql[start:end], BatchSize: size})
+		rest = end
+	}
+
+	// A directive after the last statement has nothing to batch
+	for _, line := range strings.Split(trailingComments(sql[rest:]), "\n") {
+		directive := parseDirectiveLine(line)
+		if directive != nil && directive.Name == directiveBatch {
+			return nil, ErrInvalidDirective
+		}
+	}
+
+	if strings.TrimSpace(sql[rest:]) != "" || len(sections) == 0 {
+		sections = append(sections, Section{Sql: sql[rest:]})
+	}
+
+	return sections, nil
+}
+
+// batchDirective finds a batch directive among a statement's comments, along
+// with the batch size it gives
+func batchDirective(comments []string) (int, bool, error) {
+	for _, comment := range comments {
+		directive := parseDirectiveLine(comment)
+		if directive == nil || directive.Name != directiveBatch {
+			continue
+		}
+
+		size, err := parseBatchSize(directive.Args)
+		if err != nil {
+			return 0, false, err
+		}
+
+		return size, true, nil
+	}
+
+	return 0, false, nil
+}
+
+// trailingComments returns whatever follows the last statement in sql, which
+// can only be comments and whitespace
+func trailingComments(sql string) string {
+	statements := lint.Split(sql)
+	if len(statements) == 0 {
+		return sql
+	}
+
+	last := statements[len(statements)-1].Text
+	return sql[strings.LastIndex(sql, last)+len(last):]
+}
+
+func parseBatchSize(args []string) (int, error) {
+	if len(args) != 1 || !strings.HasPrefix(strings.ToLower(args[0]), "size=") {
+		return 0, ErrInvalidDirective
+	}
+
+	size, err := strconv.Atoi(args[0][len("size="):])
+	if err != nil || size < 1 {
+		return 0, ErrInvalidDirective
+	}
+
+	return size, nil
+}
+
+// isBatched reports whether any section of a script is batched
+func isBatched(sections []Section) bool {
+	for _, section := range sections {
+		if section.BatchSize > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// batchArgs returns the arguments a batched statement is run with
+func batchArgs(section Section) []interface{} {
+	if section.BatchSize == 0 || !usesBatchSize(section.Sql) {
+		return nil
+	}
+
+	return []interface{}{section.BatchSize}
+}
+
+// usesBatchSize reports whether a statement refers to the $1 parameter,
+// ignoring comments, string literals and other parameters such as $10
+func usesBatchSize(sql string) bool {
+	for _, statement := range lint.Split(sql) {
+		code := statement.Code
+		for i := strings.Index(code, "$1"); i >= 0; i = nextIndex(code, "$1", i) {
+			before := i > 0 && (isIdentChar(code[i-1]) || code[i-1] == '$')
+			after := i+2 < len(code) && code[i+2] >= '0' && code[i+2] <= '9'
+			if !before && !after {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// nextIndex finds the next occurrence of substr in s after the one at i
+func nextIndex(s, substr string, i int) int {
+	next := strings.Index(s[i+1:], substr)
+	if next < 0 {
+		return -1
+	}
+
+	return i + 1 + next
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// execSection runs a section once against db, then calls save with the rows
+// it affected and the connection it ran on. Batches and sections of several
+// statements run in a transaction when db can start one, and save runs in the
+// same transaction, so progress is only recorded along with the changes it
+// describes. Single statements which aren't batches are left out of it, as
+// some, such as CREATE INDEX CONCURRENTLY, can't run in a transaction.
+func execSection(db DatabaseConnection, section Section, save func(db DatabaseConnection, rows int64) error) (int64, error) {
+	beginner, ok := db.(txBeginner)
+	if !ok || (section.BatchSize == 0 && len(lint.Split(section.Sql)) < 2) {
+		rows, err := execOnce(db, section)
+		if err != nil {
+			return 0, err
+		}
+
+		return rows, save(db, rows)
+	}
+
+	tx, err := beginner.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := execOnce(tx, section)
+	if err == nil {
+		err = save(tx, rows)
+	}
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	return rows, tx.Commit()
+}
+
+// execOnce runs a section's SQL, returning the rows it affected if it is a
+// batch
+func execOnce(db DatabaseConnection, section Section) (int64, error) {
+	result, err := db.Exec(section.Sql, batchArgs(section)...)
+	if err != nil {
+		return 0, err
+	}
+
+	if section.BatchSize == 0 {
+		return 0, nil
+	}
+
+	return result.RowsAffected()
+}
+
+// runScript runs a whole script against db, batch by batch if it has any,
+// without recording anything in the migration table
+func runScript(db DatabaseConnection, sql string) error {
+	sections, err := SplitSections(sql)
+	if err != nil {
+		return err
+	}
+
+	if !isBatched(sections) {
+		_, err := db.Exec(sql)
+		return err
+	}
+
+	exec := func(section Section, _ func(rows int64) BatchProgress) (int64, error) {
+		return execSection(db, section, func(DatabaseConnection, int64) error {
+			return nil
+		})
+	}
+
+	return runSections(sections, BatchProgress{}, exec, nil)
+}
+
+// runSections runs each section from the given point on, which lets an
+// interrupted migration carry on where it stopped. exec runs a section once
+// and returns the rows it affected. Given those rows, reached says how far
+// the migration will have got, which exec records along with the changes.
+func runSections(sections []Section, from BatchProgress, exec func(section Section, reached func(rows int64) BatchProgress) (int64, error), report func(BatchProgress)) error {
+	for i := from.Section; i < len(sections); i++ {
+		section := sections[i]
+		progress := BatchProgress{Section: i}
+		if i == from.Section {
+			progress.Total = from.Total
+		}
+
+		// Once a section is done the next one starts from scratch
+		done := BatchProgress{Section: i + 1}
+
+		if section.BatchSize == 0 {
+			_, err := exec(section, func(int64) BatchProgress {
+				return done
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		for section.BatchSize > 0 {
+			rows, err := exec(section, func(rows int64) BatchProgress {
+				if rows == 0 {
+					return done
+				}
+
+				return BatchProgress{Section: i, Total: progress.Total + rows}
+			})
+			if err != nil {
+				return err
+			}
+
+			progress.Batch++
+			progress.Rows = rows
+			progress.Total += rows
+
+			if report != nil {
+				report(progress)
+			}
+
+			if rows == 0 {
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkResumable refuses to resume a migration part way through if its script
+// has changed since the attempt which stopped, as the sections it already ran
+// may no longer be the ones before the resume point. Attempts recorded without
+// a checksum are trusted.
+func checkResumable(from BatchProgress, checksum, want string) error {
+	if from == (BatchProgress{}) || checksum == "" || checksum == want {
+		return nil
+	}
+
+	return ErrResumeScriptChanged
+}
+
+// migrateSchema runs a versioned migration, in batches if its script asks for
+// them
+func (m *MigrationManager) migrateSchema(migration Migration, sql string) error {
+	sections, err := SplitSections(sql)
+	if err != nil {
+		return err
+	}
+
+	if !isBatched(sections) {
+		return m.Datastore.MigrateSchema(migration, sql)
+	}
+
+	report := func(progress BatchProgress) {
+		m.Logger.Info(
+			"Batch finished",
+			logger.Version(migration.Name),
+			logger.Direction(migration.Direction),
+			logger.F("section", progress.Section),
+			logger.F("batch", progress.Batch),
+			logger.F("rows", progress.Rows),
+			logger.F("total", progress.Total),
+		)
+	}
+
+	return m.Datastore.MigrateSections(migration, sections, report)
+}
@@ -0,0 +1,188 @@
+package migrate
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitSections(t *testing.T) {
+	tests := []struct {
+		Name string
+		Sql  string
+		Want []Section
+		Err  error
+	}{
+		{
+			"no batches",
+			"CREATE TABLE a(id INT);\nCREATE TABLE b(id INT);\n",
+			[]Section{{Sql: "CREATE TABLE a(id INT);\nCREATE TABLE b(id INT);\n"}},
+			nil,
+		},
+		{
+			"batch between statements",
+			"ALTER TABLE a ADD COLUMN c INT;\n-- +pgm Batch size=500\nUPDATE a SET c = 1 WHERE id IN (SELECT id FROM a WHERE c IS NULL LIMIT $1);\nALTER TABLE a ALTER COLUMN c SET NOT NULL;\n",
+			[]Section{
+				{Sql: "ALTER TABLE a ADD COLUMN c INT;\n"},
+				{Sql: "-- +pgm Batch size=500\nUPDATE a SET c = 1 WHERE id IN (SELECT id FROM a WHERE c IS NULL LIMIT $1);", BatchSize: 500},
+				{Sql: "\nALTER TABLE a ALTER COLUMN c SET NOT NULL;\n"},
+			},
+			nil,
+		},
+		{
+			"batch only",
+			"-- +pgm batch size=10\nDELETE FROM a WHERE id IN (SELECT id FROM a LIMIT 10);\n",
+			[]Section{
+				{Sql: "-- +pgm batch size=10\nDELETE FROM a WHERE id IN (SELECT id FROM a LIMIT 10);", BatchSize: 10},
+			},
+			nil,
+		},
+		{
+			"directive in a literal",
+			"INSERT INTO note VALUES ('\n-- +pgm batch size=10\n');\nDELETE FROM a;\n",
+			[]Section{{Sql: "INSERT INTO note VALUES ('\n-- +pgm batch size=10\n');\nDELETE FROM a;\n"}},
+			nil,
+		},
+		{"missing size", "-- +pgm batch\nDELETE FROM a;", nil, ErrInvalidDirective},
+		{"zero size", "-- +pgm batch size=0\nDELETE FROM a;", nil, ErrInvalidDirective},
+		{"no statement", "SELECT 1;\n-- +pgm batch size=10\n", nil, ErrInvalidDirective},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			got, err := SplitSections(test.Sql)
+			if err != test.Err {
+				t.Fatalf("got %v, want %v", err, test.Err)
+			}
+
+			if !reflect.DeepEqual(got, test.Want) {
+				t.Errorf("got %q, want %q", got, test.Want)
+			}
+		})
+	}
+}
+
+const batchedScript = "ALTER TABLE a ADD COLUMN c INT;\n-- +pgm batch size=2\nUPDATE a SET c = 1 WHERE id IN (SELECT id FROM a WHERE c IS NULL LIMIT $1);\n"
+
+var batchedPaths = []MigrationPath{{Version: "001", Action: "up", Raw: []byte(batchedScript)}}
+
+func TestUsesBatchSize(t *testing.T) {
+	tests := []struct {
+		Name string
+		Sql  string
+		Want bool
+	}{
+		{"parameter", "DELETE FROM a WHERE id IN (SELECT id FROM a LIMIT $1)", true},
+		{"no parameter", "DELETE FROM a WHERE id IN (SELECT id FROM a LIMIT 10)", false},
+		{"tenth parameter", "SELECT $10", false},
+		{"literal", "UPDATE a SET note = 'costs $1'", false},
+		{"comment", "-- LIMIT $1\nDELETE FROM a", false},
+		{"dollar quoted", "DO $$ BEGIN PERFORM $1; END $$", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			got := usesBatchSize(test.Sql)
+			if got != test.Want {
+				t.Errorf("got %v, want %v", got, test.Want)
+			}
+		})
+	}
+}
+
+func TestBatchedMigration(t *testing.T) {
+	_, db := newTestMigrator(t, batchedPaths, WithBatchRows(2, 2, 1))
+
+	var progress []BatchProgress
+	sections, _ := SplitSections(batchedScript)
+	err := db.MigrateSections(Migration{Version: "001", Name: "001", Direction: "up"}, sections, func(p BatchProgress) {
+		progress = append(progress, p)
+	})
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	want := []BatchProgress{
+		{Section: 1, Batch: 1, Rows: 2, Total: 2},
+		{Section: 1, Batch: 2, Rows: 2, Total: 4},
+		{Section: 1, Batch: 3, Rows: 1, Total: 5},
+		{Section: 1, Batch: 4, Rows: 0, Total: 5},
+	}
+	if !reflect.DeepEqual(progress, want) {
+		t.Errorf("got %v, want %v", progress, want)
+	}
+
+	// The column is added once and the update repeated until nothing changes
+	if executed := db.Executed(); len(executed) != 5 {
+		t.Errorf("got %d statements run, want 5", len(executed))
+	}
+
+	version, _ := db.GetCurrentSchemaVersion()
+	if version != "001" {
+		t.Errorf("got %v, want 001", version)
+	}
+}
+
+func TestBatchedMigrationResumes(t *testing.T) {
+	migrator, db := newTestMigrator(t, batchedPaths, WithBatchRows(2, 2, 1), InterruptAfterBatches(2))
+
+	err := migrator.Up("001")
+	if err == nil {
+		t.Fatalf("got no error, want the interrupted batch to fail")
+	}
+
+	history, _ := db.GetMigrationHistory()
+	last := history[len(history)-1]
+	if last.MigrationStatus != "failure" || last.BatchSection != 1 || last.BatchRows != 4 {
+		t.Errorf("got %v section %d rows %d, want failure section 1 rows 4", last.MigrationStatus, last.BatchSection, last.BatchRows)
+	}
+
+	err = migrator.Up("001")
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	history, _ = db.GetMigrationHistory()
+	last = history[len(history)-1]
+	if last.MigrationStatus != "success" || last.BatchSection != 2 {
+		t.Errorf("got %v section %d, want success section 2", last.MigrationStatus, last.BatchSection)
+	}
+
+	// The column was only added by the first attempt
+	added := 0
+	for _, sql := range db.Executed() {
+		if sql == "ALTER TABLE a ADD COLUMN c INT;\n" {
+			added++
+		}
+	}
+	if added != 1 {
+		t.Errorf("got the column added %d times, want 1", added)
+	}
+}
+
+func TestBatchedMigrationScriptChanged(t *testing.T) {
+	migrator, db := newTestMigrator(t, batchedPaths, WithBatchRows(2, 2, 1), InterruptAfterBatches(2))
+
+	err := migrator.Up("001")
+	if err == nil {
+		t.Fatalf("got no error, want the interrupted batch to fail")
+	}
+
+	// The first section has already run, and the edited script no longer
+	// starts with it
+	edited := NewMigrationManager(db, nil)
+	err = edited.RegisterMigrationPath(MigrationPath{Version: "001", Action: "up", Raw: []byte("-- +pgm batch size=2\nDELETE FROM a WHERE id IN (SELECT id FROM a LIMIT $1);\n")})
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	err = edited.Up("001")
+	if err != ErrResumeScriptChanged {
+		t.Errorf("got %v, want %v", err, ErrResumeScriptChanged)
+	}
+
+	// The original script still carries on where it stopped
+	err = migrator.Up("001")
+	if err != nil {
+		t.Errorf("got %v, want no error", err)
+	}
+}
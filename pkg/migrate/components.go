@@ -0,0 +1,195 @@
+package migrate
+
+import (
+	"sort"
+
+	"github.com/crgwilson/pgm/pkg/logger"
+)
+
+// NewComponentManager creates a manager for one component of a database
+// shared by several sets of migrations, e.g. one per service. Each component
+// has its own version line in the migration table, so their version numbers
+// never clash.
+func NewComponentManager(db MigrationStore, component string, l logger.Logger) *MigrationManager {
+	migrator := NewMigrationManager(db.ForComponent(component), l)
+	migrator.Component = component
+
+	return migrator
+}
+
+// Components are the migration managers of every component sharing a
+// database, keyed by component name
+type Components map[string]*MigrationManager
+
+// Names lists the components in the order they are worked on
+func (c Components) Names() []string {
+	names := make([]string, 0, len(c))
+	for name := range c {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Get returns the named component's manager
+func (c Components) Get(name string) (*MigrationManager, error) {
+	migrator, ok := c[name]
+	if !ok {
+		return nil, ErrComponentUnknown
+	}
+
+	return migrator, nil
+}
+
+// InitDb initializes every component's version line
+func (c Components) InitDb() error {
+	for _, name := range c.Names() {
+		err := c[name].InitDb()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Up migrates every component to its highest available version, stopping at
+// the first failure. Pending migrations are applied in the order Plan gives,
+// with each component's consecutive migrations applied in one run.
+func (c Components) Up() error {
+	order, err := c.Plan()
+	if err != nil {
+		return err
+	}
+
+	planned := make(map[string]bool)
+	for i, step := range order {
+		planned[step.Component] = true
+
+		if i+1 < len(order) && order[i+1].Component == step.Component {
+			continue
+		}
+
+		err = c[step.Component].Up(step.Version)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Components with no pending versions may still have repeatable
+	// migrations to apply
+	for _, name := range c.Names() {
+		migrator := c[name]
+		if planned[name] || len(migrator.SchemaVersions) == 0 {
+			continue
+		}
+
+		err = migrator.Up(migrator.HighestAvailableVersion())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Plan orders the pending migrations of every component so that each comes
+// after the ones it requires, along with the earlier versions of its own
+// component. Otherwise components are worked through in name order. A
+// *RequirementError is returned if a requirement is neither pending nor
+// applied, or the requirements are circular.
+func (c Components) Plan() ([]Requirement, error) {
+	pending := make(map[Requirement]bool)
+	previous := make(map[Requirement]Requirement)
+	roots := make([]Requirement, 0)
+	for _, name := range c.Names() {
+		versions, err := c[name].PendingVersions()
+		if err != nil {
+			return nil, err
+		}
+
+		for i, version := range versions {
+			step := Requirement{Component: name, Version: version}
+			pending[step] = true
+			roots = append(roots, step)
+			if i > 0 {
+				previous[step] = Requirement{Component: name, Version: versions[i-1]}
+			}
+		}
+	}
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+
+	order := make([]Requirement, 0, len(roots))
+	state := make(map[Requirement]int)
+
+	var visit func(chain []Requirement) error
+	visit = func(chain []Requirement) error {
+		step := chain[len(chain)-1]
+		switch state[step] {
+		case visited:
+			return nil
+		case visiting:
+			return &RequirementError{Chain: chain, Reason: "which is a circular requirement"}
+		}
+		state[step] = visiting
+
+		migrator := c[step.Component]
+		requirements := migrator.requirements(step.Version)
+		if before, ok := previous[step]; ok {
+			requirements = append([]Requirement{before}, requirements...)
+		}
+
+		for _, required := range requirements {
+			next := append(append([]Requirement{}, chain...), required)
+			if pending[required] {
+				err := visit(next)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			ok, current, err := migrator.requirementApplied(required)
+			if err != nil {
+				return err
+			}
+
+			if !ok {
+				return &RequirementError{Chain: next, Reason: notAppliedReason(required, current)}
+			}
+		}
+
+		state[step] = visited
+		order = append(order, step)
+
+		return nil
+	}
+
+	for _, step := range roots {
+		err := visit([]Requirement{step})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return order, nil
+}
+
+// Status returns the status of every component, keyed by component name
+func (c Components) Status() (map[string]Status, error) {
+	statuses := make(map[string]Status, len(c))
+	for _, name := range c.Names() {
+		status, err := c[name].Status()
+		if err != nil {
+			return nil, err
+		}
+		statuses[name] = status
+	}
+
+	return statuses, nil
+}
@@ -0,0 +1,134 @@
+package migrate
+
+import (
+	"reflect"
+	"testing"
+)
+
+// newTestComponents registers an up script for every version, with the
+// given requirements
+func newTestComponents(t *testing.T, db MigrationStore, requires map[string]map[string]string) Components {
+	components := make(Components)
+	for name, versions := range requires {
+		migrator := NewComponentManager(db, name, nil)
+		for version, required := range versions {
+			sql := "SELECT 1;"
+			if required != "" {
+				sql = "-- +pgm Requires: " + required + "\n" + sql
+			}
+
+			err := migrator.RegisterMigrationPath(MigrationPath{Version: version, Action: "up", Raw: []byte(sql)})
+			if err != nil {
+				t.Fatalf("got %v, want no error", err)
+			}
+		}
+		components[name] = migrator
+	}
+
+	err := components.InitDb()
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	return components
+}
+
+func TestComponents(t *testing.T) {
+	db := NewMemoryMigrationStore()
+	components := newTestComponents(t, db, map[string]map[string]string{
+		"users":   {"001": "", "002": ""},
+		"billing": {"001": "", "002": "", "003": ""},
+	})
+
+	want := []string{"billing", "users"}
+	for i, name := range components.Names() {
+		if name != want[i] {
+			t.Errorf("got %v, want %v", name, want[i])
+		}
+	}
+
+	users, err := components.Get("users")
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	// Migrating one component leaves the others alone
+	err = users.Up("001")
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	billing, _ := components.Get("billing")
+	version, _ := billing.CurrentVersion()
+	if version != "000" {
+		t.Errorf("got %v, want 000", version)
+	}
+
+	err = components.Up()
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	statuses, err := components.Status()
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	for name, want := range map[string]string{"users": "002", "billing": "003"} {
+		if statuses[name].CurrentVersion != want {
+			t.Errorf("got %v for %s, want %v", statuses[name].CurrentVersion, name, want)
+		}
+	}
+
+	// Components share the migration table but not their history
+	history, _ := db.ForComponent("users").GetMigrationHistory()
+	if len(history) != 3 {
+		t.Errorf("got %d rows, want 3", len(history))
+	}
+	for _, row := range history {
+		if row.Component != "users" {
+			t.Errorf("got %v, want users", row.Component)
+		}
+	}
+
+	// The version line of databases without components is separate again
+	_, err = db.GetCurrentSchemaVersion()
+	if err != ErrDatabaseNotInitialized {
+		t.Errorf("got %v, want %v", err, ErrDatabaseNotInitialized)
+	}
+
+	_, err = components.Get("search")
+	if err != ErrComponentUnknown {
+		t.Errorf("got %v, want %v", err, ErrComponentUnknown)
+	}
+}
+
+func TestComponentsUpRunsOncePerComponent(t *testing.T) {
+	components := newTestComponents(t, NewMemoryMigrationStore(), map[string]map[string]string{
+		"billing": {"001": "", "002": "", "003": "users/001"},
+		"users":   {"001": "", "002": ""},
+	})
+
+	runs := make([]string, 0)
+	for _, name := range components.Names() {
+		name := name
+		components[name].AddHooks(Hooks{
+			BeforeRun: func(run RunInfo) error {
+				runs = append(runs, name+" "+run.TargetVersion)
+				return nil
+			},
+		})
+	}
+
+	err := components.Up()
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	// billing/003 has to wait for users/001, otherwise consecutive
+	// migrations of a component are applied together
+	want := []string{"billing 002", "users 001", "billing 003", "users 002"}
+	if !reflect.DeepEqual(runs, want) {
+		t.Errorf("got %v, want %v", runs, want)
+	}
+}
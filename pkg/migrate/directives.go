@@ -0,0 +1,82 @@
+package migrate
+
+import (
+	"bufio"
+	"errors"
+	"strings"
+)
+
+var ErrUnknownDirective = errors.New("Migration contains an unknown -- +pgm directive")
+var ErrInvalidDirective = errors.New("Migration contains a -- +pgm directive with the wrong arguments")
+
+const directivePrefix = "-- +pgm "
+
+const (
+	directiveSquashes = "squashes"
+	directiveTemplate = "template"
+	directiveRequires = "requires"
+	// Batch directives may appear anywhere in a script, see Section
+	directiveBatch = "batch"
+	// Lint suppressions are read by the lint package, see its docs
+	directiveLintIgnore     = "lint-ignore"
+	directiveLintIgnoreFile = "lint-ignore-file"
+)
+
+// Directive is an instruction to pgm embedded in the leading comments of a
+// migration script, e.g.
+//
+//	-- +pgm squashes 001 002 003
+//	-- +pgm Requires: billing/014
+//
+// Directive names are case insensitive and may be followed by a colon.
+type Directive struct {
+	Name string
+	Args []string
+}
+
+// String renders the directive as it appears in a script
+func (d Directive) String() string {
+	return strings.TrimSpace(directivePrefix + d.Name + " " + strings.Join(d.Args, " "))
+}
+
+// ParseDirectives reads every directive from the comments at the top of a
+// script, stopping at the first line of SQL
+func ParseDirectives(sql string) []Directive {
+	directives := make([]Directive, 0)
+
+	scanner := bufio.NewScanner(strings.NewReader(sql))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if !strings.HasPrefix(line, "--") {
+			break
+		}
+
+		directive := parseDirectiveLine(line)
+		if directive != nil {
+			directives = append(directives, *directive)
+		}
+	}
+
+	return directives
+}
+
+// parseDirectiveLine parses a single line of a script, returning nil if it
+// isn't a directive
+func parseDirectiveLine(line string) *Directive {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, directivePrefix) {
+		return nil
+	}
+
+	fields := strings.Fields(strings.TrimPrefix(line, directivePrefix))
+	if len(fields) == 0 {
+		return nil
+	}
+
+	name := strings.ToLower(strings.TrimSuffix(fields[0], ":"))
+	return &Directive{Name: name, Args: fields[1:]}
+}
@@ -0,0 +1,54 @@
+package migrate
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDirectives(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Sql      string
+		Expected []Directive
+	}{
+		{"no directives", "CREATE TABLE a();", []Directive{}},
+		{"directive", "-- +pgm squashes 001 002\nCREATE TABLE a();", []Directive{{"squashes", []string{"001", "002"}}}},
+		{"after other comments", "-- Accounts\n\n-- +pgm squashes 001\nSELECT 1;", []Directive{{"squashes", []string{"001"}}}},
+		{"after sql", "SELECT 1;\n-- +pgm squashes 001", []Directive{}},
+		{"capitalised with colon", "-- +pgm Requires: billing/014\nSELECT 1;", []Directive{{"requires", []string{"billing/014"}}}},
+	}
+
+	for _, test := range cases {
+		t.Run(test.Name, func(t *testing.T) {
+			got := ParseDirectives(test.Sql)
+			if !reflect.DeepEqual(got, test.Expected) {
+				t.Errorf("got %v, want %v", got, test.Expected)
+			}
+		})
+	}
+}
+
+func TestSetActionDirectives(t *testing.T) {
+	cases := []struct {
+		Name          string
+		Sql           string
+		ExpectedError error
+	}{
+		{"squashes", "-- +pgm squashes 001 002\nSELECT 1;", nil},
+		{"squashes nothing", "-- +pgm squashes\nSELECT 1;", ErrInvalidDirective},
+		{"requires", "-- +pgm Requires: billing/014 002\nSELECT 1;", nil},
+		{"requires nothing", "-- +pgm requires\nSELECT 1;", ErrInvalidDirective},
+		{"requires empty version", "-- +pgm requires billing/\nSELECT 1;", ErrInvalidDirective},
+		{"unknown", "-- +pgm frobnicate\nSELECT 1;", ErrUnknownDirective},
+	}
+
+	for _, test := range cases {
+		t.Run(test.Name, func(t *testing.T) {
+			schema := NewSchemaVersion("002")
+			err := schema.SetAction("up", test.Sql)
+			if err != test.ExpectedError {
+				t.Errorf("got %v, want %v", err, test.ExpectedError)
+			}
+		})
+	}
+}
@@ -11,3 +11,18 @@ var ErrSchemaVersionUnknown = errors.New("Given schema has not been registered")
 var ErrNoNextStep = errors.New("Given schema version has no further steps")
 var ErrAlreadyReachedTargetVersion = errors.New("Requested schema version has already been deployed")
 var ErrNoCurrentVersion = errors.New("No migrations have been run on this database")
+var ErrDatabaseAlreadyMigrated = errors.New("Migrations have already been run on this database")
+var ErrBelowBaseline = errors.New("Requested schema version is below the version this database was baselined at")
+var ErrMigrationLocked = errors.New("Another migration is already running against this database")
+var ErrInvalidSchemaSnapshot = errors.New("Schema snapshot has no version header, it must be written by pgm dump-schema")
+var ErrSquashPartiallyApplied = errors.New("Only some of the migrations squashed into one version have been applied, finish applying them with the original files first")
+var ErrNothingToSquash = errors.New("At least two schema versions are needed to squash")
+var ErrLintFailed = errors.New("Pending migrations failed linting")
+var ErrComponentUnknown = errors.New("Given component has not been registered")
+var ErrUnsatisfiedRequirement = errors.New("Migration requires another migration which has not been applied")
+var ErrInvalidTemplate = errors.New("Unable to render templated migration")
+var ErrWaitTimedOut = errors.New("Timed out waiting for the database to be migrated")
+var ErrNoMigrations = errors.New("No migrations were found")
+var ErrDownNotAllowed = errors.New("Reaching the target version would revert migrations, which has not been allowed")
+var ErrDatabaseAhead = errors.New("Database has been migrated past every known migration")
+var ErrResumeScriptChanged = errors.New("Migration script has changed since it was interrupted part way through, restore it to carry on where it stopped")
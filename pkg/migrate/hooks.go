@@ -0,0 +1,200 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	sqlHookBeforeAll  = "beforeAll.sql"
+	sqlHookBeforeEach = "beforeEach.sql"
+	sqlHookAfterEach  = "afterEach.sql"
+	sqlHookAfterAll   = "afterAll.sql"
+)
+
+var ErrUnknownSqlHook = errors.New("Provided file name is not a known SQL hook")
+
+// RunInfo describes a whole Up or Down run. Steps, Slowest, Duration and Err
+// are only populated once the run has finished.
+type RunInfo struct {
+	Direction     string
+	FromVersion   string
+	TargetVersion string
+	// Planned is how many steps the run expected to take when it started
+	Planned  int
+	Steps    int
+	Slowest  StepInfo
+	Duration time.Duration
+	Err      error
+}
+
+// StepInfo describes a single migration step. Version is the schema version
+// whose script is being run, so for "down" steps it is the version being
+// reverted, and for repeatable migrations it is the migration's name.
+// Duration and Err are only populated once the step has finished.
+type StepInfo struct {
+	Version    string
+	Direction  string
+	Repeatable bool
+	// Index counts the steps of a run from 1
+	Index    int
+	Duration time.Duration
+	Err      error
+}
+
+// Hooks are callbacks invoked around the migration lifecycle. Any of them may
+// be left nil. An error returned from a "before" hook aborts the run before
+// anything else happens, and an error from an "after" hook is returned once
+// the migration itself has been recorded.
+type Hooks struct {
+	BeforeRun  func(run RunInfo) error
+	AfterRun   func(run RunInfo) error
+	BeforeStep func(step StepInfo) error
+	AfterStep  func(step StepInfo) error
+	OnFailure  func(step StepInfo)
+}
+
+type hookList []Hooks
+
+func (l hookList) beforeRun(run RunInfo) error {
+	for _, h := range l {
+		if h.BeforeRun == nil {
+			continue
+		}
+
+		err := h.BeforeRun(run)
+		if err != nil {
+			return fmt.Errorf("before run hook failed: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (l hookList) afterRun(run RunInfo) error {
+	for _, h := range l {
+		if h.AfterRun == nil {
+			continue
+		}
+
+		err := h.AfterRun(run)
+		if err != nil {
+			return fmt.Errorf("after run hook failed: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (l hookList) beforeStep(step StepInfo) error {
+	for _, h := range l {
+		if h.BeforeStep == nil {
+			continue
+		}
+
+		err := h.BeforeStep(step)
+		if err != nil {
+			return fmt.Errorf("before step hook failed for version %s: %w", step.Version, err)
+		}
+	}
+
+	return nil
+}
+
+func (l hookList) afterStep(step StepInfo) error {
+	for _, h := range l {
+		if h.AfterStep == nil {
+			continue
+		}
+
+		err := h.AfterStep(step)
+		if err != nil {
+			return fmt.Errorf("after step hook failed for version %s: %w", step.Version, err)
+		}
+	}
+
+	return nil
+}
+
+func (l hookList) onFailure(step StepInfo) {
+	for _, h := range l {
+		if h.OnFailure != nil {
+			h.OnFailure(step)
+		}
+	}
+}
+
+// SqlHooks holds the contents of the SQL hook files found alongside the
+// migration scripts (beforeAll.sql, beforeEach.sql, afterEach.sql and
+// afterAll.sql)
+type SqlHooks struct {
+	BeforeAll  string
+	BeforeEach string
+	AfterEach  string
+	AfterAll   string
+}
+
+func IsSqlHookFile(sqlFileName string) bool {
+	switch sqlFileName {
+	case sqlHookBeforeAll, sqlHookBeforeEach, sqlHookAfterEach, sqlHookAfterAll:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *SqlHooks) Register(sqlFileName string, sqlFileContents []byte) error {
+	sqlText := string(sqlFileContents)
+
+	switch sqlFileName {
+	case sqlHookBeforeAll:
+		s.BeforeAll = sqlText
+	case sqlHookBeforeEach:
+		s.BeforeEach = sqlText
+	case sqlHookAfterEach:
+		s.AfterEach = sqlText
+	case sqlHookAfterAll:
+		s.AfterAll = sqlText
+	default:
+		return ErrUnknownSqlHook
+	}
+
+	return nil
+}
+
+// Hooks returns lifecycle hooks which run the registered SQL against db. The
+// "after" scripts only run when the step or run they follow succeeded.
+func (s SqlHooks) Hooks(db DatabaseConnection) Hooks {
+	exec := func(sqlText string) error {
+		if sqlText == "" {
+			return nil
+		}
+
+		_, err := db.Exec(sqlText)
+		return err
+	}
+
+	hooks := Hooks{
+		BeforeRun: func(run RunInfo) error {
+			return exec(s.BeforeAll)
+		},
+		AfterRun: func(run RunInfo) error {
+			if run.Err != nil {
+				return nil
+			}
+			return exec(s.AfterAll)
+		},
+		BeforeStep: func(step StepInfo) error {
+			return exec(s.BeforeEach)
+		},
+		AfterStep: func(step StepInfo) error {
+			if step.Err != nil {
+				return nil
+			}
+			return exec(s.AfterEach)
+		},
+	}
+
+	return hooks
+}
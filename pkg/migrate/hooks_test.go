@@ -0,0 +1,178 @@
+package migrate
+
+import (
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func recordingHooks(events *[]string) Hooks {
+	return Hooks{
+		BeforeRun: func(run RunInfo) error {
+			*events = append(*events, "beforeRun "+run.Direction+" "+run.FromVersion+"->"+run.TargetVersion)
+			return nil
+		},
+		AfterRun: func(run RunInfo) error {
+			status := "ok"
+			if run.Err != nil {
+				status = "failed"
+			}
+			*events = append(*events, "afterRun "+status)
+			return nil
+		},
+		BeforeStep: func(step StepInfo) error {
+			*events = append(*events, "beforeStep "+step.Direction+" "+step.Version)
+			return nil
+		},
+		AfterStep: func(step StepInfo) error {
+			*events = append(*events, "afterStep "+step.Direction+" "+step.Version)
+			return nil
+		},
+		OnFailure: func(step StepInfo) {
+			*events = append(*events, "onFailure "+step.Version+" "+step.Err.Error())
+		},
+	}
+}
+
+func TestHooks(t *testing.T) {
+	t.Run("up and down", func(t *testing.T) {
+		testMigrator, _ := newTestMigrator(t, testPaths)
+
+		events := make([]string, 0)
+		testMigrator.AddHooks(recordingHooks(&events))
+
+		err := testMigrator.Up("002")
+		if err != nil {
+			t.Fatalf("got %v, want no error", err)
+		}
+
+		err = testMigrator.Down("001")
+		if err != nil {
+			t.Fatalf("got %v, want no error", err)
+		}
+
+		want := []string{
+			"beforeRun up 000->002",
+			"beforeStep up 001",
+			"afterStep up 001",
+			"beforeStep up 002",
+			"afterStep up 002",
+			"afterRun ok",
+			"beforeRun down 002->001",
+			"beforeStep down 002",
+			"afterStep down 002",
+			"afterRun ok",
+		}
+		if !reflect.DeepEqual(events, want) {
+			t.Errorf("got %v, want %v", events, want)
+		}
+	})
+
+	t.Run("failing step", func(t *testing.T) {
+		testMigrator, _ := newTestMigrator(t, testPaths, FailOnVersion("002"))
+
+		events := make([]string, 0)
+		testMigrator.AddHooks(recordingHooks(&events))
+
+		err := testMigrator.Up("003")
+		if err != ErrInjectedMigrationFailure {
+			t.Fatalf("got %v, want %v", err, ErrInjectedMigrationFailure)
+		}
+
+		want := []string{
+			"beforeRun up 000->003",
+			"beforeStep up 001",
+			"afterStep up 001",
+			"beforeStep up 002",
+			"onFailure 002 Injected migration failure",
+			"afterStep up 002",
+			"afterRun failed",
+		}
+		if !reflect.DeepEqual(events, want) {
+			t.Errorf("got %v, want %v", events, want)
+		}
+	})
+
+	t.Run("before hook aborts run", func(t *testing.T) {
+		testMigrator, _ := newTestMigrator(t, testPaths)
+
+		hookErr := errors.New("not today")
+		testMigrator.AddHooks(Hooks{
+			BeforeStep: func(step StepInfo) error {
+				return hookErr
+			},
+		})
+
+		err := testMigrator.Up("003")
+		if !errors.Is(err, hookErr) {
+			t.Errorf("got %v, want %v", err, hookErr)
+		}
+
+		version, _ := testMigrator.CurrentVersion()
+		if version != "000" {
+			t.Errorf("got %q, want %q", version, "000")
+		}
+	})
+}
+
+type mockConnection struct {
+	queries []string
+}
+
+func (c *mockConnection) Exec(query string, args ...interface{}) (sql.Result, error) {
+	c.queries = append(c.queries, query)
+	return nil, nil
+}
+
+func (c *mockConnection) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return nil, nil
+}
+
+func (c *mockConnection) QueryRow(query string, args ...interface{}) *sql.Row {
+	return nil
+}
+
+func TestSqlHooks(t *testing.T) {
+	sqlHooks := SqlHooks{}
+	for _, name := range []string{"beforeAll.sql", "beforeEach.sql", "afterEach.sql", "afterAll.sql"} {
+		if !IsSqlHookFile(name) {
+			t.Errorf("expected %q to be a hook file", name)
+		}
+
+		err := sqlHooks.Register(name, []byte(name))
+		if err != nil {
+			t.Errorf("got %v, want no error", err)
+		}
+	}
+
+	if IsSqlHookFile("001.up.sql") {
+		t.Errorf("expected 001.up.sql not to be a hook file")
+	}
+
+	err := sqlHooks.Register("001.up.sql", []byte(""))
+	if err != ErrUnknownSqlHook {
+		t.Errorf("got %v, want %v", err, ErrUnknownSqlHook)
+	}
+
+	conn := &mockConnection{}
+	testMigrator, _ := newTestMigrator(t, testPaths)
+	testMigrator.AddHooks(sqlHooks.Hooks(conn))
+
+	err = testMigrator.Up("002")
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	want := []string{
+		"beforeAll.sql",
+		"beforeEach.sql",
+		"afterEach.sql",
+		"beforeEach.sql",
+		"afterEach.sql",
+		"afterAll.sql",
+	}
+	if !reflect.DeepEqual(conn.queries, want) {
+		t.Errorf("got %v, want %v", conn.queries, want)
+	}
+}
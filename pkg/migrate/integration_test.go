@@ -0,0 +1,500 @@
+package migrate
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/crgwilson/pgm/pkg/catalog"
+	"github.com/crgwilson/pgm/pkg/pg"
+	"github.com/crgwilson/pgm/pkg/pgtest"
+)
+
+func TestMain(m *testing.M) {
+	pgtest.Main(m)
+}
+
+var integrationMigrations = []MigrationPath{
+	{Version: "001", Action: "up", Raw: []byte("CREATE TABLE account(id SERIAL PRIMARY KEY)")},
+	{Version: "001", Action: "down", Raw: []byte("DROP TABLE account")},
+	{Version: "002", Action: "up", Raw: []byte("ALTER TABLE account ADD COLUMN name TEXT")},
+	{Version: "002", Action: "down", Raw: []byte("ALTER TABLE account DROP COLUMN name")},
+	{Version: "003", Action: "up", Raw: []byte("CREATE INDEX account_name ON account(name)")},
+	{Version: "003", Action: "down", Raw: []byte("DROP INDEX account_name")},
+}
+
+func newIntegrationMigrator(t *testing.T, db *sql.DB, paths []MigrationPath) *MigrationManager {
+	t.Helper()
+
+	testMigrator := NewMigrationManager(NewSchemaMigrationStore(db), nil)
+	for _, path := range paths {
+		err := testMigrator.RegisterMigrationPath(path)
+		if err != nil {
+			t.Fatalf("got %v, want no error", err)
+		}
+	}
+
+	return testMigrator
+}
+
+func assertVersion(t *testing.T, m *MigrationManager, want string) {
+	t.Helper()
+
+	got, err := m.CurrentVersion()
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	if got != want {
+		t.Errorf("got version %q, want %q", got, want)
+	}
+}
+
+func columnExists(t *testing.T, db *sql.DB, table, column string) bool {
+	t.Helper()
+
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM information_schema.columns WHERE table_name=$1 AND column_name=$2", table, column).Scan(&count)
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	return count > 0
+}
+
+func TestIntegrationInit(t *testing.T) {
+	db, _ := pgtest.NewDatabase(t)
+	testMigrator := newIntegrationMigrator(t, db, integrationMigrations)
+
+	_, err := testMigrator.CurrentVersion()
+	if err != ErrDatabaseNotInitialized {
+		t.Errorf("got %v, want %v", err, ErrDatabaseNotInitialized)
+	}
+
+	// Running init twice must not reset the version
+	for i := 0; i < 2; i++ {
+		err = testMigrator.InitDb()
+		if err != nil {
+			t.Fatalf("got %v, want no error", err)
+		}
+	}
+
+	history, err := testMigrator.Datastore.GetMigrationHistory()
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	if len(history) != 1 {
+		t.Errorf("got %d rows, want 1", len(history))
+	}
+
+	assertVersion(t, testMigrator, "000")
+}
+
+func TestIntegrationUpDown(t *testing.T) {
+	db, _ := pgtest.NewDatabase(t)
+	testMigrator := newIntegrationMigrator(t, db, integrationMigrations)
+
+	err := testMigrator.InitDb()
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	err = testMigrator.Up("003")
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+	assertVersion(t, testMigrator, "003")
+
+	if !columnExists(t, db, "account", "name") {
+		t.Errorf("expected account.name to exist after migrating up")
+	}
+
+	err = testMigrator.Down("001")
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+	assertVersion(t, testMigrator, "001")
+
+	if columnExists(t, db, "account", "name") {
+		t.Errorf("expected account.name to be gone after migrating down")
+	}
+
+	status, err := testMigrator.Status()
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	wantStates := []string{StatusApplied, StatusPending, StatusPending}
+	for i, v := range status.Versions {
+		if v.State != wantStates[i] {
+			t.Errorf("got %q for version %s, want %q", v.State, v.Version, wantStates[i])
+		}
+	}
+}
+
+func TestIntegrationFailure(t *testing.T) {
+	db, _ := pgtest.NewDatabase(t)
+
+	paths := append([]MigrationPath{}, integrationMigrations[:2]...)
+	paths = append(paths, MigrationPath{
+		Version: "002",
+		Action:  "up",
+		Raw:     []byte("ALTER TABLE account ADD COLUMN name TEXT; ALTER TABLE missing ADD COLUMN name TEXT"),
+	})
+	testMigrator := newIntegrationMigrator(t, db, paths)
+
+	err := testMigrator.InitDb()
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	err = testMigrator.Up("002")
+	if err == nil {
+		t.Fatalf("expected an error from the broken migration")
+	}
+	assertVersion(t, testMigrator, "001")
+
+	// Statements sent together run in one transaction, so nothing is left behind
+	if columnExists(t, db, "account", "name") {
+		t.Errorf("expected partially applied migration to be rolled back")
+	}
+
+	history, err := testMigrator.Datastore.GetMigrationHistory()
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	last := history[len(history)-1]
+	if last.Name != "002" || last.MigrationStatus != "failure" {
+		t.Errorf("got %+v, want failed row for 002", last)
+	}
+}
+
+func TestIntegrationLock(t *testing.T) {
+	db, config := pgtest.NewDatabase(t)
+
+	// A second pool stands in for a second copy of pgm
+	other, err := sql.Open("postgres", mustConnectionString(t, config))
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+	defer other.Close()
+
+	first := NewSchemaMigrationStore(db)
+	second := NewSchemaMigrationStore(other)
+
+	err = first.Lock()
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	err = second.Lock()
+	if err != ErrMigrationLocked {
+		t.Errorf("got %v, want %v", err, ErrMigrationLocked)
+	}
+
+	err = first.Unlock()
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	err = second.Lock()
+	if err != nil {
+		t.Errorf("got %v, want no error", err)
+	}
+	second.Unlock()
+}
+
+func TestIntegrationRepeatableAndBaseline(t *testing.T) {
+	db, _ := pgtest.NewDatabase(t)
+	testMigrator := newIntegrationMigrator(t, db, integrationMigrations)
+
+	// The schema up to 002 already exists, created by hand
+	_, err := db.Exec("CREATE TABLE account(id SERIAL PRIMARY KEY, name TEXT)")
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	err = testMigrator.Baseline("002")
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	repeatable, _ := ParseRepeatableFile("R__account_names.sql", []byte("CREATE OR REPLACE VIEW account_names AS SELECT name FROM account"))
+	testMigrator.RegisterRepeatableMigration(repeatable)
+
+	err = testMigrator.Up("003")
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+	assertVersion(t, testMigrator, "003")
+
+	checksums, err := testMigrator.Datastore.GetRepeatableChecksums()
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	if checksums["R__account_names"] != repeatable.Checksum {
+		t.Errorf("got %q, want %q", checksums["R__account_names"], repeatable.Checksum)
+	}
+
+	err = testMigrator.Down("001")
+	if err != ErrBelowBaseline {
+		t.Errorf("got %v, want %v", err, ErrBelowBaseline)
+	}
+}
+
+func TestIntegrationLegacyTable(t *testing.T) {
+	db, _ := pgtest.NewDatabase(t)
+
+	// This is the table as created by the first release of pgm
+	_, err := db.Exec(`CREATE TABLE pgm_schema_migration(
+		id SERIAL PRIMARY KEY,
+		version VARCHAR(16) NOT NULL,
+		migration_status VARCHAR(16) DEFAULT 'in progress',
+		last_updated TIMESTAMP NOT NULL DEFAULT NOW()
+	);
+	INSERT INTO pgm_schema_migration(version, migration_status) VALUES('000', 'success'), ('001', 'success'), ('002', 'success');
+	CREATE TABLE account(id SERIAL PRIMARY KEY, name TEXT)`)
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	testMigrator := newIntegrationMigrator(t, db, integrationMigrations)
+	assertVersion(t, testMigrator, "002")
+
+	err = testMigrator.Up("003")
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	err = testMigrator.Down("001")
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+	assertVersion(t, testMigrator, "001")
+}
+
+func mustConnectionString(t *testing.T, config pg.PostgresConfig) string {
+	t.Helper()
+
+	connString, err := config.ConnectionString()
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	return connString
+}
+
+func TestIntegrationRoundTrip(t *testing.T) {
+	db, _ := pgtest.NewDatabase(t)
+
+	// 002 forgets to drop the index it creates
+	paths := append([]MigrationPath{}, integrationMigrations[:2]...)
+	paths = append(paths,
+		MigrationPath{Version: "002", Action: "up", Raw: []byte("CREATE TYPE mood AS ENUM ('happy'); CREATE INDEX account_id ON account(id)")},
+		MigrationPath{Version: "002", Action: "down", Raw: []byte("DROP TYPE mood")},
+	)
+	testMigrator := newIntegrationMigrator(t, db, paths)
+
+	results, err := testMigrator.RoundTrip(db, catalog.Options{})
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2", len(results))
+	}
+
+	if !results[0].Ok() {
+		t.Errorf("got %+v, want version 001 to round trip cleanly", results[0])
+	}
+
+	// Re-running the up script fails because the index is still there
+	if results[1].Err == nil {
+		t.Errorf("got no error, want version 002 to fail being reapplied")
+	}
+
+	if len(results[1].Residue) != 0 {
+		t.Errorf("got residue %v, want none recorded for a failed round trip", results[1].Residue)
+	}
+}
+
+func TestIntegrationRoundTripBatches(t *testing.T) {
+	db, _ := pgtest.NewDatabase(t)
+
+	paths := []MigrationPath{
+		{Version: "001", Action: "up", Raw: []byte("CREATE TABLE account(id SERIAL PRIMARY KEY, region TEXT);\nINSERT INTO account(region) SELECT NULL FROM generate_series(1, 5);\n-- +pgm batch size=2\nUPDATE account SET region = 'eu' WHERE id IN (SELECT id FROM account WHERE region IS NULL LIMIT $1);\nALTER TABLE account ALTER COLUMN region SET NOT NULL;")},
+		{Version: "001", Action: "down", Raw: []byte("DROP TABLE account")},
+	}
+	testMigrator := newIntegrationMigrator(t, db, paths)
+
+	results, err := testMigrator.RoundTrip(db, catalog.Options{})
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	if len(results) != 1 || !results[0].Ok() {
+		t.Errorf("got %+v, want version 001 to round trip cleanly", results)
+	}
+}
+
+func TestIntegrationRoundTripResidue(t *testing.T) {
+	db, _ := pgtest.NewDatabase(t)
+
+	paths := append([]MigrationPath{}, integrationMigrations[:2]...)
+	paths = append(paths,
+		MigrationPath{Version: "002", Action: "up", Raw: []byte("CREATE INDEX IF NOT EXISTS account_id ON account(id)")},
+		MigrationPath{Version: "002", Action: "down", Raw: []byte("SELECT 1")},
+	)
+	testMigrator := newIntegrationMigrator(t, db, paths)
+
+	results, err := testMigrator.RoundTrip(db, catalog.Options{})
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2", len(results))
+	}
+
+	residue := results[1].Residue
+	if len(residue) != 1 || residue[0].Kind != catalog.KindIndex || residue[0].Change != catalog.ChangeAdded {
+		t.Errorf("got residue %v, want the account_id index", residue)
+	}
+}
+
+func TestIntegrationDumpAndLoadSchema(t *testing.T) {
+	source, _ := pgtest.NewDatabase(t)
+	sourceMigrator := newIntegrationMigrator(t, source, integrationMigrations)
+
+	err := sourceMigrator.InitDb()
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	err = sourceMigrator.Up("002")
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	snapshot, err := sourceMigrator.DumpSchema(source)
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	target, _ := pgtest.NewDatabase(t)
+	targetMigrator := newIntegrationMigrator(t, target, integrationMigrations)
+
+	err = targetMigrator.LoadSchema([]byte(snapshot))
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+	assertVersion(t, targetMigrator, "002")
+
+	if !columnExists(t, target, "account", "name") {
+		t.Errorf("expected account.name to exist after loading the snapshot")
+	}
+
+	reloaded, err := targetMigrator.DumpSchema(target)
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	if reloaded != snapshot {
+		t.Errorf("got snapshot:\n%s\nwant:\n%s", reloaded, snapshot)
+	}
+
+	err = targetMigrator.Up("003")
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+	assertVersion(t, targetMigrator, "003")
+}
+
+func TestIntegrationComponents(t *testing.T) {
+	db, _ := pgtest.NewDatabase(t)
+
+	// An existing database without components keeps its version line
+	legacy := newIntegrationMigrator(t, db, integrationMigrations)
+	err := legacy.InitDb()
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	err = legacy.Up("001")
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	billing := NewComponentManager(NewSchemaMigrationStore(db), "billing", nil)
+	for _, path := range []MigrationPath{
+		{Version: "001", Action: "up", Raw: []byte("CREATE TABLE invoice(id SERIAL PRIMARY KEY)")},
+		{Version: "001", Action: "down", Raw: []byte("DROP TABLE invoice")},
+	} {
+		err := billing.RegisterMigrationPath(path)
+		if err != nil {
+			t.Fatalf("got %v, want no error", err)
+		}
+	}
+
+	_, err = billing.CurrentVersion()
+	if err != ErrDatabaseNotInitialized {
+		t.Errorf("got %v, want %v", err, ErrDatabaseNotInitialized)
+	}
+
+	err = billing.InitDb()
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+	assertVersion(t, billing, "000")
+
+	err = billing.Up("001")
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+	assertVersion(t, billing, "001")
+
+	err = legacy.Up("003")
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+	assertVersion(t, legacy, "003")
+	assertVersion(t, billing, "001")
+}
+
+func TestIntegrationReadOnly(t *testing.T) {
+	db, _ := pgtest.NewDatabase(t)
+
+	err := newIntegrationMigrator(t, db, integrationMigrations).InitDb()
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	// ALTER TABLE fails in a read only transaction, so this would catch any
+	// read which tried to upgrade the table
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("SET TRANSACTION READ ONLY")
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	testMigrator := NewMigrationManager(NewSchemaMigrationStore(tx), nil)
+	for _, path := range integrationMigrations {
+		err := testMigrator.RegisterMigrationPath(path)
+		if err != nil {
+			t.Fatalf("got %v, want no error", err)
+		}
+	}
+	assertVersion(t, testMigrator, "000")
+
+	_, err = testMigrator.Status()
+	if err != nil {
+		t.Errorf("got %v, want no error", err)
+	}
+}
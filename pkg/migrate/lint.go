@@ -0,0 +1,82 @@
+package migrate
+
+import (
+	"github.com/crgwilson/pgm/pkg/lint"
+	"github.com/crgwilson/pgm/pkg/logger"
+)
+
+// LintVersions checks the scripts of the given versions, or of every version
+// if none are given. Up scripts are checked in order so that rules can see
+// what earlier ones did, and each down script is checked on its own. Findings
+// name files the way they are laid out on disk, e.g. 042.up.sql.
+func (m *MigrationManager) LintVersions(rules []lint.Rule, versions []string) []lint.Finding {
+	if versions == nil {
+		versions = m.SchemaVersions
+	}
+
+	findings := make([]lint.Finding, 0)
+
+	ups := lint.NewLinter(rules)
+	for _, version := range versions {
+		schema, ok := m.SchemaVersionMap[version]
+		if !ok {
+			continue
+		}
+
+		if schema.Up != "" {
+			findings = append(findings, ups.Lint(version+".up.sql", m.lintable(version+".up.sql", schema.Up))...)
+		}
+
+		if schema.Down != "" {
+			findings = append(findings, lint.NewLinter(rules).Lint(version+".down.sql", m.lintable(version+".down.sql", schema.Down))...)
+		}
+	}
+
+	return findings
+}
+
+// lintable returns the rendered script when it is a template, since that is
+// what will actually run. Templates which can't be rendered, e.g. because not
+// every variable was given, are checked as written.
+func (m *MigrationManager) lintable(name, sql string) string {
+	rendered, err := m.render(name, sql)
+	if err != nil {
+		return sql
+	}
+
+	return rendered
+}
+
+// lintPending refuses to go on if the pending up scripts about to be run on
+// the way to targetVersion break any of the manager's LintRules at error
+// severity
+func (m *MigrationManager) lintPending(targetVersion string, pending []string) error {
+	if len(m.LintRules) == 0 {
+		return nil
+	}
+
+	linter := lint.NewLinter(m.LintRules)
+	failed := false
+	for _, version := range pending {
+		if version > targetVersion {
+			break
+		}
+
+		name := version + ".up.sql"
+		for _, finding := range linter.Lint(name, m.lintable(name, m.SchemaVersionMap[version].Up)) {
+			fields := []logger.Field{logger.Version(version), logger.F("rule", finding.Rule), logger.F("line", finding.Line)}
+			if finding.Severity == lint.SeverityError {
+				failed = true
+				m.Logger.Error(finding.String(), fields...)
+			} else {
+				m.Logger.Warn(finding.String(), fields...)
+			}
+		}
+	}
+
+	if failed {
+		return ErrLintFailed
+	}
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package migrate
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/crgwilson/pgm/pkg/lint"
+)
+
+// lintPaths adds a version with an unsafe up script to testPaths
+var lintPaths = append(append([]MigrationPath{}, testPaths...),
+	MigrationPath{Version: "004", Action: "up", Raw: []byte("-- +pgm lint-ignore-file set-not-null\nALTER TABLE a ALTER COLUMN b SET NOT NULL;\nCREATE INDEX a_b ON a(b);")},
+	MigrationPath{Version: "004", Action: "down", Raw: []byte("DROP INDEX a_b;")},
+)
+
+func TestLintVersions(t *testing.T) {
+	testMigrator, _ := newTestMigrator(t, lintPaths)
+
+	findings := testMigrator.LintVersions(lint.DefaultRules(), nil)
+
+	want := []string{"004.up.sql:3: error: CREATE INDEX without CONCURRENTLY blocks writes to a while the index is built (create-index-not-concurrently)"}
+	got := make([]string, 0)
+	for _, finding := range findings {
+		got = append(got, finding.String())
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestLintBlocksUp(t *testing.T) {
+	testMigrator, db := newTestMigrator(t, lintPaths)
+	testMigrator.LintRules = lint.DefaultRules()
+
+	// Versions before the offending one are fine on their own
+	err := testMigrator.Up("003")
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	err = testMigrator.Up("004")
+	if err != ErrLintFailed {
+		t.Errorf("got %v, want %v", err, ErrLintFailed)
+	}
+
+	if len(db.Executed()) != 3 {
+		t.Errorf("got %d migrations run, want 3", len(db.Executed()))
+	}
+
+	pending, err := testMigrator.PendingVersions()
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	if !reflect.DeepEqual(pending, []string{"004"}) {
+		t.Errorf("got %v, want %v", pending, []string{"004"})
+	}
+}
@@ -0,0 +1,478 @@
+package migrate
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrInjectedMigrationFailure = errors.New("Injected migration failure")
+var ErrInjectedBookkeepingFailure = errors.New("Injected migration table failure")
+
+// MemoryMigrationStore is a MigrationStore which keeps everything in memory.
+// It behaves like SchemaMigrationStore, including history, statuses,
+// checksums and locking, so code built on top of the migrate package can be
+// tested without a PostgreSQL server. Faults can be injected with the
+// MemoryStoreOption functions.
+type MemoryMigrationStore struct {
+	*memoryDatabase
+	component string
+}
+
+// memoryDatabase is the state shared by the stores of every component
+type memoryDatabase struct {
+	mu          sync.Mutex
+	initialized map[string]bool
+	locked      map[string]bool
+	history     []Migration
+	executed    []string
+
+	failVersions    map[string]bool
+	failBookkeeping bool
+	failLock        bool
+
+	batchRows       []int64
+	interruptBatch  int
+	batchesExecuted int
+}
+
+type MemoryStoreOption func(s *MemoryMigrationStore)
+
+// FailOnVersion makes running the migration with the given version, or the
+// repeatable migration with the given name, fail. The failure is recorded in
+// the history the same way a failing SQL script would be.
+func FailOnVersion(version string) MemoryStoreOption {
+	return func(s *MemoryMigrationStore) {
+		s.failVersions[version] = true
+	}
+}
+
+// FailOnBookkeeping makes every write to the migration table fail, before any
+// migration SQL has been run
+func FailOnBookkeeping() MemoryStoreOption {
+	return func(s *MemoryMigrationStore) {
+		s.failBookkeeping = true
+	}
+}
+
+// FailOnLock makes every attempt to take the migration lock fail as though
+// another process were holding it
+func FailOnLock() MemoryStoreOption {
+	return func(s *MemoryMigrationStore) {
+		s.failLock = true
+	}
+}
+
+// WithBatchRows makes successive batches of batched sections report that they
+// affected the given numbers of rows, and every batch after them none
+func WithBatchRows(rows ...int64) MemoryStoreOption {
+	return func(s *MemoryMigrationStore) {
+		s.batchRows = append(s.batchRows, rows...)
+	}
+}
+
+// InterruptAfterBatches makes the batch after the first n fail once, as though
+// pgm had been stopped part way through a batched section
+func InterruptAfterBatches(n int) MemoryStoreOption {
+	return func(s *MemoryMigrationStore) {
+		s.interruptBatch = n + 1
+	}
+}
+
+// WithHistory starts the store off initialized, with the given rows already in
+// its migration table
+func WithHistory(history ...Migration) MemoryStoreOption {
+	return func(s *MemoryMigrationStore) {
+		s.initialized[s.component] = true
+		for _, migration := range history {
+			s.appendMigration(migration)
+		}
+	}
+}
+
+func (s *MemoryMigrationStore) appendMigration(migration Migration) *Migration {
+	migration.Id = len(s.history) + 1
+	migration.Component = s.component
+	if migration.MigrationType == "" {
+		migration.MigrationType = migrationTypeVersioned
+	}
+	if migration.MigrationStatus == "" {
+		migration.MigrationStatus = "success"
+	}
+	if migration.LastUpdated.IsZero() {
+		migration.LastUpdated = time.Now()
+	}
+
+	s.history = append(s.history, migration)
+
+	return &s.history[len(s.history)-1]
+}
+
+// rows returns a copy of the component's rows of the migration table
+func (s *MemoryMigrationStore) rows() []Migration {
+	rows := make([]Migration, 0, len(s.history))
+	for _, migration := range s.history {
+		if migration.Component == s.component {
+			rows = append(rows, migration)
+		}
+	}
+
+	return rows
+}
+
+func (s *MemoryMigrationStore) Init() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.failBookkeeping {
+		return ErrInjectedBookkeepingFailure
+	}
+
+	if !s.initialized[s.component] {
+		s.initialized[s.component] = true
+		s.appendMigration(Migration{Version: "000"})
+	}
+
+	return nil
+}
+
+func (s *MemoryMigrationStore) GetCurrentSchemaVersion() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.initialized[s.component] {
+		return "", ErrDatabaseNotInitialized
+	}
+
+	history := s.rows()
+	for i := len(history) - 1; i >= 0; i-- {
+		migration := history[i]
+		if migration.MigrationStatus != "success" || migration.MigrationType == migrationTypeRepeatable {
+			continue
+		}
+
+		return migration.Version, nil
+	}
+
+	return "", ErrFailedToQuerySchemaVersion
+}
+
+func (s *MemoryMigrationStore) GetMigrationHistory() ([]Migration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.initialized[s.component] {
+		return nil, ErrDatabaseNotInitialized
+	}
+
+	return s.rows(), nil
+}
+
+// run records a migration as in progress, "executes" its SQL and then records
+// the outcome, failing wherever a fault has been injected
+func (s *MemoryMigrationStore) run(migration Migration, script, sql string) error {
+	if !s.initialized[s.component] {
+		return ErrDatabaseNotInitialized
+	}
+
+	if s.failBookkeeping {
+		return ErrInjectedBookkeepingFailure
+	}
+
+	migration.MigrationStatus = "in progress"
+	row := s.appendMigration(migration)
+
+	var migrationErr error
+	if s.failVersions[script] {
+		migrationErr = ErrInjectedMigrationFailure
+		row.MigrationStatus = "failure"
+	} else {
+		s.executed = append(s.executed, sql)
+		row.MigrationStatus = "success"
+	}
+	row.LastUpdated = time.Now()
+
+	return migrationErr
+}
+
+func (s *MemoryMigrationStore) MigrateSchema(migration Migration, sql string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if migration.MigrationType == "" {
+		migration.MigrationType = migrationTypeVersioned
+	}
+
+	return s.run(migration, migration.Name, sql)
+}
+
+func (s *MemoryMigrationStore) MigrateSections(migration Migration, sections []Section, report func(BatchProgress)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.initialized[s.component] {
+		return ErrDatabaseNotInitialized
+	}
+
+	if s.failBookkeeping {
+		return ErrInjectedBookkeepingFailure
+	}
+
+	if migration.MigrationType == "" {
+		migration.MigrationType = migrationTypeVersioned
+	}
+
+	// Carry on from the last attempt if it failed
+	from := BatchProgress{}
+	history := s.rows()
+	for i := len(history) - 1; i >= 0; i-- {
+		row := history[i]
+		if row.Name != migration.Name || row.Direction != migration.Direction {
+			continue
+		}
+
+		if row.MigrationStatus != "success" {
+			from = BatchProgress{Section: row.BatchSection, Total: row.BatchRows}
+
+			err := checkResumable(from, row.Checksum, migration.Checksum)
+			if err != nil {
+				return err
+			}
+		}
+		break
+	}
+
+	migration.MigrationStatus = "in progress"
+	migration.BatchSection = from.Section
+	migration.BatchRows = from.Total
+	row := s.appendMigration(migration)
+
+	run := func(section Section) (int64, error) {
+		if s.failVersions[migration.Name] {
+			return 0, ErrInjectedMigrationFailure
+		}
+
+		if section.BatchSize == 0 {
+			s.executed = append(s.executed, section.Sql)
+			return 0, nil
+		}
+
+		s.batchesExecuted++
+		if s.batchesExecuted == s.interruptBatch {
+			return 0, ErrInjectedMigrationFailure
+		}
+
+		s.executed = append(s.executed, section.Sql)
+		if len(s.batchRows) == 0 {
+			return 0, nil
+		}
+
+		rows := s.batchRows[0]
+		s.batchRows = s.batchRows[1:]
+
+		return rows, nil
+	}
+
+	exec := func(section Section, reached func(rows int64) BatchProgress) (int64, error) {
+		rows, err := run(section)
+		if err != nil {
+			return 0, err
+		}
+
+		progress := reached(rows)
+		row.BatchSection = progress.Section
+		row.BatchRows = progress.Total
+
+		return rows, nil
+	}
+
+	migrationErr := runSections(sections, from, exec, report)
+
+	row.MigrationStatus = "success"
+	if migrationErr != nil {
+		row.MigrationStatus = "failure"
+	}
+	row.LastUpdated = time.Now()
+
+	return migrationErr
+}
+
+func (s *MemoryMigrationStore) GetRepeatableChecksums() (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.initialized[s.component] {
+		return nil, ErrDatabaseNotInitialized
+	}
+
+	checksums := make(map[string]string)
+	for _, migration := range s.rows() {
+		if migration.MigrationType == migrationTypeRepeatable && migration.MigrationStatus == "success" {
+			checksums[migration.Name] = migration.Checksum
+		}
+	}
+
+	return checksums, nil
+}
+
+func (s *MemoryMigrationStore) ApplyRepeatable(name, checksum, sql string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	migration := Migration{
+		MigrationType: migrationTypeRepeatable,
+		Name:          name,
+		Checksum:      checksum,
+	}
+
+	// Like the real store, repeatable rows are stamped with the current version
+	history := s.rows()
+	for i := len(history) - 1; i >= 0; i-- {
+		row := history[i]
+		if row.MigrationStatus == "success" && row.MigrationType != migrationTypeRepeatable {
+			migration.Version = row.Version
+			break
+		}
+	}
+
+	return s.run(migration, name, sql)
+}
+
+func (s *MemoryMigrationStore) Baseline(version string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.checkUnmigrated()
+	if err != nil {
+		return err
+	}
+
+	s.initialized[s.component] = true
+	s.appendMigration(Migration{
+		Version:       version,
+		MigrationType: migrationTypeBaseline,
+	})
+
+	return nil
+}
+
+func (s *MemoryMigrationStore) checkUnmigrated() error {
+	if s.failBookkeeping {
+		return ErrInjectedBookkeepingFailure
+	}
+
+	for _, migration := range s.rows() {
+		if migration.Version != "000" || migration.MigrationType != migrationTypeVersioned {
+			return ErrDatabaseAlreadyMigrated
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryMigrationStore) LoadSnapshot(version, checksum, sql string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.checkUnmigrated()
+	if err != nil {
+		return err
+	}
+	s.initialized[s.component] = true
+
+	migration := Migration{
+		Version:       version,
+		MigrationType: migrationTypeSnapshot,
+		Name:          version,
+		Checksum:      checksum,
+		Direction:     "up",
+	}
+
+	return s.run(migration, version, sql)
+}
+
+func (s *MemoryMigrationStore) GetBaselineVersion() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.initialized[s.component] {
+		return "", ErrDatabaseNotInitialized
+	}
+
+	history := s.rows()
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].MigrationType == migrationTypeBaseline {
+			return history[i].Version, nil
+		}
+	}
+
+	return "", nil
+}
+
+func (s *MemoryMigrationStore) Lock() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.locked[s.component] || s.failLock {
+		return ErrMigrationLocked
+	}
+	s.locked[s.component] = true
+
+	return nil
+}
+
+func (s *MemoryMigrationStore) Unlock() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.locked[s.component] = false
+
+	return nil
+}
+
+// Locked reports whether the migration lock is currently held
+func (s *MemoryMigrationStore) Locked() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.locked[s.component]
+}
+
+// Executed returns the SQL of every migration which has run successfully, in
+// the order it ran
+func (s *MemoryMigrationStore) Executed() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	executed := make([]string, len(s.executed))
+	copy(executed, s.executed)
+
+	return executed
+}
+
+// ForComponent returns a store sharing this one's state which works on the
+// given component's version line
+func (s *MemoryMigrationStore) ForComponent(component string) MigrationStore {
+	return &MemoryMigrationStore{
+		memoryDatabase: s.memoryDatabase,
+		component:      component,
+	}
+}
+
+func NewMemoryMigrationStore(opts ...MemoryStoreOption) *MemoryMigrationStore {
+	s := MemoryMigrationStore{
+		memoryDatabase: &memoryDatabase{
+			initialized:  make(map[string]bool),
+			locked:       make(map[string]bool),
+			history:      make([]Migration, 0),
+			executed:     make([]string, 0),
+			failVersions: make(map[string]bool),
+		},
+	}
+
+	for _, opt := range opts {
+		opt(&s)
+	}
+
+	return &s
+}
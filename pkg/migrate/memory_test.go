@@ -0,0 +1,151 @@
+package migrate
+
+import (
+	"testing"
+)
+
+var _ MigrationStore = &MemoryMigrationStore{}
+var _ MigrationStore = &SchemaMigrationStore{}
+
+// testPaths registers versions 001 to 003, each of whose scripts is just its
+// version and action
+var testPaths = []MigrationPath{
+	{Version: "001", Action: "up", Raw: []byte("001up")},
+	{Version: "001", Action: "down", Raw: []byte("001down")},
+	{Version: "002", Action: "up", Raw: []byte("002up")},
+	{Version: "002", Action: "down", Raw: []byte("002down")},
+	{Version: "003", Action: "up", Raw: []byte("003up")},
+	{Version: "003", Action: "down", Raw: []byte("003down")},
+}
+
+// newTestMigrator returns a manager with the given scripts registered,
+// migrating an initialized memory store
+func newTestMigrator(t *testing.T, paths []MigrationPath, opts ...MemoryStoreOption) (*MigrationManager, *MemoryMigrationStore) {
+	t.Helper()
+
+	db := NewMemoryMigrationStore(opts...)
+	testMigrator := NewMigrationManager(db, nil)
+
+	err := testMigrator.InitDb()
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	for _, path := range paths {
+		err := testMigrator.RegisterMigrationPath(path)
+		if err != nil {
+			t.Fatalf("got %v, want no error", err)
+		}
+	}
+
+	return testMigrator, db
+}
+
+func TestMemoryMigrationStore(t *testing.T) {
+	t.Run("not initialized", func(t *testing.T) {
+		db := NewMemoryMigrationStore()
+
+		_, err := db.GetCurrentSchemaVersion()
+		if err != ErrDatabaseNotInitialized {
+			t.Errorf("got %v, want %v", err, ErrDatabaseNotInitialized)
+		}
+	})
+
+	t.Run("history and executed sql", func(t *testing.T) {
+		testMigrator, db := newTestMigrator(t, testPaths)
+
+		err := testMigrator.Up("002")
+		if err != nil {
+			t.Fatalf("got %v, want no error", err)
+		}
+
+		history, err := db.GetMigrationHistory()
+		if err != nil {
+			t.Fatalf("got %v, want no error", err)
+		}
+
+		if len(history) != 3 {
+			t.Fatalf("got %d rows, want %d", len(history), 3)
+		}
+
+		for i, row := range history {
+			if row.Id != i+1 || row.MigrationStatus != "success" {
+				t.Errorf("got %+v, want successful row with id %d", row, i+1)
+			}
+		}
+
+		executed := db.Executed()
+		if len(executed) != 2 || executed[0] != "001up" || executed[1] != "002up" {
+			t.Errorf("got %v, want %v", executed, []string{"001up", "002up"})
+		}
+	})
+
+	t.Run("failing version", func(t *testing.T) {
+		testMigrator, db := newTestMigrator(t, testPaths, FailOnVersion("002"))
+
+		err := testMigrator.Up("003")
+		if err != ErrInjectedMigrationFailure {
+			t.Fatalf("got %v, want %v", err, ErrInjectedMigrationFailure)
+		}
+
+		history, _ := db.GetMigrationHistory()
+		last := history[len(history)-1]
+		if last.Name != "002" || last.MigrationStatus != "failure" {
+			t.Errorf("got %+v, want failed row for 002", last)
+		}
+
+		version, _ := testMigrator.CurrentVersion()
+		if version != "001" {
+			t.Errorf("got %q, want %q", version, "001")
+		}
+	})
+
+	t.Run("failing bookkeeping", func(t *testing.T) {
+		db := NewMemoryMigrationStore(WithHistory(Migration{Version: "000"}), FailOnBookkeeping())
+		testMigrator := NewMigrationManager(db, nil)
+		testMigrator.RegisterMigrationPath(MigrationPath{Version: "001", Action: "up"})
+
+		err := testMigrator.Up("001")
+		if err != ErrInjectedBookkeepingFailure {
+			t.Errorf("got %v, want %v", err, ErrInjectedBookkeepingFailure)
+		}
+
+		if len(db.Executed()) != 0 {
+			t.Errorf("got %v, want nothing executed", db.Executed())
+		}
+	})
+
+	t.Run("locking", func(t *testing.T) {
+		testMigrator, db := newTestMigrator(t, testPaths)
+
+		err := db.Lock()
+		if err != nil {
+			t.Fatalf("got %v, want no error", err)
+		}
+
+		err = testMigrator.Up("003")
+		if err != ErrMigrationLocked {
+			t.Errorf("got %v, want %v", err, ErrMigrationLocked)
+		}
+
+		db.Unlock()
+
+		err = testMigrator.Up("003")
+		if err != nil {
+			t.Errorf("got %v, want no error", err)
+		}
+
+		if db.Locked() {
+			t.Errorf("expected lock to be released after migrating")
+		}
+	})
+
+	t.Run("lock held elsewhere", func(t *testing.T) {
+		testMigrator, _ := newTestMigrator(t, testPaths, FailOnLock())
+
+		err := testMigrator.Down("001")
+		if err != ErrMigrationLocked {
+			t.Errorf("got %v, want %v", err, ErrMigrationLocked)
+		}
+	})
+}
@@ -2,17 +2,43 @@ package migrate
 
 import (
 	"sort"
+	"strings"
+	"time"
 
+	"github.com/crgwilson/pgm/pkg/lint"
 	"github.com/crgwilson/pgm/pkg/logger"
 )
 
 const schemaVersionTableName = "pgm_schema_migration"
 
 type MigrationManager struct {
+	// Component names the version line the manager works on when several
+	// sets of migrations share a database, see NewComponentManager
+	Component string
+
 	Datastore        MigrationStore
 	SchemaVersions   []string
 	SchemaVersionMap map[string]*SchemaVersion
-	Logger           logger.CliLogger
+	RepeatableMap    map[string]*RepeatableMigration
+	Logger           logger.Logger
+	Hooks            []Hooks
+
+	// AllowOutOfOrder lets Up apply versions lower than the current one which
+	// have never been applied, rather than only warning about them
+	AllowOutOfOrder bool
+
+	// LintRules, when set, are checked against every pending up script before
+	// Up runs anything, refusing to go on if any error is found
+	LintRules []lint.Rule
+
+	// TemplateVars are the variables available to scripts marked with a
+	// "-- +pgm template" directive
+	TemplateVars map[string]string
+
+	// TolerateAhead makes Up succeed without doing anything, after a
+	// warning, when the database has been migrated past every known version.
+	// This lets older replicas keep starting during a rolling deploy.
+	TolerateAhead bool
 }
 
 func (m *MigrationManager) InitDb() error {
@@ -27,6 +53,33 @@ func (m *MigrationManager) InitDb() error {
 	return nil
 }
 
+// Baseline initializes the migration table for a database whose schema was
+// created outside of pgm. Every version up to and including the given one is
+// treated as already applied.
+func (m *MigrationManager) Baseline(version string) error {
+	if !m.isKnownVersion(version) {
+		return ErrSchemaVersionUnknown
+	}
+
+	m.Logger.Debug("Preparing to baseline "+schemaVersionTableName+" table in target database", logger.Version(version))
+	err := m.withLock(func() error {
+		return m.Datastore.Baseline(version)
+	})
+	if err != nil {
+		m.Logger.Error("An error has occurred while trying to baseline migration table")
+		return err
+	}
+	m.Logger.Info("Baselined database at version "+version, logger.Version(version))
+
+	return nil
+}
+
+// BaselineVersion returns the version the database was baselined at, or an
+// empty string if it was initialized normally
+func (m *MigrationManager) BaselineVersion() (string, error) {
+	return m.Datastore.GetBaselineVersion()
+}
+
 func (m *MigrationManager) CurrentVersion() (string, error) {
 	currentVersion, err := m.Datastore.GetCurrentSchemaVersion()
 	if err != nil {
@@ -79,114 +132,630 @@ func (m *MigrationManager) getVersionIndex(version string) (int, error) {
 	return 0, ErrSchemaVersionUnknown
 }
 
-func (m *MigrationManager) getNextStepUp() (SchemaVersion, error) {
-	version, err := m.CurrentVersion()
+// appliedVersions replays the migration history to work out which versions
+// are applied right now. Versions covered by a baseline map to StatusBaseline,
+// everything else to StatusApplied.
+func (m *MigrationManager) appliedVersions() (map[string]string, error) {
+	history, err := m.Datastore.GetMigrationHistory()
 	if err != nil {
-		return SchemaVersion{}, err
+		return nil, err
 	}
 
-	var nextSchemaVersion *SchemaVersion
-	if version == "000" {
-		nextVersion := m.SchemaVersions[0]
-		nextSchemaVersion = m.SchemaVersionMap[nextVersion]
-	} else {
-		versionIndex, err := m.getVersionIndex(version)
-		if err != nil {
-			return SchemaVersion{}, err
+	applied := make(map[string]string)
+	for _, migration := range history {
+		if migration.MigrationStatus != "success" {
+			continue
+		}
+
+		switch {
+		case migration.MigrationType == migrationTypeBaseline:
+			applied = m.versionsThrough(migration.Version, StatusBaseline)
+		case migration.MigrationType == migrationTypeSnapshot:
+			// Unlike a baseline, a loaded snapshot was produced by these
+			// same migrations so their down scripts still apply
+			applied = m.versionsThrough(migration.Version, StatusApplied)
+		case migration.MigrationType == migrationTypeSquashed:
+			// A squashed migration applies or reverts everything it replaced
+			for _, v := range m.squashedVersions(migration.Name) {
+				if migration.Direction == "up" {
+					applied[v] = StatusApplied
+				} else {
+					delete(applied, v)
+				}
+			}
+		case migration.MigrationType != migrationTypeVersioned:
+			continue
+		case migration.Direction == "up":
+			applied[migration.Name] = StatusApplied
+		case migration.Direction == "down":
+			delete(applied, migration.Name)
+		default:
+			// Rows recorded before directions were tracked only tell us the
+			// resulting version, and back then migrations always ran in order
+			applied = m.versionsThrough(migration.Version, StatusApplied)
 		}
-		lastIndex := len(m.SchemaVersions) - 1
-		if versionIndex == lastIndex {
-			return SchemaVersion{}, ErrNoNextStep
+	}
+
+	return m.collapseSquashed(applied)
+}
+
+func (m *MigrationManager) versionsThrough(version, state string) map[string]string {
+	versions := make(map[string]string)
+	for _, v := range m.allVersions() {
+		if v <= version {
+			versions[v] = state
 		}
+	}
 
-		nextVersion := m.SchemaVersions[versionIndex+1]
-		nextSchemaVersion = m.SchemaVersionMap[nextVersion]
+	return versions
+}
+
+// allVersions returns every known version along with the original versions
+// which have since been squashed into one of them
+func (m *MigrationManager) allVersions() []string {
+	versions := append([]string{}, m.SchemaVersions...)
+	for _, v := range m.SchemaVersions {
+		for _, squashed := range m.SchemaVersionMap[v].Squashes {
+			if !m.isKnownVersion(squashed) {
+				versions = append(versions, squashed)
+			}
+		}
 	}
 
-	return *nextSchemaVersion, nil
+	return versions
 }
 
-func (m *MigrationManager) getNextStepDown() (SchemaVersion, error) {
+// squashedVersions returns the versions which the given version stands in
+// for: its own plus any it was squashed from
+func (m *MigrationManager) squashedVersions(version string) []string {
+	versions := []string{version}
+
+	schema, ok := m.SchemaVersionMap[version]
+	if !ok {
+		return versions
+	}
+
+	for _, squashed := range schema.Squashes {
+		if squashed != version {
+			versions = append(versions, squashed)
+		}
+	}
+
+	return versions
+}
+
+// collapseSquashed replaces the original versions of each squashed migration
+// with the squashed version itself. Databases which were migrated before the
+// squash have a row for every original version, and keep working as long as
+// they either had all of them applied or none.
+func (m *MigrationManager) collapseSquashed(applied map[string]string) (map[string]string, error) {
+	for _, v := range m.SchemaVersions {
+		if len(m.SchemaVersionMap[v].Squashes) == 0 {
+			continue
+		}
+
+		squashed := m.squashedVersions(v)
+		state := StatusApplied
+		count := 0
+		for _, s := range squashed {
+			if applied[s] != "" {
+				count++
+			}
+			if applied[s] == StatusBaseline {
+				state = StatusBaseline
+			}
+		}
+
+		if count == 0 {
+			continue
+		}
+
+		if count < len(squashed) {
+			return nil, ErrSquashPartiallyApplied
+		}
+
+		for _, s := range squashed {
+			delete(applied, s)
+		}
+		applied[v] = state
+	}
+
+	return applied, nil
+}
+
+// OutOfOrderVersions returns the known versions which are lower than the
+// current version but have never been applied, typically because they were
+// merged in from a branch after a later migration had already been deployed
+func (m *MigrationManager) OutOfOrderVersions() ([]string, error) {
+	version, err := m.CurrentVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := m.appliedVersions()
+	if err != nil {
+		return nil, err
+	}
+
+	return m.outOfOrderVersions(version, applied), nil
+}
+
+func (m *MigrationManager) outOfOrderVersions(version string, applied map[string]string) []string {
+	versions := make([]string, 0)
+	for _, v := range m.SchemaVersions {
+		if _, ok := applied[v]; !ok && v < version {
+			versions = append(versions, v)
+		}
+	}
+
+	return versions
+}
+
+func (m *MigrationManager) getNextStepUp() (SchemaVersion, error) {
 	version, err := m.CurrentVersion()
 	if err != nil {
 		return SchemaVersion{}, err
 	}
 
-	versionIndex, err := m.getVersionIndex(version)
+	applied, err := m.appliedVersions()
 	if err != nil {
 		return SchemaVersion{}, err
 	}
 
-	if versionIndex == 0 {
+	return m.nextStepUp(version, applied)
+}
+
+// nextStepUp returns the first pending version, given the current version and
+// the applied versions
+func (m *MigrationManager) nextStepUp(version string, applied map[string]string) (SchemaVersion, error) {
+	if version != "000" && !m.isKnownVersion(version) {
+		return SchemaVersion{}, ErrSchemaVersionUnknown
+	}
+
+	pending := m.pendingVersions(version, applied)
+	if len(pending) == 0 {
+		return SchemaVersion{}, ErrNoNextStep
+	}
+
+	return *m.SchemaVersionMap[pending[0]], nil
+}
+
+// getNextStepDown returns the most recent version which was applied by a
+// migration and so can be reverted
+func (m *MigrationManager) getNextStepDown(applied map[string]string) (SchemaVersion, error) {
+	var highest string
+	for v, state := range applied {
+		if state == StatusApplied && v > highest {
+			highest = v
+		}
+	}
+
+	if highest == "" {
 		return SchemaVersion{}, ErrNoNextStep
 	}
 
-	nextVersion := m.SchemaVersions[versionIndex-1]
-	nextVersionSchema := m.SchemaVersionMap[nextVersion]
+	if !m.isKnownVersion(highest) {
+		return SchemaVersion{}, ErrSchemaVersionUnknown
+	}
+
+	return *m.SchemaVersionMap[highest], nil
+}
+
+// highestApplied returns the version the schema will be at once the given
+// change has been made to the applied versions
+func highestApplied(applied map[string]string, add, remove string) string {
+	highest := "000"
+	for v := range applied {
+		if v != remove && v > highest {
+			highest = v
+		}
+	}
+
+	if add > highest {
+		highest = add
+	}
+
+	return highest
+}
+
+// migrationStep is a single planned change to the schema
+type migrationStep struct {
+	info    StepInfo
+	message string
+	apply   func() error
+}
+
+// planStep works out the next step of a run, given the current version and
+// the applied versions
+type planStep func(version, targetVersion string, applied map[string]string) (*migrationStep, error)
+
+func (m *MigrationManager) planStepUp(version, targetVersion string, applied map[string]string) (*migrationStep, error) {
+	next, err := m.nextStepUp(version, applied)
+	if err == ErrNoNextStep {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if next.Version > targetVersion {
+		return nil, nil
+	}
+
+	message := "Beginning schema migration from version " + version + " to " + next.Version
+	if next.Version < version {
+		message = "Applying out of order schema migration " + next.Version + " at version " + version
+	}
+
+	migration := Migration{
+		Version:       highestApplied(applied, next.Version, ""),
+		MigrationType: next.migrationType(),
+		Name:          next.Version,
+		Checksum:      Checksum(next.Up),
+		Direction:     "up",
+	}
+
+	sql, err := m.render(next.Version+".up.sql", next.Up)
+	if err != nil {
+		return nil, err
+	}
+
+	step := migrationStep{
+		info: StepInfo{
+			Version:   next.Version,
+			Direction: "up",
+		},
+		message: message,
+		apply: func() error {
+			return m.migrateSchema(migration, sql)
+		},
+	}
+
+	return &step, nil
+}
+
+func (m *MigrationManager) planStepDown(version, targetVersion string, applied map[string]string) (*migrationStep, error) {
+	down, err := m.getNextStepDown(applied)
+	if err == ErrNoNextStep {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if down.Version <= targetVersion {
+		return nil, nil
+	}
+
+	migration := Migration{
+		Version:       highestApplied(applied, "", down.Version),
+		MigrationType: down.migrationType(),
+		Name:          down.Version,
+		Checksum:      Checksum(down.Down),
+		Direction:     "down",
+	}
+
+	sql, err := m.render(down.Version+".down.sql", down.Down)
+	if err != nil {
+		return nil, err
+	}
+
+	step := migrationStep{
+		info: StepInfo{
+			Version:   down.Version,
+			Direction: "down",
+		},
+		message: "Beginning schema migration from version " + version + " to " + migration.Version,
+		apply: func() error {
+			return m.migrateSchema(migration, sql)
+		},
+	}
+
+	return &step, nil
+}
+
+func (m *MigrationManager) planRepeatable(repeatable *RepeatableMigration) (migrationStep, error) {
+	// The checksum is of the script as written, so changing a variable
+	// doesn't re-run it
+	sql, err := m.render(repeatable.Name+".sql", repeatable.Sql)
+	if err != nil {
+		return migrationStep{}, err
+	}
+
+	step := migrationStep{
+		info: StepInfo{
+			Version:    repeatable.Name,
+			Direction:  "up",
+			Repeatable: true,
+		},
+		message: "Applying repeatable migration " + repeatable.Name,
+		apply: func() error {
+			return m.Datastore.ApplyRepeatable(repeatable.Name, repeatable.Checksum, sql)
+		},
+	}
 
-	return *nextVersionSchema, nil
+	return step, nil
+}
+
+// runStep applies a single step of a run, counting it towards the run's
+// totals if it succeeds
+func (m *MigrationManager) runStep(step migrationStep, run *RunInfo) error {
+	step.info.Index = run.Steps + 1
+
+	err := m.hooks().beforeStep(step.info)
+	if err != nil {
+		return err
+	}
+
+	m.Logger.Info(step.message, logger.Direction(step.info.Direction), logger.F("step", step.info.Index))
+
+	start := time.Now()
+	err = step.apply()
+	step.info.Duration = time.Since(start)
+	step.info.Err = err
+
+	// The error is returned rather than logged, leaving it to the caller to
+	// report it once
+	if err != nil {
+		m.hooks().onFailure(step.info)
+	} else {
+		m.Logger.Info("Schema migration finished", logger.Version(step.info.Version), logger.Direction(step.info.Direction), logger.Duration(roundDuration(step.info.Duration)))
+
+		run.Steps++
+		if step.info.Duration >= run.Slowest.Duration {
+			run.Slowest = step.info
+		}
+	}
+
+	hookErr := m.hooks().afterStep(step.info)
+	if err != nil {
+		return err
+	}
+
+	return hookErr
+}
+
+// run steps the schema towards targetVersion one migration at a time, firing
+// the registered lifecycle hooks along the way. The history is replayed once
+// per step, and what it says is handed to everything planning that step.
+func (m *MigrationManager) run(direction, targetVersion string, plan planStep) error {
+	version, err := m.CurrentVersion()
+	if err != nil {
+		return err
+	}
+
+	applied, err := m.appliedVersions()
+	if err != nil {
+		return err
+	}
+
+	run := RunInfo{
+		Direction:     direction,
+		FromVersion:   version,
+		TargetVersion: targetVersion,
+		Planned:       m.plannedSteps(direction, version, targetVersion, applied),
+	}
+
+	err = m.hooks().beforeRun(run)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	for {
+		var step *migrationStep
+		step, err = plan(version, targetVersion, applied)
+		if err != nil || step == nil {
+			break
+		}
+
+		err = m.runStep(*step, &run)
+		if err != nil {
+			break
+		}
+
+		version, err = m.CurrentVersion()
+		if err != nil {
+			break
+		}
+
+		applied, err = m.appliedVersions()
+		if err != nil {
+			break
+		}
+	}
+
+	// Repeatable migrations are written against the latest schema, so they
+	// only run once every versioned migration has been applied
+	if err == nil && direction == "up" && targetVersion == m.HighestAvailableVersion() {
+		err = m.applyRepeatables(&run)
+	}
+
+	run.Duration = time.Since(start)
+	run.Err = err
+
+	m.logSummary(run)
+	if err == nil {
+		m.Logger.Info("Reached target version "+targetVersion, logger.Version(targetVersion))
+	}
+
+	hookErr := m.hooks().afterRun(run)
+	if err != nil {
+		return err
+	}
+
+	return hookErr
+}
+
+// withLock holds the migration lock while f runs, so that two copies of pgm
+// never migrate the same database at once
+func (m *MigrationManager) withLock(f func() error) error {
+	err := m.Datastore.Lock()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		unlockErr := m.Datastore.Unlock()
+		if unlockErr != nil {
+			m.Logger.Warn("Unable to release migration lock", logger.F("error", unlockErr))
+		}
+	}()
+
+	return f()
 }
 
 func (m *MigrationManager) Up(targetVersion string) error {
+	return m.withLock(func() error {
+		return m.up(targetVersion)
+	})
+}
+
+func (m *MigrationManager) up(targetVersion string) error {
+	if !m.isKnownVersion(targetVersion) {
+		return ErrSchemaVersionUnknown
+	}
+
 	version, err := m.CurrentVersion()
 	if err != nil {
 		return err
 	}
 
-	if version == targetVersion {
-		m.Logger.Info("Reached target version " + targetVersion)
+	err = m.checkAhead(version)
+	if err != nil && m.TolerateAhead {
+		m.Logger.Warn(err.Error()+", leaving it as it is", logger.Version(version), logger.F("latest", m.HighestAvailableVersion()))
 		return nil
 	}
+	if err != nil {
+		return err
+	}
 
-	next, err := m.getNextStepUp()
+	applied, err := m.appliedVersions()
 	if err != nil {
 		return err
 	}
 
-	m.Logger.Info("Beginning schema migration from version " + version + " to " + next.Version)
-	err = m.Datastore.MigrateSchema(next.Version, next.Up)
+	outOfOrder := m.outOfOrderVersions(version, applied)
+	if len(outOfOrder) > 0 && !m.AllowOutOfOrder {
+		m.Logger.Warn("Skipping migrations older than the current version which have never been applied", logger.Version(version), logger.F("versions", strings.Join(outOfOrder, ",")))
+	}
+
+	if targetVersion < version && !m.AllowOutOfOrder {
+		return ErrAlreadyReachedTargetVersion
+	}
+
+	pending := m.pendingVersions(version, applied)
+
+	err = m.checkRequirements(targetVersion, pending, applied)
 	if err != nil {
 		return err
 	}
 
-	// We want to keep going step by step until we reach our target version
-	err = m.Up(targetVersion)
+	err = m.lintPending(targetVersion, pending)
 	if err != nil {
 		return err
-	} else {
-		return nil
 	}
+
+	return m.run("up", targetVersion, m.planStepUp)
 }
 
 func (m *MigrationManager) Down(targetVersion string) error {
-	version, err := m.CurrentVersion()
+	return m.withLock(func() error {
+		return m.down(targetVersion)
+	})
+}
+
+func (m *MigrationManager) down(targetVersion string) error {
+	// Versions before the baseline were never applied by pgm, so there is
+	// nothing we could safely revert them with
+	baseline, err := m.BaselineVersion()
 	if err != nil {
 		return err
 	}
 
-	if version == targetVersion {
-		m.Logger.Info("Reached target version " + targetVersion)
-		return nil
+	if baseline != "" && targetVersion < baseline {
+		return ErrBelowBaseline
+	}
+
+	if !m.isKnownVersion(targetVersion) {
+		return ErrSchemaVersionUnknown
 	}
 
-	down := m.SchemaVersionMap[version]
-	next, err := m.getNextStepDown()
+	// The newer versions' down scripts aren't here to revert them with
+	version, err := m.CurrentVersion()
 	if err != nil {
 		return err
 	}
 
-	m.Logger.Info("Beginning schema migration from version " + version + " to " + next.Version)
-	err = m.Datastore.MigrateSchema(next.Version, down.Down)
+	err = m.checkAhead(version)
 	if err != nil {
 		return err
 	}
 
-	err = m.Down(targetVersion)
+	return m.run("down", targetVersion, m.planStepDown)
+}
+
+// OutdatedRepeatables returns the repeatable migrations which have never been
+// applied, or whose contents have changed since they last were, in the order
+// they will be applied
+func (m *MigrationManager) OutdatedRepeatables() ([]*RepeatableMigration, error) {
+	outdated := make([]*RepeatableMigration, 0)
+	if len(m.RepeatableMap) == 0 {
+		return outdated, nil
+	}
+
+	checksums, err := m.Datastore.GetRepeatableChecksums()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range m.repeatableNames() {
+		repeatable := m.RepeatableMap[name]
+		if checksums[name] != repeatable.Checksum {
+			outdated = append(outdated, repeatable)
+		}
+	}
+
+	return outdated, nil
+}
+
+func (m *MigrationManager) applyRepeatables(run *RunInfo) error {
+	outdated, err := m.OutdatedRepeatables()
 	if err != nil {
 		return err
-	} else {
-		return nil
 	}
+
+	for _, repeatable := range outdated {
+		step, err := m.planRepeatable(repeatable)
+		if err == nil {
+			err = m.runStep(step, run)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *MigrationManager) repeatableNames() []string {
+	names := make([]string, 0, len(m.RepeatableMap))
+	for name := range m.RepeatableMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// AddHooks registers a set of lifecycle callbacks. Hooks run in the order
+// they were added.
+func (m *MigrationManager) AddHooks(hooks Hooks) {
+	m.Hooks = append(m.Hooks, hooks)
+}
+
+func (m *MigrationManager) hooks() hookList {
+	return hookList(m.Hooks)
 }
 
 func (m *MigrationManager) RegisterMigrationPath(migrationPath MigrationPath) error {
@@ -206,11 +775,28 @@ func (m *MigrationManager) RegisterMigrationPath(migrationPath MigrationPath) er
 	return nil
 }
 
-func NewMigrationManager(db MigrationStore, l logger.CliLogger) *MigrationManager {
+func (m *MigrationManager) RegisterRepeatableMigration(repeatable RepeatableMigration) error {
+	_, exists := m.RepeatableMap[repeatable.Name]
+	if exists {
+		return ErrRepeatableAlreadyDefined
+	}
+
+	m.RepeatableMap[repeatable.Name] = &repeatable
+	m.Logger.Debug("Registered repeatable migration "+repeatable.Name, logger.F("checksum", repeatable.Checksum))
+
+	return nil
+}
+
+func NewMigrationManager(db MigrationStore, l logger.Logger) *MigrationManager {
+	if l == nil {
+		l = logger.NopLogger{}
+	}
+
 	migrator := MigrationManager{
 		Datastore:        db,
 		SchemaVersions:   make([]string, 0),
 		SchemaVersionMap: make(map[string]*SchemaVersion),
+		RepeatableMap:    make(map[string]*RepeatableMigration),
 		Logger:           l,
 	}
 
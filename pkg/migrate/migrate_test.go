@@ -8,12 +8,13 @@ import (
 )
 
 func TestMigrations(t *testing.T) {
+	spy := mocks.NewSpyLogger()
 	lgr := logger.CliLogger{
-		Logger:   mocks.NewSpyLogger(),
+		Logger:   spy,
 		LogLevel: logger.DebugLogLevel(),
 	}
 
-	db := NewMockMigrationStore()
+	db := NewMemoryMigrationStore()
 
 	testMigrator := NewMigrationManager(db, lgr)
 	err := testMigrator.InitDb()
@@ -110,4 +111,223 @@ func TestMigrations(t *testing.T) {
 	if currentVersion != "003" {
 		t.Errorf("got %q, want %q", currentVersion, "003")
 	}
+
+	lastLog := spy.Logs[len(spy.Logs)-1]
+	if lastLog != "Reached target version 003 version=003" {
+		t.Errorf("got %q, want %q", lastLog, "Reached target version 003 version=003")
+	}
+}
+
+func TestBaseline(t *testing.T) {
+	testMigrator, _ := newTestMigrator(t, testPaths)
+
+	err := testMigrator.Baseline("004")
+	if err != ErrSchemaVersionUnknown {
+		t.Errorf("got %v, want %v", err, ErrSchemaVersionUnknown)
+	}
+
+	err = testMigrator.Baseline("002")
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	err = testMigrator.Baseline("002")
+	if err != ErrDatabaseAlreadyMigrated {
+		t.Errorf("got %v, want %v", err, ErrDatabaseAlreadyMigrated)
+	}
+
+	err = testMigrator.Up("003")
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	status, err := testMigrator.Status()
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	wantStates := []string{StatusBaseline, StatusBaseline, StatusApplied}
+	for i, v := range status.Versions {
+		if v.State != wantStates[i] {
+			t.Errorf("got %q for version %s, want %q", v.State, v.Version, wantStates[i])
+		}
+	}
+
+	err = testMigrator.Down("001")
+	if err != ErrBelowBaseline {
+		t.Errorf("got %v, want %v", err, ErrBelowBaseline)
+	}
+
+	err = testMigrator.Down("002")
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	currentVersion, _ := testMigrator.CurrentVersion()
+	if currentVersion != "002" {
+		t.Errorf("got %q, want %q", currentVersion, "002")
+	}
+}
+
+func TestOutOfOrderMigrations(t *testing.T) {
+	testMigrator, db := newTestMigrator(t, testPaths)
+
+	// 001 and 003 were deployed before 002 was merged in from another branch
+	for _, version := range []string{"001", "003"} {
+		err := db.MigrateSchema(Migration{Version: version, Name: version, Direction: "up"}, "")
+		if err != nil {
+			t.Fatalf("got %v, want no error", err)
+		}
+	}
+
+	outOfOrder, err := testMigrator.OutOfOrderVersions()
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	if len(outOfOrder) != 1 || outOfOrder[0] != "002" {
+		t.Errorf("got %v, want %v", outOfOrder, []string{"002"})
+	}
+
+	status, err := testMigrator.Status()
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	if status.Versions[1].State != StatusOutOfOrder {
+		t.Errorf("got %q, want %q", status.Versions[1].State, StatusOutOfOrder)
+	}
+
+	// Without permission the skipped version is left alone
+	err = testMigrator.Up("003")
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	outOfOrder, _ = testMigrator.OutOfOrderVersions()
+	if len(outOfOrder) != 1 {
+		t.Errorf("got %v, want %v", outOfOrder, []string{"002"})
+	}
+
+	testMigrator.AllowOutOfOrder = true
+	err = testMigrator.Up("003")
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	outOfOrder, _ = testMigrator.OutOfOrderVersions()
+	if len(outOfOrder) != 0 {
+		t.Errorf("got %v, want none", outOfOrder)
+	}
+
+	currentVersion, _ := testMigrator.CurrentVersion()
+	if currentVersion != "003" {
+		t.Errorf("got %q, want %q", currentVersion, "003")
+	}
+
+	history, _ := db.GetMigrationHistory()
+	last := history[len(history)-1]
+	if last.Name != "002" || last.Version != "003" || last.Direction != "up" {
+		t.Errorf("got %+v, want 002 applied at version 003", last)
+	}
+}
+
+func TestDownOnlyRevertsAppliedVersions(t *testing.T) {
+	testMigrator, db := newTestMigrator(t, testPaths)
+
+	// 002 was never applied, so going down from 003 must skip it
+	for _, version := range []string{"001", "003"} {
+		db.MigrateSchema(Migration{Version: version, Name: version, Direction: "up"}, "")
+	}
+
+	reverted := make([]string, 0)
+	testMigrator.AddHooks(Hooks{
+		AfterStep: func(step StepInfo) error {
+			reverted = append(reverted, step.Version)
+			return nil
+		},
+	})
+
+	err := testMigrator.Down("001")
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	if len(reverted) != 1 || reverted[0] != "003" {
+		t.Errorf("got %v, want %v", reverted, []string{"003"})
+	}
+
+	currentVersion, _ := testMigrator.CurrentVersion()
+	if currentVersion != "001" {
+		t.Errorf("got %q, want %q", currentVersion, "001")
+	}
+}
+
+func TestLegacyHistory(t *testing.T) {
+	// Rows written by older releases only record the resulting version
+	testMigrator, _ := newTestMigrator(t, testPaths, WithHistory(
+		Migration{Version: "000"},
+		Migration{Version: "002"},
+	))
+
+	status, err := testMigrator.Status()
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	wantStates := []string{StatusApplied, StatusApplied, StatusPending}
+	for i, v := range status.Versions {
+		if v.State != wantStates[i] {
+			t.Errorf("got %q for version %s, want %q", v.State, v.Version, wantStates[i])
+		}
+	}
+}
+
+func TestStatusNoMigrations(t *testing.T) {
+	testMigrator, _ := newTestMigrator(t, nil)
+
+	_, err := testMigrator.Status()
+	if err != ErrNoMigrations {
+		t.Errorf("got %v, want %v", err, ErrNoMigrations)
+	}
+}
+
+// historyCountingStore counts how often the migration history is read
+type historyCountingStore struct {
+	*MemoryMigrationStore
+	reads int
+}
+
+func (s *historyCountingStore) GetMigrationHistory() ([]Migration, error) {
+	s.reads++
+	return s.MemoryMigrationStore.GetMigrationHistory()
+}
+
+func TestUpReadsHistoryOncePerStep(t *testing.T) {
+	db := &historyCountingStore{MemoryMigrationStore: NewMemoryMigrationStore()}
+	testMigrator := NewMigrationManager(db, nil)
+
+	err := testMigrator.InitDb()
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	versions := []string{"001", "002", "003", "004", "005", "006", "007", "008", "009", "010"}
+	for _, version := range versions {
+		err := testMigrator.RegisterMigrationPath(MigrationPath{Version: version, Action: "up", Raw: []byte(version + "up")})
+		if err != nil {
+			t.Fatalf("got %v, want no error", err)
+		}
+	}
+
+	err = testMigrator.Up("010")
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	// Once to check the run, once to plan it and once after every step
+	want := len(versions) + 2
+	if db.reads != want {
+		t.Errorf("got %d history reads, want %d", db.reads, want)
+	}
 }
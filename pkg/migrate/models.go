@@ -1,35 +1,110 @@
 package migrate
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"hash/fnv"
+	"sync"
 	"time"
 )
 
+const (
+	migrationTypeVersioned  = "versioned"
+	migrationTypeRepeatable = "repeatable"
+	migrationTypeBaseline   = "baseline"
+	migrationTypeSnapshot   = "snapshot"
+	migrationTypeSquashed   = "squashed"
+)
+
+// Migration is a single row of the migration table. Version is the schema
+// version once the row's migration has run, while Name is the migration that
+// was actually run: the reverted version for a "down" step, or the script name
+// for a repeatable migration.
 type Migration struct {
 	Id              int
 	Version         string
 	MigrationStatus string
 	LastUpdated     time.Time
+	MigrationType   string
+	Name            string
+	Checksum        string
+	Direction       string
+	// Component is the version line the row belongs to, empty for databases
+	// with a single set of migrations
+	Component string
+	// BatchSection and BatchRows record how far a script made of several
+	// sections has got: the section being run, and the rows its batches have
+	// affected so far
+	BatchSection int
+	BatchRows    int64
 }
 
 type DatabaseConnection interface {
 	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
 	QueryRow(query string, args ...interface{}) *sql.Row
 }
 
 type MigrationStore interface {
 	Init() error
 	GetCurrentSchemaVersion() (string, error)
-	MigrateSchema(version, sql string) error
+	GetMigrationHistory() ([]Migration, error)
+	MigrateSchema(migration Migration, sql string) error
+	// MigrateSections runs a script made of several sections, committing
+	// after each batch and recording its progress in the same transaction,
+	// so that running the same migration again after a failure carries on
+	// where it stopped
+	MigrateSections(migration Migration, sections []Section, report func(BatchProgress)) error
+	GetRepeatableChecksums() (map[string]string, error)
+	ApplyRepeatable(name, checksum, sql string) error
+	Baseline(version string) error
+	GetBaselineVersion() (string, error)
+	LoadSnapshot(version, checksum, sql string) error
+	Lock() error
+	Unlock() error
+	// ForComponent returns a store for another component's version line,
+	// kept in the same migration table
+	ForComponent(component string) MigrationStore
+}
+
+// Columns added to the migration table after its first release. These are
+// added to existing tables by init, or the first time the store finds them
+// missing.
+var schemaMigrationTableUpgrades = []struct {
+	Column     string
+	Definition string
+}{
+	{"migration_type", "VARCHAR(16) NOT NULL DEFAULT 'versioned'"},
+	{"name", "VARCHAR(255)"},
+	{"checksum", "VARCHAR(64)"},
+	{"direction", "VARCHAR(8)"},
+	{"component", "VARCHAR(64) NOT NULL DEFAULT ''"},
+	{"batch_section", "INT NOT NULL DEFAULT 0"},
+	{"batch_rows", "BIGINT NOT NULL DEFAULT 0"},
+}
+
+// connectionPool is implemented by *sql.DB. Session level advisory locks have
+// to be taken and released on the same connection, so when the store is given
+// a pool it pins one connection for as long as the lock is held.
+type connectionPool interface {
+	Conn(ctx context.Context) (*sql.Conn, error)
 }
 
 type SchemaMigrationStore struct {
 	Db        DatabaseConnection
 	TableName string
+	// Component selects which version line in the table the store works on
+	Component string
+
+	// mu guards upgraded, which is set once the table is known to have
+	// every column, as a server may use the store from several goroutines
+	mu       sync.Mutex
+	upgraded bool
+	lockConn *sql.Conn
 }
 
-func (s *SchemaMigrationStore) Init() error {
+func (s *SchemaMigrationStore) createTable() error {
 	query := `CREATE TABLE IF NOT EXISTS %s(
 		id SERIAL PRIMARY KEY,
 		version VARCHAR(16) NOT NULL,
@@ -42,8 +117,19 @@ func (s *SchemaMigrationStore) Init() error {
 		return err
 	}
 
-	query = `INSERT INTO %s(version, migration_status) VALUES('000', 'success')`
-	_, err = s.Db.Exec(fmt.Sprintf(query, s.TableName))
+	return s.upgrade()
+}
+
+func (s *SchemaMigrationStore) Init() error {
+	err := s.createTable()
+	if err != nil {
+		return err
+	}
+
+	// Only seed each component once so that running init again is harmless
+	query := `INSERT INTO %s(version, migration_status, component)
+		SELECT '000', 'success', $1 WHERE NOT EXISTS (SELECT 1 FROM %s WHERE component=$1)`
+	_, err = s.Db.Exec(fmt.Sprintf(query, s.TableName, s.TableName), s.Component)
 	if err != nil {
 		return err
 	}
@@ -51,6 +137,91 @@ func (s *SchemaMigrationStore) Init() error {
 	return nil
 }
 
+// upgrade adds any columns the table is missing
+func (s *SchemaMigrationStore) upgrade() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.upgradeLocked()
+}
+
+func (s *SchemaMigrationStore) upgradeLocked() error {
+	if s.upgraded {
+		return nil
+	}
+
+	for _, upgrade := range schemaMigrationTableUpgrades {
+		query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s", s.TableName, upgrade.Column, upgrade.Definition)
+		_, err := s.Db.Exec(query)
+		if err != nil {
+			return err
+		}
+	}
+	s.upgraded = true
+
+	return nil
+}
+
+// prepare makes sure the migration table exists and has every column the
+// store expects before reading it. ALTER TABLE needs the table's owner and
+// blocks every other query against the table, so the columns are looked up
+// first and the table is only altered if it was created by an older release.
+func (s *SchemaMigrationStore) prepare() error {
+	if !s.initialized() {
+		return ErrDatabaseNotInitialized
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.upgraded {
+		return nil
+	}
+
+	missing, err := s.missingColumns()
+	if err != nil {
+		return err
+	}
+	if missing {
+		return s.upgradeLocked()
+	}
+	s.upgraded = true
+
+	return nil
+}
+
+// missingColumns reports whether the table lacks any of the columns added
+// since its first release
+func (s *SchemaMigrationStore) missingColumns() (bool, error) {
+	query := "SELECT attname FROM pg_attribute WHERE attrelid=$1::regclass AND attnum>0 AND NOT attisdropped"
+	rows, err := s.Db.Query(query, s.TableName)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var column string
+		err = rows.Scan(&column)
+		if err != nil {
+			return false, err
+		}
+		columns[column] = true
+	}
+	if rows.Err() != nil {
+		return false, rows.Err()
+	}
+
+	for _, upgrade := range schemaMigrationTableUpgrades {
+		if !columns[upgrade.Column] {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
 func (s *SchemaMigrationStore) initialized() bool {
 	query := fmt.Sprintf("SELECT COUNT(*) from %s", s.TableName)
 
@@ -63,15 +234,22 @@ func (s *SchemaMigrationStore) initialized() bool {
 }
 
 func (s *SchemaMigrationStore) GetCurrentSchemaVersion() (string, error) {
-	if !s.initialized() {
-		return "", ErrDatabaseNotInitialized
+	err := s.prepare()
+	if err != nil {
+		return "", err
 	}
 
-	query := "SELECT version FROM %s WHERE id=(SELECT MAX(id) FROM %s)"
-	result := s.Db.QueryRow(fmt.Sprintf(query, s.TableName, s.TableName))
+	query := `SELECT version FROM %s WHERE id=(
+		SELECT MAX(id) FROM %s WHERE migration_status='success' AND migration_type IN ('versioned', 'baseline', 'snapshot', 'squashed') AND component=$1
+	)`
+	result := s.Db.QueryRow(fmt.Sprintf(query, s.TableName, s.TableName), s.Component)
 
 	var currentVersion string
-	err := result.Scan(&currentVersion)
+	err = result.Scan(&currentVersion)
+	if err == sql.ErrNoRows {
+		// The table exists, but init has not been run for this component
+		return "", ErrDatabaseNotInitialized
+	}
 	if err != nil {
 		return "", err
 	}
@@ -79,17 +257,53 @@ func (s *SchemaMigrationStore) GetCurrentSchemaVersion() (string, error) {
 	return currentVersion, nil
 }
 
-func (s *SchemaMigrationStore) startMigration(version string) error {
-	query := fmt.Sprintf("INSERT INTO %s (version) VALUES ($1)", s.TableName)
-	_, err := s.Db.Exec(query, version)
+// GetMigrationHistory returns every row of the migration table, oldest first
+func (s *SchemaMigrationStore) GetMigrationHistory() ([]Migration, error) {
+	err := s.prepare()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	query := `SELECT id, version, COALESCE(migration_status, ''), last_updated, migration_type,
+		COALESCE(name, ''), COALESCE(checksum, ''), COALESCE(direction, ''), component, batch_section, batch_rows
+		FROM %s WHERE component=$1 ORDER BY id`
+	rows, err := s.Db.Query(fmt.Sprintf(query, s.TableName), s.Component)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := make([]Migration, 0)
+	for rows.Next() {
+		var m Migration
+		err = rows.Scan(&m.Id, &m.Version, &m.MigrationStatus, &m.LastUpdated, &m.MigrationType, &m.Name, &m.Checksum, &m.Direction, &m.Component, &m.BatchSection, &m.BatchRows)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, m)
+	}
+
+	return history, rows.Err()
 }
 
-func (s *SchemaMigrationStore) endMigration(version string, migrationSuccessful bool) error {
+func (s *SchemaMigrationStore) startMigration(migration Migration) (int, error) {
+	if migration.MigrationType == "" {
+		migration.MigrationType = migrationTypeVersioned
+	}
+
+	query := fmt.Sprintf(`INSERT INTO %s (version, migration_type, name, checksum, direction, component)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6) RETURNING id`, s.TableName)
+
+	var id int
+	err := s.Db.QueryRow(query, migration.Version, migration.MigrationType, migration.Name, migration.Checksum, migration.Direction, s.Component).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (s *SchemaMigrationStore) endMigration(id int, migrationSuccessful bool) error {
 	var migrationStatus string
 	if migrationSuccessful {
 		migrationStatus = "success"
@@ -97,8 +311,8 @@ func (s *SchemaMigrationStore) endMigration(version string, migrationSuccessful
 		migrationStatus = "failure"
 	}
 
-	query := fmt.Sprintf("UPDATE %s SET migration_status=$1, last_updated=NOW() WHERE version=$2", s.TableName)
-	_, err := s.Db.Exec(query, migrationStatus, version)
+	query := fmt.Sprintf("UPDATE %s SET migration_status=$1, last_updated=NOW() WHERE id=$2", s.TableName)
+	_, err := s.Db.Exec(query, migrationStatus, id)
 	if err != nil {
 		return err
 	}
@@ -106,8 +320,16 @@ func (s *SchemaMigrationStore) endMigration(version string, migrationSuccessful
 	return nil
 }
 
-func (s *SchemaMigrationStore) MigrateSchema(version, sql string) error {
-	err := s.startMigration(version)
+// MigrateSchema runs the given sql and records the outcome as a new row. A
+// failed migration is recorded as such and its error returned, leaving the
+// current schema version untouched.
+func (s *SchemaMigrationStore) MigrateSchema(migration Migration, sql string) error {
+	err := s.prepare()
+	if err != nil {
+		return err
+	}
+
+	id, err := s.startMigration(migration)
 	if err != nil {
 		return err
 	}
@@ -120,14 +342,302 @@ func (s *SchemaMigrationStore) MigrateSchema(version, sql string) error {
 		migrationSuccessful = true
 	}
 
-	err = s.endMigration(version, migrationSuccessful)
+	err = s.endMigration(id, migrationSuccessful)
 	if err != nil {
 		return err
 	}
 
+	return migrationErr
+}
+
+// MigrateSections runs a script made of several sections. If the last attempt
+// at the same migration failed part way through, the new attempt starts from
+// the section it stopped at.
+func (s *SchemaMigrationStore) MigrateSections(migration Migration, sections []Section, report func(BatchProgress)) error {
+	err := s.prepare()
+	if err != nil {
+		return err
+	}
+
+	from, err := s.resumePoint(migration)
+	if err != nil {
+		return err
+	}
+
+	id, err := s.startMigration(migration)
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf("UPDATE %s SET batch_section=$1, batch_rows=$2, last_updated=NOW() WHERE id=$3", s.TableName)
+	_, err = s.Db.Exec(query, from.Section, from.Total, id)
+	if err != nil {
+		return err
+	}
+
+	exec := func(section Section, reached func(rows int64) BatchProgress) (int64, error) {
+		return execSection(s.Db, section, func(db DatabaseConnection, rows int64) error {
+			progress := reached(rows)
+			_, err := db.Exec(query, progress.Section, progress.Total, id)
+			return err
+		})
+	}
+
+	migrationErr := runSections(sections, from, exec, report)
+
+	err = s.endMigration(id, migrationErr == nil)
+	if err != nil {
+		return err
+	}
+
+	return migrationErr
+}
+
+// resumePoint returns where the last attempt at a migration stopped, if it
+// failed
+func (s *SchemaMigrationStore) resumePoint(migration Migration) (BatchProgress, error) {
+	query := fmt.Sprintf(`SELECT COALESCE(migration_status, ''), COALESCE(checksum, ''), batch_section, batch_rows FROM %s
+		WHERE component=$1 AND name=$2 AND direction=$3 ORDER BY id DESC LIMIT 1`, s.TableName)
+
+	var status, checksum string
+	var progress BatchProgress
+	err := s.Db.QueryRow(query, s.Component, migration.Name, migration.Direction).Scan(&status, &checksum, &progress.Section, &progress.Total)
+	if err == sql.ErrNoRows || status == "success" {
+		return BatchProgress{}, nil
+	}
+	if err != nil {
+		return BatchProgress{}, err
+	}
+
+	return progress, checkResumable(progress, checksum, migration.Checksum)
+}
+
+// GetRepeatableChecksums returns the checksum of the last successful run of
+// each repeatable migration, keyed by name
+func (s *SchemaMigrationStore) GetRepeatableChecksums() (map[string]string, error) {
+	err := s.prepare()
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT DISTINCT ON (name) name, checksum FROM %s
+		WHERE migration_type='repeatable' AND migration_status='success' AND component=$1
+		ORDER BY name, id DESC`
+	rows, err := s.Db.Query(fmt.Sprintf(query, s.TableName), s.Component)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	checksums := make(map[string]string)
+	for rows.Next() {
+		var name, checksum string
+		err = rows.Scan(&name, &checksum)
+		if err != nil {
+			return nil, err
+		}
+		checksums[name] = checksum
+	}
+
+	return checksums, rows.Err()
+}
+
+// ApplyRepeatable runs the given repeatable migration and records its
+// checksum. The row is stamped with the current schema version.
+func (s *SchemaMigrationStore) ApplyRepeatable(name, checksum, sql string) error {
+	version, err := s.GetCurrentSchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf(`INSERT INTO %s (version, migration_type, name, checksum, component)
+		VALUES ($1, 'repeatable', $2, $3, $4) RETURNING id`, s.TableName)
+
+	var id int
+	err = s.Db.QueryRow(query, version, name, checksum, s.Component).Scan(&id)
+	if err != nil {
+		return err
+	}
+
+	_, migrationErr := s.Db.Exec(sql)
+
+	err = s.endMigration(id, migrationErr == nil)
+	if err != nil {
+		return err
+	}
+
+	return migrationErr
+}
+
+// Baseline initializes the migration table at the given version rather than
+// 000, for databases whose schema already exists. It refuses to do so once any
+// migrations have been run.
+func (s *SchemaMigrationStore) Baseline(version string) error {
+	err := s.createUnmigratedTable()
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (version, migration_status, migration_type, component) VALUES ($1, 'success', 'baseline', $2)", s.TableName)
+	_, err = s.Db.Exec(query, version, s.Component)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// createUnmigratedTable makes sure the migration table exists, failing if any
+// migrations have already been recorded in it
+func (s *SchemaMigrationStore) createUnmigratedTable() error {
+	err := s.createTable()
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE (version<>'000' OR migration_type<>'versioned') AND component=$1", s.TableName)
+
+	var count int
+	err = s.Db.QueryRow(query, s.Component).Scan(&count)
+	if err != nil {
+		return err
+	}
+
+	if count > 0 {
+		return ErrDatabaseAlreadyMigrated
+	}
+
 	return nil
 }
 
+// LoadSnapshot runs a schema snapshot against a database which has not been
+// migrated yet, recording every version up to and including the snapshot's as
+// applied. Like any other migration, a failed load is recorded as such.
+func (s *SchemaMigrationStore) LoadSnapshot(version, checksum, sql string) error {
+	err := s.createUnmigratedTable()
+	if err != nil {
+		return err
+	}
+
+	id, err := s.startMigration(Migration{
+		Version:       version,
+		MigrationType: migrationTypeSnapshot,
+		Name:          version,
+		Checksum:      checksum,
+		Direction:     "up",
+	})
+	if err != nil {
+		return err
+	}
+
+	_, migrationErr := s.Db.Exec(sql)
+
+	err = s.endMigration(id, migrationErr == nil)
+	if err != nil {
+		return err
+	}
+
+	return migrationErr
+}
+
+// GetBaselineVersion returns the version the database was baselined at, or
+// an empty string if it never was
+func (s *SchemaMigrationStore) GetBaselineVersion() (string, error) {
+	err := s.prepare()
+	if err != nil {
+		return "", err
+	}
+
+	query := fmt.Sprintf("SELECT version FROM %s WHERE migration_type='baseline' AND component=$1 ORDER BY id DESC LIMIT 1", s.TableName)
+
+	var version string
+	err = s.Db.QueryRow(query, s.Component).Scan(&version)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return version, nil
+}
+
+// lockKey derives the advisory lock key from the table name and component, so
+// stores using different tables or components don't block each other
+func (s *SchemaMigrationStore) lockKey() int64 {
+	h := fnv.New64a()
+	h.Write([]byte(s.TableName))
+	if s.Component != "" {
+		h.Write([]byte("/" + s.Component))
+	}
+
+	return int64(h.Sum64())
+}
+
+// Lock takes a PostgreSQL advisory lock, returning ErrMigrationLocked straight
+// away if another session already holds it
+func (s *SchemaMigrationStore) Lock() error {
+	query := "SELECT pg_try_advisory_lock($1)"
+
+	var locked bool
+	pool, ok := s.Db.(connectionPool)
+	if ok {
+		conn, err := pool.Conn(context.Background())
+		if err != nil {
+			return err
+		}
+
+		err = conn.QueryRowContext(context.Background(), query, s.lockKey()).Scan(&locked)
+		if err != nil || !locked {
+			conn.Close()
+		}
+		if err != nil {
+			return err
+		}
+		if locked {
+			s.lockConn = conn
+		}
+	} else {
+		err := s.Db.QueryRow(query, s.lockKey()).Scan(&locked)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !locked {
+		return ErrMigrationLocked
+	}
+
+	return nil
+}
+
+func (s *SchemaMigrationStore) Unlock() error {
+	query := "SELECT pg_advisory_unlock($1)"
+
+	if s.lockConn == nil {
+		_, err := s.Db.Exec(query, s.lockKey())
+		return err
+	}
+
+	conn := s.lockConn
+	s.lockConn = nil
+	defer conn.Close()
+
+	_, err := conn.ExecContext(context.Background(), query, s.lockKey())
+
+	return err
+}
+
+// ForComponent returns a store sharing this one's database and table which
+// works on the given component's version line
+func (s *SchemaMigrationStore) ForComponent(component string) MigrationStore {
+	return &SchemaMigrationStore{
+		Db:        s.Db,
+		TableName: s.TableName,
+		Component: component,
+	}
+}
+
 func NewSchemaMigrationStore(db DatabaseConnection) *SchemaMigrationStore {
 	sm := SchemaMigrationStore{
 		Db:        db,
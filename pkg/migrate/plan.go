@@ -0,0 +1,107 @@
+package migrate
+
+import (
+	"sort"
+)
+
+// PlannedStep is a script which would run to reach a target version, as it
+// would be run, i.e. with any template rendered
+type PlannedStep struct {
+	Version    string
+	Direction  string
+	Repeatable bool
+	Sql        string
+}
+
+// PlanUp lists the scripts Up would run to reach targetVersion without running
+// any of them
+func (m *MigrationManager) PlanUp(targetVersion string) ([]PlannedStep, error) {
+	if !m.isKnownVersion(targetVersion) {
+		return nil, ErrSchemaVersionUnknown
+	}
+
+	version, err := m.CurrentVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := m.appliedVersions()
+	if err != nil {
+		return nil, err
+	}
+
+	return m.planUp(targetVersion, m.pendingVersions(version, applied))
+}
+
+func (m *MigrationManager) planUp(targetVersion string, pending []string) ([]PlannedStep, error) {
+	steps := make([]PlannedStep, 0, len(pending))
+	for _, version := range pending {
+		if version > targetVersion {
+			break
+		}
+
+		sql, err := m.Rendered(version, "up")
+		if err != nil {
+			return nil, err
+		}
+
+		steps = append(steps, PlannedStep{Version: version, Direction: "up", Sql: sql})
+	}
+
+	if targetVersion != m.HighestAvailableVersion() {
+		return steps, nil
+	}
+
+	outdated, err := m.OutdatedRepeatables()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, repeatable := range outdated {
+		sql, err := m.render(repeatable.Name+".sql", repeatable.Sql)
+		if err != nil {
+			return nil, err
+		}
+
+		steps = append(steps, PlannedStep{Version: repeatable.Name, Direction: "up", Repeatable: true, Sql: sql})
+	}
+
+	return steps, nil
+}
+
+// PlanDown lists the scripts Down would run to reach targetVersion without
+// running any of them
+func (m *MigrationManager) PlanDown(targetVersion string) ([]PlannedStep, error) {
+	if !m.isKnownVersion(targetVersion) {
+		return nil, ErrSchemaVersionUnknown
+	}
+
+	applied, err := m.appliedVersions()
+	if err != nil {
+		return nil, err
+	}
+
+	return m.planDown(targetVersion, applied)
+}
+
+func (m *MigrationManager) planDown(targetVersion string, applied map[string]string) ([]PlannedStep, error) {
+	versions := make([]string, 0)
+	for version, state := range applied {
+		if state == StatusApplied && version > targetVersion {
+			versions = append(versions, version)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(versions)))
+
+	steps := make([]PlannedStep, 0, len(versions))
+	for _, version := range versions {
+		sql, err := m.Rendered(version, "down")
+		if err != nil {
+			return nil, err
+		}
+
+		steps = append(steps, PlannedStep{Version: version, Direction: "down", Sql: sql})
+	}
+
+	return steps, nil
+}
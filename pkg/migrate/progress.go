@@ -0,0 +1,165 @@
+package migrate
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/crgwilson/pgm/pkg/logger"
+)
+
+// Event is a single line of the stream written by EventStream
+type Event struct {
+	Time          time.Time `json:"time"`
+	Event         string    `json:"event"`
+	Component     string    `json:"component,omitempty"`
+	Direction     string    `json:"direction"`
+	Version       string    `json:"version,omitempty"`
+	FromVersion   string    `json:"from_version,omitempty"`
+	TargetVersion string    `json:"target_version,omitempty"`
+	Repeatable    bool      `json:"repeatable,omitempty"`
+	Step          int       `json:"step,omitempty"`
+	Planned       int       `json:"planned,omitempty"`
+	Steps         int       `json:"steps,omitempty"`
+	DurationMs    int64     `json:"duration_ms,omitempty"`
+	Slowest       string    `json:"slowest,omitempty"`
+	Error         string    `json:"error,omitempty"`
+}
+
+const (
+	EventRunStarted   = "run_started"
+	EventRunFinished  = "run_finished"
+	EventStepStarted  = "step_started"
+	EventStepFinished = "step_finished"
+	EventStepFailed   = "step_failed"
+)
+
+// EventStream returns hooks which write every run and step of a manager to
+// w as it happens, one JSON object per line, for dashboards and other tools
+// to follow
+func (m *MigrationManager) EventStream(w io.Writer) Hooks {
+	var mu sync.Mutex
+	encoder := json.NewEncoder(w)
+
+	write := func(event Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		event.Time = time.Now().UTC()
+		event.Component = m.Component
+		return encoder.Encode(event)
+	}
+
+	step := func(name string, step StepInfo) Event {
+		event := Event{
+			Event:      name,
+			Direction:  step.Direction,
+			Version:    step.Version,
+			Repeatable: step.Repeatable,
+			Step:       step.Index,
+			DurationMs: step.Duration.Milliseconds(),
+		}
+		if step.Err != nil {
+			event.Error = step.Err.Error()
+		}
+
+		return event
+	}
+
+	return Hooks{
+		BeforeRun: func(run RunInfo) error {
+			return write(Event{
+				Event:         EventRunStarted,
+				Direction:     run.Direction,
+				FromVersion:   run.FromVersion,
+				TargetVersion: run.TargetVersion,
+				Planned:       run.Planned,
+			})
+		},
+		AfterRun: func(run RunInfo) error {
+			event := Event{
+				Event:         EventRunFinished,
+				Direction:     run.Direction,
+				FromVersion:   run.FromVersion,
+				TargetVersion: run.TargetVersion,
+				Planned:       run.Planned,
+				Steps:         run.Steps,
+				DurationMs:    run.Duration.Milliseconds(),
+				Slowest:       run.Slowest.Version,
+			}
+			if run.Err != nil {
+				event.Error = run.Err.Error()
+			}
+
+			return write(event)
+		},
+		BeforeStep: func(info StepInfo) error {
+			return write(step(EventStepStarted, info))
+		},
+		AfterStep: func(info StepInfo) error {
+			if info.Err != nil {
+				return write(step(EventStepFailed, info))
+			}
+
+			return write(step(EventStepFinished, info))
+		},
+	}
+}
+
+// plannedSteps counts the steps a run is expected to take, or returns 0 if
+// that can't be worked out
+func (m *MigrationManager) plannedSteps(direction, version, targetVersion string, applied map[string]string) int {
+	var steps []PlannedStep
+	var err error
+	if direction == "up" {
+		steps, err = m.planUp(targetVersion, m.pendingVersions(version, applied))
+	} else {
+		steps, err = m.planDown(targetVersion, applied)
+	}
+
+	if err != nil {
+		return 0
+	}
+
+	return len(steps)
+}
+
+// logSummary reports how a run went once it has finished
+func (m *MigrationManager) logSummary(run RunInfo) {
+	message := "Applied " + pluralSteps(run.Steps) + " in " + roundDuration(run.Duration).String()
+	if run.Err != nil {
+		message = "Stopped after " + pluralSteps(run.Steps) + " in " + roundDuration(run.Duration).String()
+	}
+
+	fields := []logger.Field{
+		logger.Direction(run.Direction),
+		logger.F("steps", run.Steps),
+		logger.Duration(roundDuration(run.Duration)),
+	}
+
+	if run.Steps > 0 {
+		message += ", slowest was " + run.Slowest.Version + " " + run.Slowest.Direction + " in " + roundDuration(run.Slowest.Duration).String()
+		fields = append(fields, logger.F("slowest", run.Slowest.Version))
+	}
+
+	m.Logger.Info(message, fields...)
+}
+
+func pluralSteps(n int) string {
+	if n == 1 {
+		return "1 step"
+	}
+
+	return strconv.Itoa(n) + " steps"
+}
+
+// roundDuration trims a duration to something readable in a log line
+func roundDuration(d time.Duration) time.Duration {
+	if d < time.Millisecond {
+		return d.Round(time.Microsecond)
+	}
+
+	return d.Round(time.Millisecond)
+}
@@ -0,0 +1,93 @@
+package migrate
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/crgwilson/pgm/pkg/logger"
+	"github.com/crgwilson/pgm/pkg/mocks"
+)
+
+func TestEventStream(t *testing.T) {
+	testMigrator, _ := newTestMigrator(t, testPaths, FailOnVersion("003"))
+
+	var out bytes.Buffer
+	testMigrator.AddHooks(testMigrator.EventStream(&out))
+
+	err := testMigrator.Up("003")
+	if err != ErrInjectedMigrationFailure {
+		t.Fatalf("got %v, want %v", err, ErrInjectedMigrationFailure)
+	}
+
+	got := make([]string, 0)
+	var last Event
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var event Event
+		err := json.Unmarshal([]byte(line), &event)
+		if err != nil {
+			t.Fatalf("got %v, want no error", err)
+		}
+
+		got = append(got, event.Event+" "+event.Version)
+		last = event
+	}
+
+	want := []string{
+		"run_started ",
+		"step_started 001",
+		"step_finished 001",
+		"step_started 002",
+		"step_finished 002",
+		"step_started 003",
+		"step_failed 003",
+		"run_finished ",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if last.Planned != 3 || last.Steps != 2 || last.Error == "" {
+		t.Errorf("got planned %d steps %d error %q, want planned 3 steps 2 and an error", last.Planned, last.Steps, last.Error)
+	}
+}
+
+func TestRunSummary(t *testing.T) {
+	spy := mocks.NewSpyLogger()
+	testMigrator, _ := newTestMigrator(t, testPaths)
+	testMigrator.Logger = logger.CliLogger{Logger: spy, LogLevel: logger.InfoLogLevel()}
+
+	var slowest StepInfo
+	testMigrator.AddHooks(Hooks{
+		AfterRun: func(run RunInfo) error {
+			slowest = run.Slowest
+			return nil
+		},
+	})
+
+	err := testMigrator.Up("003")
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	finished := 0
+	summary := ""
+	for _, line := range spy.Logs {
+		if strings.HasPrefix(line, "Schema migration finished") {
+			finished++
+		}
+		if strings.HasPrefix(line, "Applied ") {
+			summary = line
+		}
+	}
+
+	if finished != 3 {
+		t.Errorf("got %d finish lines, want 3", finished)
+	}
+
+	if !strings.HasPrefix(summary, "Applied 3 steps in ") || !strings.Contains(summary, "slowest was "+slowest.Version+" up") {
+		t.Errorf("got %q, want a summary of 3 steps naming %s as the slowest", summary, slowest.Version)
+	}
+}
@@ -0,0 +1,50 @@
+package migrate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+const repeatablePrefix = "R__"
+
+var ErrRepeatableAlreadyDefined = errors.New("Given repeatable migration is already registered")
+
+// RepeatableMigration is a script which is re-applied after the versioned
+// migrations whenever its contents change, e.g. R__refresh_views.sql
+type RepeatableMigration struct {
+	Name     string
+	Sql      string
+	Checksum string
+}
+
+// Checksum returns the hex encoded SHA-256 of a migration script
+func Checksum(sqlText string) string {
+	sum := sha256.Sum256([]byte(sqlText))
+	return hex.EncodeToString(sum[:])
+}
+
+func IsRepeatableFile(sqlFileName string) bool {
+	return strings.HasPrefix(sqlFileName, repeatablePrefix) && strings.HasSuffix(sqlFileName, ".sql")
+}
+
+func ParseRepeatableFile(sqlFileName string, sqlFileContents []byte) (RepeatableMigration, error) {
+	if !IsRepeatableFile(sqlFileName) {
+		return RepeatableMigration{}, ErrInvalidFile
+	}
+
+	name := strings.TrimSuffix(sqlFileName, ".sql")
+	if name == repeatablePrefix {
+		return RepeatableMigration{}, ErrInvalidFile
+	}
+
+	sqlText := string(sqlFileContents)
+	parsed := RepeatableMigration{
+		Name:     name,
+		Sql:      sqlText,
+		Checksum: Checksum(sqlText),
+	}
+
+	return parsed, nil
+}
@@ -0,0 +1,117 @@
+package migrate
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRepeatableFile(t *testing.T) {
+	cases := []struct {
+		Name          string
+		FileName      string
+		ExpectedName  string
+		ExpectedError error
+	}{
+		{"repeatable file", "R__refresh_views.sql", "R__refresh_views", nil},
+		{"versioned file", "001.up.sql", "", ErrInvalidFile},
+		{"missing name", "R__.sql", "", ErrInvalidFile},
+		{"non-sql file", "R__notes.txt", "", ErrInvalidFile},
+	}
+
+	for _, test := range cases {
+		t.Run(test.Name, func(t *testing.T) {
+			parsed, err := ParseRepeatableFile(test.FileName, []byte("SELECT 1"))
+			if err != test.ExpectedError {
+				t.Errorf("got %v, want %v", err, test.ExpectedError)
+			}
+
+			if parsed.Name != test.ExpectedName {
+				t.Errorf("got %q, want %q", parsed.Name, test.ExpectedName)
+			}
+
+			if err == nil && parsed.Checksum != Checksum("SELECT 1") {
+				t.Errorf("got %q, want %q", parsed.Checksum, Checksum("SELECT 1"))
+			}
+		})
+	}
+}
+
+func repeatableStates(t *testing.T, m *MigrationManager) map[string]string {
+	status, err := m.Status()
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	states := make(map[string]string)
+	for _, r := range status.Repeatables {
+		states[r.Name] = r.State
+	}
+
+	return states
+}
+
+func TestRepeatableMigrations(t *testing.T) {
+	testMigrator, _ := newTestMigrator(t, testPaths)
+
+	for _, name := range []string{"R__views", "R__functions"} {
+		repeatable, _ := ParseRepeatableFile(name+".sql", []byte(name))
+		err := testMigrator.RegisterRepeatableMigration(repeatable)
+		if err != nil {
+			t.Fatalf("got %v, want no error", err)
+		}
+	}
+
+	duplicate, _ := ParseRepeatableFile("R__views.sql", []byte(""))
+	err := testMigrator.RegisterRepeatableMigration(duplicate)
+	if err != ErrRepeatableAlreadyDefined {
+		t.Errorf("got %v, want %v", err, ErrRepeatableAlreadyDefined)
+	}
+
+	applied := make([]string, 0)
+	testMigrator.AddHooks(Hooks{
+		AfterStep: func(step StepInfo) error {
+			applied = append(applied, step.Version)
+			return nil
+		},
+	})
+
+	// Repeatables wait until the highest version has been reached
+	err = testMigrator.Up("002")
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	want := map[string]string{"R__functions": StatusPending, "R__views": StatusPending}
+	if got := repeatableStates(t, testMigrator); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	err = testMigrator.Up("003")
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	wantApplied := []string{"001", "002", "003", "R__functions", "R__views"}
+	if !reflect.DeepEqual(applied, wantApplied) {
+		t.Errorf("got %v, want %v", applied, wantApplied)
+	}
+
+	// Changing a script marks it as outdated until it is applied again
+	testMigrator.RepeatableMap["R__views"].Sql = "changed"
+	testMigrator.RepeatableMap["R__views"].Checksum = Checksum("changed")
+
+	want = map[string]string{"R__functions": StatusApplied, "R__views": StatusOutdated}
+	if got := repeatableStates(t, testMigrator); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	applied = applied[:0]
+	err = testMigrator.Up("003")
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	if !reflect.DeepEqual(applied, []string{"R__views"}) {
+		t.Errorf("got %v, want %v", applied, []string{"R__views"})
+	}
+}
@@ -0,0 +1,191 @@
+package migrate
+
+import (
+	"strings"
+)
+
+// Requirement is a migration which has to be applied before another one
+type Requirement struct {
+	Component string
+	Version   string
+}
+
+// String names the migration the way requires directives do
+func (r Requirement) String() string {
+	if r.Component == "" {
+		return r.Version
+	}
+
+	return r.Component + "/" + r.Version
+}
+
+// ParseRequirement reads a requirement written as component/version, or as a
+// bare version of the given component
+func ParseRequirement(text, component string) (Requirement, error) {
+	required := Requirement{Component: component, Version: text}
+	if before, after, found := strings.Cut(text, "/"); found {
+		required = Requirement{Component: before, Version: after}
+	}
+
+	if required.Version == "" || strings.Contains(required.Version, "/") {
+		return Requirement{}, ErrInvalidDirective
+	}
+
+	return required, nil
+}
+
+// RequirementError explains why a migration can't be applied yet, naming
+// every migration along the way from the one being applied to the one which
+// is missing
+type RequirementError struct {
+	Chain  []Requirement
+	Reason string
+}
+
+func (e *RequirementError) Error() string {
+	names := make([]string, 0, len(e.Chain))
+	for _, r := range e.Chain {
+		names = append(names, r.String())
+	}
+
+	return "Migration " + strings.Join(names, " requires ") + ", " + e.Reason
+}
+
+func (e *RequirementError) Unwrap() error {
+	return ErrUnsatisfiedRequirement
+}
+
+// requirements returns the migrations a version of this manager's component
+// requires
+func (m *MigrationManager) requirements(version string) []Requirement {
+	schema, ok := m.SchemaVersionMap[version]
+	if !ok {
+		return nil
+	}
+
+	requirements := make([]Requirement, 0, len(schema.Requires))
+	for _, text := range schema.Requires {
+		// Directives were checked when the script was registered
+		required, _ := ParseRequirement(text, m.Component)
+		requirements = append(requirements, required)
+	}
+
+	return requirements
+}
+
+// requirementApplied reports whether another component's migration has been
+// applied, along with the version that component is at
+func (m *MigrationManager) requirementApplied(required Requirement) (bool, string, error) {
+	db := m.Datastore.ForComponent(required.Component)
+
+	current, err := db.GetCurrentSchemaVersion()
+	if err == ErrDatabaseNotInitialized {
+		return false, "", nil
+	}
+	if err != nil {
+		return false, "", err
+	}
+
+	history, err := db.GetMigrationHistory()
+	if err != nil {
+		return false, "", err
+	}
+
+	return versionApplied(history, required.Version), current, nil
+}
+
+// versionApplied replays another component's history to work out whether a
+// version of it is applied. Being below its current version isn't enough,
+// since the version may have been skipped over. That component's scripts may
+// not be registered here, so a squashed migration is taken to stand in for
+// every version up to its own, the way Squash builds them.
+func versionApplied(history []Migration, version string) bool {
+	applied := false
+	for _, migration := range history {
+		if migration.MigrationStatus != "success" {
+			continue
+		}
+
+		switch {
+		case migration.MigrationType == migrationTypeBaseline || migration.MigrationType == migrationTypeSnapshot:
+			applied = version <= migration.Version
+		case migration.MigrationType == migrationTypeSquashed:
+			if version <= migration.Name {
+				applied = migration.Direction == "up"
+			}
+		case migration.MigrationType != migrationTypeVersioned:
+			continue
+		case migration.Direction == "up" || migration.Direction == "down":
+			if migration.Name == version {
+				applied = migration.Direction == "up"
+			}
+		default:
+			// Rows recorded before directions were tracked
+			applied = version <= migration.Version
+		}
+	}
+
+	return applied
+}
+
+// notAppliedReason describes a requirement which has not been applied
+func notAppliedReason(required Requirement, current string) string {
+	if current == "" {
+		return "which has not been applied (" + componentName(required.Component) + " has not been initialized)"
+	}
+
+	return "which has not been applied (" + componentName(required.Component) + " is at version " + current + ")"
+}
+
+func componentName(component string) string {
+	if component == "" {
+		return "the default component"
+	}
+
+	return component
+}
+
+// checkRequirements makes sure everything the pending versions about to be
+// applied on the way to targetVersion require is either applied already or
+// will be by the time they run
+func (m *MigrationManager) checkRequirements(targetVersion string, pending []string, applied map[string]string) error {
+	for _, version := range pending {
+		if version > targetVersion {
+			break
+		}
+
+		for _, required := range m.requirements(version) {
+			chain := []Requirement{{Component: m.Component, Version: version}, required}
+
+			if required.Component == m.Component {
+				// Versions run in order, so only earlier ones can be relied on
+				if applied[required.Version] != "" || (required.Version < version && containsVersion(pending, required.Version)) {
+					continue
+				}
+
+				return &RequirementError{Chain: chain, Reason: "which will not have been applied first"}
+			}
+
+			ok, current, err := m.requirementApplied(required)
+			if err != nil {
+				return err
+			}
+
+			if !ok {
+				return &RequirementError{Chain: chain, Reason: notAppliedReason(required, current)}
+			}
+		}
+	}
+
+	return nil
+}
+
+func containsVersion(versions []string, version string) bool {
+	for _, v := range versions {
+		if v == version {
+			return true
+		}
+	}
+
+	return false
+}
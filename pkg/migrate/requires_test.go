@@ -0,0 +1,147 @@
+package migrate
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseRequirement(t *testing.T) {
+	tests := []struct {
+		Text string
+		Want Requirement
+		Err  error
+	}{
+		{"billing/014", Requirement{"billing", "014"}, nil},
+		{"014", Requirement{"users", "014"}, nil},
+		{"/014", Requirement{"", "014"}, nil},
+		{"billing/", Requirement{}, ErrInvalidDirective},
+		{"a/b/014", Requirement{}, ErrInvalidDirective},
+	}
+
+	for _, test := range tests {
+		got, err := ParseRequirement(test.Text, "users")
+		if got != test.Want || err != test.Err {
+			t.Errorf("got %v %v, want %v %v", got, err, test.Want, test.Err)
+		}
+	}
+}
+
+func TestComponentRequirements(t *testing.T) {
+	db := NewMemoryMigrationStore()
+	components := newTestComponents(t, db, map[string]map[string]string{
+		"billing": {"001": "", "002": "users/001"},
+		"users":   {"001": "", "002": "billing/002"},
+	})
+
+	order, err := components.Plan()
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	want := []Requirement{{"billing", "001"}, {"users", "001"}, {"billing", "002"}, {"users", "002"}}
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("got %v, want %v", order, want)
+	}
+
+	// A component migrated on its own refuses to go past what it requires
+	users, _ := components.Get("users")
+	err = users.Up("002")
+
+	var requirementErr *RequirementError
+	if !errors.As(err, &requirementErr) || !errors.Is(err, ErrUnsatisfiedRequirement) {
+		t.Fatalf("got %v, want a requirement error", err)
+	}
+
+	wantMessage := "Migration users/002 requires billing/002, which has not been applied (billing is at version 000)"
+	if err.Error() != wantMessage {
+		t.Errorf("got %q, want %q", err.Error(), wantMessage)
+	}
+
+	// Nothing was applied, not even users/001
+	version, _ := users.CurrentVersion()
+	if version != "000" {
+		t.Errorf("got %v, want 000", version)
+	}
+
+	err = components.Up()
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	for _, name := range components.Names() {
+		version, _ := components[name].CurrentVersion()
+		if version != "002" {
+			t.Errorf("got %v for %s, want 002", version, name)
+		}
+	}
+}
+
+func TestComponentRequirementErrors(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Requires map[string]map[string]string
+		Want     string
+	}{
+		{
+			"circular",
+			map[string]map[string]string{
+				"billing": {"001": "", "002": "users/001"},
+				"users":   {"001": "billing/002"},
+			},
+			"Migration billing/002 requires users/001 requires billing/002, which is a circular requirement",
+		},
+		{
+			"missing further along",
+			map[string]map[string]string{
+				"billing": {"001": "users/001"},
+				"users":   {"001": "search/004"},
+			},
+			"Migration billing/001 requires users/001 requires search/004, which has not been applied (search has not been initialized)",
+		},
+	}
+
+	for _, test := range cases {
+		t.Run(test.Name, func(t *testing.T) {
+			components := newTestComponents(t, NewMemoryMigrationStore(), test.Requires)
+
+			_, err := components.Plan()
+			if err == nil || err.Error() != test.Want {
+				t.Errorf("got %v, want %q", err, test.Want)
+			}
+
+			err = components.Up()
+			if !errors.Is(err, ErrUnsatisfiedRequirement) {
+				t.Errorf("got %v, want %v", err, ErrUnsatisfiedRequirement)
+			}
+		})
+	}
+}
+
+func TestRequirementSkippedOver(t *testing.T) {
+	db := NewMemoryMigrationStore()
+	components := newTestComponents(t, db, map[string]map[string]string{
+		"billing": {"001": "", "002": "", "003": ""},
+		"users":   {"001": "billing/002"},
+	})
+
+	// billing is at 003, but 002 was skipped over
+	billing := db.ForComponent("billing")
+	for _, version := range []string{"001", "003"} {
+		err := billing.MigrateSchema(Migration{Version: version, Name: version, Direction: "up", MigrationType: migrationTypeVersioned}, "SELECT 1;")
+		if err != nil {
+			t.Fatalf("got %v, want no error", err)
+		}
+	}
+
+	users, _ := components.Get("users")
+	err := users.Up("001")
+	if !errors.Is(err, ErrUnsatisfiedRequirement) {
+		t.Errorf("got %v, want %v", err, ErrUnsatisfiedRequirement)
+	}
+
+	want := "Migration users/001 requires billing/002, which has not been applied (billing is at version 003)"
+	if err == nil || err.Error() != want {
+		t.Errorf("got %v, want %q", err, want)
+	}
+}
@@ -0,0 +1,103 @@
+package migrate
+
+import (
+	"errors"
+	"time"
+
+	"github.com/crgwilson/pgm/pkg/catalog"
+	"github.com/crgwilson/pgm/pkg/logger"
+)
+
+var ErrMissingDownMigration = errors.New("Schema version has no down migration")
+
+// RoundTripResult describes what happened when one version was applied,
+// reverted and applied again
+type RoundTripResult struct {
+	Version string
+	// Residue is everything the down migration failed to undo, compared to
+	// the schema before the up migration ran
+	Residue []catalog.Difference
+	// Drift is everything that differs after applying the up migration a
+	// second time, compared to the first time
+	Drift []catalog.Difference
+	// Err is set when one of the scripts failed to run, in which case the
+	// round trip stopped at this version
+	Err error
+}
+
+// Ok reports whether the version round tripped cleanly
+func (r RoundTripResult) Ok() bool {
+	return r.Err == nil && len(r.Residue) == 0 && len(r.Drift) == 0
+}
+
+// RoundTrip checks that every down migration exactly reverses its up
+// migration. Starting from an empty database each version is applied, reverted
+// and applied again in order, comparing catalog snapshots after every step.
+//
+// The scripts are run directly against db without touching the migration
+// table, so db should be a scratch database which can be thrown away
+// afterwards. The returned error is only set when a snapshot could not be
+// taken; failing scripts are reported through RoundTripResult.Err.
+func (m *MigrationManager) RoundTrip(db DatabaseConnection, opts catalog.Options) ([]RoundTripResult, error) {
+	results := make([]RoundTripResult, 0, len(m.SchemaVersions))
+
+	before, err := catalog.Snapshot(db, opts)
+	if err != nil {
+		return results, err
+	}
+
+	for _, version := range m.SchemaVersions {
+		schema := m.SchemaVersionMap[version]
+		result := RoundTripResult{Version: version}
+		start := time.Now()
+
+		if schema.Down == "" {
+			result.Err = ErrMissingDownMigration
+			results = append(results, result)
+			return results, nil
+		}
+
+		var up, down string
+		up, result.Err = m.Rendered(version, "up")
+		if result.Err == nil {
+			down, result.Err = m.Rendered(version, "down")
+		}
+		if result.Err != nil {
+			results = append(results, result)
+			return results, nil
+		}
+
+		var applied, reverted, reapplied *catalog.Catalog
+		steps := []struct {
+			sql      string
+			snapshot **catalog.Catalog
+		}{
+			{up, &applied},
+			{down, &reverted},
+			{up, &reapplied},
+		}
+
+		for _, step := range steps {
+			result.Err = runScript(db, step.sql)
+			if result.Err != nil {
+				results = append(results, result)
+				return results, nil
+			}
+
+			*step.snapshot, err = catalog.Snapshot(db, opts)
+			if err != nil {
+				return results, err
+			}
+		}
+
+		result.Residue = catalog.Diff(before, reverted)
+		result.Drift = catalog.Diff(applied, reapplied)
+		results = append(results, result)
+
+		m.Logger.Debug("Round tripped version "+version, logger.Version(version), logger.Duration(time.Since(start)))
+
+		before = reapplied
+	}
+
+	return results, nil
+}
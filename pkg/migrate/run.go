@@ -0,0 +1,156 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"time"
+
+	"github.com/crgwilson/pgm/pkg/logger"
+)
+
+// RunOptions control what Run is allowed to do
+type RunOptions struct {
+	// Component selects the version line to migrate, see NewComponentManager
+	Component string
+	Logger    logger.Logger
+	// Store keeps track of the migrations, by default in db's
+	// pgm_schema_migration table
+	Store MigrationStore
+
+	// AutoInit creates the migration table if the database doesn't have one
+	// yet, rather than failing with ErrDatabaseNotInitialized
+	AutoInit bool
+	// WaitForLock keeps trying to take the migration lock while another
+	// process holds it, until ctx is done, rather than failing with
+	// ErrMigrationLocked. Replicas starting together can all call Run and
+	// whichever gets the lock migrates while the rest wait.
+	WaitForLock bool
+	// LockRetryInterval is how often WaitForLock tries, every second by default
+	LockRetryInterval time.Duration
+
+	// TargetVersion defaults to the highest version in the source
+	TargetVersion string
+	// AllowDown lets Run revert migrations when the target is below the
+	// current version. Without it Run refuses with ErrDownNotAllowed.
+	AllowDown bool
+	// TolerateAhead lets Run succeed, after a warning, against a database
+	// migrated past every version in the source, i.e. by a newer release,
+	// leaving it alone. Without it Run refuses with a DatabaseAheadError. See
+	// MigrationManager.TolerateAhead.
+	TolerateAhead bool
+
+	AllowOutOfOrder bool
+	TemplateVars    map[string]string
+	Hooks           []Hooks
+}
+
+const defaultLockRetryInterval = time.Second
+
+// Run brings a database up to date with the migrations in source in one call,
+// for services which migrate themselves on startup. source is usually an
+// embed.FS or os.DirFS holding the same files the pgm command would read.
+//
+// The migration steps themselves can't be interrupted, so ctx is only checked
+// between them and while waiting for the lock. The returned RunInfo describes
+// the run which took place, if any.
+func Run(ctx context.Context, db DatabaseConnection, source fs.FS, opts RunOptions) (RunInfo, error) {
+	store := opts.Store
+	if store == nil {
+		store = NewSchemaMigrationStore(db)
+	}
+
+	migrator := NewComponentManager(store, opts.Component, opts.Logger)
+	migrator.AllowOutOfOrder = opts.AllowOutOfOrder
+	migrator.TolerateAhead = opts.TolerateAhead
+	migrator.TemplateVars = opts.TemplateVars
+
+	sqlHooks, _, err := migrator.LoadFS(source)
+	if err != nil {
+		return RunInfo{}, err
+	}
+
+	if len(migrator.SchemaVersions) == 0 {
+		return RunInfo{}, ErrNoMigrations
+	}
+
+	if db != nil {
+		migrator.AddHooks(sqlHooks.Hooks(db))
+	}
+	for _, hooks := range opts.Hooks {
+		migrator.AddHooks(hooks)
+	}
+
+	var run RunInfo
+	migrator.AddHooks(Hooks{
+		BeforeRun: func(info RunInfo) error {
+			return ctx.Err()
+		},
+		BeforeStep: func(info StepInfo) error {
+			return ctx.Err()
+		},
+		AfterRun: func(info RunInfo) error {
+			run = info
+			return nil
+		},
+	})
+
+	_, err = migrator.CurrentVersion()
+	if err == ErrDatabaseNotInitialized && opts.AutoInit {
+		err = migrator.InitDb()
+	}
+	if err != nil {
+		return RunInfo{}, err
+	}
+
+	target := opts.TargetVersion
+	if target == "" {
+		target = migrator.HighestAvailableVersion()
+	}
+
+	err = lockWhile(ctx, migrator, opts, func() error {
+		// Another process may have migrated the database while we waited for
+		// the lock, so only look at where it is once we hold it
+		current, err := migrator.CurrentVersion()
+		if err != nil {
+			return err
+		}
+
+		// Up either refuses or leaves alone a database which is ahead
+		if target >= current || migrator.databaseAhead(current) {
+			return migrator.up(target)
+		}
+
+		if !opts.AllowDown {
+			return fmt.Errorf("%w: the database is at version %s, past the target %s", ErrDownNotAllowed, current, target)
+		}
+
+		return migrator.down(target)
+	})
+
+	return run, err
+}
+
+// lockWhile holds the migration lock while f runs, retrying to take it if
+// asked to wait for it
+func lockWhile(ctx context.Context, migrator *MigrationManager, opts RunOptions, f func() error) error {
+	interval := opts.LockRetryInterval
+	if interval <= 0 {
+		interval = defaultLockRetryInterval
+	}
+
+	for {
+		err := migrator.withLock(f)
+		if err != ErrMigrationLocked || !opts.WaitForLock {
+			return err
+		}
+
+		migrator.Logger.Info("Waiting for another migration to finish")
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %w", ErrMigrationLocked, ctx.Err())
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func testSource(versions ...string) fstest.MapFS {
+	source := fstest.MapFS{
+		"README.md":          {Data: []byte("not a migration")},
+		"R__views.sql":       {Data: []byte("CREATE OR REPLACE VIEW v AS SELECT 1;")},
+		"beforeEach.sql":     {Data: []byte("SET lock_timeout = '5s';")},
+		"archive/001.up.sql": {Data: []byte("ignored")},
+	}
+	for _, version := range versions {
+		source[version+".up.sql"] = &fstest.MapFile{Data: []byte(version + "up")}
+		source[version+".down.sql"] = &fstest.MapFile{Data: []byte(version + "down")}
+	}
+
+	return source
+}
+
+func TestLoadFS(t *testing.T) {
+	testMigrator := NewMigrationManager(NewMemoryMigrationStore(), nil)
+
+	sqlHooks, versionFiles, err := testMigrator.LoadFS(testSource("001", "002"))
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	if len(testMigrator.SchemaVersions) != 2 || len(testMigrator.RepeatableMap) != 1 {
+		t.Errorf("got %v and %d repeatables, want 2 versions and 1 repeatable", testMigrator.SchemaVersions, len(testMigrator.RepeatableMap))
+	}
+
+	if sqlHooks.BeforeEach == "" || len(versionFiles["001"]) != 2 {
+		t.Errorf("got hooks %+v and files %v, want beforeEach and both 001 files", sqlHooks, versionFiles)
+	}
+
+	_, _, err = NewMigrationManager(NewMemoryMigrationStore(), nil).LoadFS(fstest.MapFS{"042.sideways.sql": {}})
+
+	var sourceErr *SourceError
+	if !errors.As(err, &sourceErr) || sourceErr.File != "042.sideways.sql" {
+		t.Errorf("got %v, want an error naming 042.sideways.sql", err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	db := NewMemoryMigrationStore()
+	ctx := context.Background()
+
+	_, err := Run(ctx, nil, testSource("001", "002"), RunOptions{Store: db})
+	if err != ErrDatabaseNotInitialized {
+		t.Errorf("got %v, want %v", err, ErrDatabaseNotInitialized)
+	}
+
+	run, err := Run(ctx, nil, testSource("001", "002"), RunOptions{Store: db, AutoInit: true})
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	// Both versions and the repeatable migration
+	if run.Steps != 3 {
+		t.Errorf("got %d steps, want 3", run.Steps)
+	}
+
+	version, _ := db.GetCurrentSchemaVersion()
+	if version != "002" {
+		t.Errorf("got %v, want 002", version)
+	}
+
+	_, err = Run(ctx, nil, testSource("001", "002"), RunOptions{Store: db, TargetVersion: "001"})
+	if !errors.Is(err, ErrDownNotAllowed) {
+		t.Errorf("got %v, want %v", err, ErrDownNotAllowed)
+	}
+
+	_, err = Run(ctx, nil, testSource("001", "002"), RunOptions{Store: db, TargetVersion: "001", AllowDown: true})
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	version, _ = db.GetCurrentSchemaVersion()
+	if version != "001" {
+		t.Errorf("got %v, want 001", version)
+	}
+
+	_, err = Run(ctx, nil, testSource(), RunOptions{Store: db})
+	if err != ErrNoMigrations {
+		t.Errorf("got %v, want %v", err, ErrNoMigrations)
+	}
+}
+
+func TestRunAhead(t *testing.T) {
+	db := NewMemoryMigrationStore()
+	ctx := context.Background()
+
+	_, err := Run(ctx, nil, testSource("001", "002", "003"), RunOptions{Store: db, AutoInit: true})
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	// An older release only knows about the first two versions
+	_, err = Run(ctx, nil, testSource("001", "002"), RunOptions{Store: db})
+	if !errors.Is(err, ErrDatabaseAhead) {
+		t.Fatalf("got %v, want %v", err, ErrDatabaseAhead)
+	}
+
+	want := "Database is at schema version 003, which is newer than the latest known migration 002"
+	if err.Error() != want {
+		t.Errorf("got %q, want %q", err.Error(), want)
+	}
+
+	// Tolerating it, the older release starts up and leaves the database alone
+	_, err = Run(ctx, nil, testSource("001", "002"), RunOptions{Store: db, TolerateAhead: true})
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	version, _ := db.GetCurrentSchemaVersion()
+	if version != "003" {
+		t.Errorf("got %v, want 003", version)
+	}
+}
+
+func TestRunWaitsForLock(t *testing.T) {
+	db := NewMemoryMigrationStore(FailOnLock())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err := Run(ctx, nil, testSource("001"), RunOptions{Store: db, AutoInit: true, WaitForLock: true, LockRetryInterval: time.Millisecond})
+	if !errors.Is(err, ErrMigrationLocked) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want the lock to still be held when the context ran out", err)
+	}
+}
@@ -8,11 +8,29 @@ type SchemaVersion struct {
 	Version string
 	Up      string
 	Down    string
+
+	// Squashes lists the original versions this one was squashed from, taken
+	// from a "-- +pgm squashes" directive in its up script
+	Squashes []string
+
+	// Requires lists the migrations which have to be applied first, taken
+	// from "-- +pgm requires" directives in its up script, as component/version
+	// or just a version of the same component
+	Requires []string
 }
 
 func (s *SchemaVersion) SetAction(action, sqlText string) error {
+	_, err := SplitSections(sqlText)
+	if err != nil {
+		return err
+	}
+
 	switch action {
 	case "up":
+		err := s.applyDirectives(ParseDirectives(sqlText))
+		if err != nil {
+			return err
+		}
 		s.Up = sqlText
 	case "down":
 		s.Down = sqlText
@@ -28,3 +46,41 @@ func NewSchemaVersion(version string) *SchemaVersion {
 	}
 	return &sv
 }
+
+// migrationType is how running this version is recorded in the migration table
+func (s *SchemaVersion) migrationType() string {
+	if len(s.Squashes) > 0 {
+		return migrationTypeSquashed
+	}
+
+	return migrationTypeVersioned
+}
+
+func (s *SchemaVersion) applyDirectives(directives []Directive) error {
+	for _, directive := range directives {
+		switch directive.Name {
+		case directiveSquashes:
+			if len(directive.Args) == 0 {
+				return ErrInvalidDirective
+			}
+			s.Squashes = directive.Args
+		case directiveRequires:
+			if len(directive.Args) == 0 {
+				return ErrInvalidDirective
+			}
+			for _, arg := range directive.Args {
+				_, err := ParseRequirement(arg, "")
+				if err != nil {
+					return err
+				}
+			}
+			s.Requires = append(s.Requires, directive.Args...)
+		case directiveTemplate, directiveBatch, directiveLintIgnore, directiveLintIgnoreFile:
+			continue
+		default:
+			return ErrUnknownDirective
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package migrate
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+
+	"github.com/crgwilson/pgm/pkg/catalog"
+	"github.com/crgwilson/pgm/pkg/logger"
+)
+
+const schemaSnapshotHeader = "-- pgm schema snapshot, generated by pgm dump-schema. Do not edit by hand.\n"
+const schemaSnapshotVersionPrefix = "-- version: "
+
+// RenderSchemaSnapshot renders a catalog as a schema snapshot of the given
+// version: the DDL needed to recreate it, preceded by a header recording the
+// version
+func RenderSchemaSnapshot(c *catalog.Catalog, version string) string {
+	return schemaSnapshotHeader + schemaSnapshotVersionPrefix + version + "\n\n" + c.DDL()
+}
+
+// ParseSchemaSnapshotVersion reads the version from the header of a snapshot
+// written by RenderSchemaSnapshot
+func ParseSchemaSnapshotVersion(snapshot []byte) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(snapshot))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "--") {
+			break
+		}
+
+		if strings.HasPrefix(line, schemaSnapshotVersionPrefix) {
+			version := strings.TrimSpace(strings.TrimPrefix(line, schemaSnapshotVersionPrefix))
+			if version != "" {
+				return version, nil
+			}
+		}
+	}
+
+	return "", ErrInvalidSchemaSnapshot
+}
+
+// LoadSchema sets up an empty database from a snapshot written by DumpSchema
+// in one go, rather than running every migration up to the snapshot's version.
+// Those versions are recorded as applied, so Up carries on from there.
+func (m *MigrationManager) LoadSchema(snapshot []byte) error {
+	version, err := ParseSchemaSnapshotVersion(snapshot)
+	if err != nil {
+		return err
+	}
+
+	if !m.isKnownVersion(version) {
+		return ErrSchemaVersionUnknown
+	}
+
+	sql := string(snapshot)
+	m.Logger.Debug("Preparing to load schema snapshot", logger.Version(version))
+	err = m.withLock(func() error {
+		return m.Datastore.LoadSnapshot(version, Checksum(sql), sql)
+	})
+	if err != nil {
+		m.Logger.Error("An error has occurred while trying to load schema snapshot", logger.Version(version))
+		return err
+	}
+	m.Logger.Info("Loaded schema snapshot at version "+version, logger.Version(version))
+
+	return nil
+}
+
+// DumpSchema takes a snapshot of everything in db apart from the migration
+// table and renders it at the current schema version
+func (m *MigrationManager) DumpSchema(db catalog.Queryer) (string, error) {
+	version, err := m.CurrentVersion()
+	if err != nil {
+		return "", err
+	}
+
+	snapshot, err := SnapshotSchema(db)
+	if err != nil {
+		return "", err
+	}
+
+	return RenderSchemaSnapshot(snapshot, version), nil
+}
+
+// SnapshotSchema takes a catalog snapshot of everything in db apart from the
+// migration table
+func SnapshotSchema(db catalog.Queryer) (*catalog.Catalog, error) {
+	return catalog.Snapshot(db, catalog.Options{ExcludeTables: []string{schemaVersionTableName}})
+}
+
+// WithDatastore returns a copy of the manager, sharing its registered
+// migrations, which runs them against a different store. Hooks are not copied
+// since they are usually bound to the original database.
+func (m *MigrationManager) WithDatastore(db MigrationStore) *MigrationManager {
+	migrator := *m
+	migrator.Datastore = db
+	migrator.Hooks = nil
+
+	return &migrator
+}
@@ -0,0 +1,126 @@
+package migrate
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/crgwilson/pgm/pkg/catalog"
+)
+
+func TestRenderSchemaSnapshot(t *testing.T) {
+	got := RenderSchemaSnapshot(&catalog.Catalog{}, "042")
+
+	if !strings.HasPrefix(got, schemaSnapshotHeader+"-- version: 042\n\n") {
+		t.Errorf("got %q, want it to start with the header and version", got)
+	}
+
+	if !strings.HasSuffix(got, (&catalog.Catalog{}).DDL()) {
+		t.Errorf("got %q, want it to end with the DDL", got)
+	}
+}
+
+func TestWithDatastore(t *testing.T) {
+	testMigrator, original := newTestMigrator(t, testPaths)
+	testMigrator.AddHooks(Hooks{})
+
+	scratch := NewMemoryMigrationStore()
+	copied := testMigrator.WithDatastore(scratch)
+
+	err := copied.InitDb()
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	err = copied.Up(copied.HighestAvailableVersion())
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	if len(copied.Hooks) != 0 {
+		t.Errorf("got %d hooks, want none", len(copied.Hooks))
+	}
+
+	if len(scratch.Executed()) != 3 {
+		t.Errorf("got %d migrations run on the new store, want 3", len(scratch.Executed()))
+	}
+
+	if len(original.Executed()) != 0 {
+		t.Errorf("got %d migrations run on the original store, want 0", len(original.Executed()))
+	}
+}
+
+func TestParseSchemaSnapshotVersion(t *testing.T) {
+	cases := []struct {
+		Name            string
+		Snapshot        string
+		ExpectedVersion string
+		ExpectedError   error
+	}{
+		{"rendered snapshot", RenderSchemaSnapshot(&catalog.Catalog{}, "042"), "042", nil},
+		{"version only", "-- version: 007\nCREATE TABLE a();", "007", nil},
+		{"no header", "CREATE TABLE a();\n-- version: 007", "", ErrInvalidSchemaSnapshot},
+		{"empty version", "-- version: \n", "", ErrInvalidSchemaSnapshot},
+	}
+
+	for _, test := range cases {
+		t.Run(test.Name, func(t *testing.T) {
+			version, err := ParseSchemaSnapshotVersion([]byte(test.Snapshot))
+			if err != test.ExpectedError {
+				t.Errorf("got %v, want %v", err, test.ExpectedError)
+			}
+
+			if version != test.ExpectedVersion {
+				t.Errorf("got %q, want %q", version, test.ExpectedVersion)
+			}
+		})
+	}
+}
+
+func TestLoadSchema(t *testing.T) {
+	testMigrator, db := newTestMigrator(t, testPaths)
+	snapshot := []byte(RenderSchemaSnapshot(&catalog.Catalog{}, "002"))
+
+	err := testMigrator.LoadSchema([]byte(RenderSchemaSnapshot(&catalog.Catalog{}, "004")))
+	if err != ErrSchemaVersionUnknown {
+		t.Errorf("got %v, want %v", err, ErrSchemaVersionUnknown)
+	}
+
+	err = testMigrator.LoadSchema(snapshot)
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	err = testMigrator.LoadSchema(snapshot)
+	if err != ErrDatabaseAlreadyMigrated {
+		t.Errorf("got %v, want %v", err, ErrDatabaseAlreadyMigrated)
+	}
+
+	status, err := testMigrator.Status()
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	wantStates := []string{StatusApplied, StatusApplied, StatusPending}
+	for i, v := range status.Versions {
+		if v.State != wantStates[i] {
+			t.Errorf("got %q for version %s, want %q", v.State, v.Version, wantStates[i])
+		}
+	}
+
+	// Unlike a baseline, versions loaded from a snapshot can be reverted
+	err = testMigrator.Up("003")
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	err = testMigrator.Down("001")
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	want := []string{string(snapshot), "003up", "003down", "002down"}
+	if !reflect.DeepEqual(db.Executed(), want) {
+		t.Errorf("got %v, want %v", db.Executed(), want)
+	}
+}
@@ -0,0 +1,82 @@
+package migrate
+
+import (
+	"io/fs"
+	"path"
+)
+
+// SourceError names the file a migration source could not be loaded from.
+// File is empty when the directory itself could not be read.
+type SourceError struct {
+	File string
+	Err  error
+}
+
+func (e *SourceError) Error() string {
+	if e.File == "" {
+		return e.Err.Error()
+	}
+
+	return e.File + ": " + e.Err.Error()
+}
+
+func (e *SourceError) Unwrap() error {
+	return e.Err
+}
+
+// LoadFS registers every migration and repeatable migration in the top level
+// of fsys, which may be a directory on disk (os.DirFS) or files embedded in
+// the binary (embed.FS). Files other than .sql files are ignored. It returns
+// the SQL hook files found along the way, and the names of each version's
+// files.
+func (m *MigrationManager) LoadFS(fsys fs.FS) (SqlHooks, map[string][]string, error) {
+	sqlHooks := SqlHooks{}
+	versionFiles := make(map[string][]string)
+
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return sqlHooks, nil, &SourceError{Err: err}
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || path.Ext(name) != ".sql" {
+			continue
+		}
+
+		contents, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return sqlHooks, nil, &SourceError{File: name, Err: err}
+		}
+
+		// Hook files run around migrations rather than being migrations themselves
+		if IsSqlHookFile(name) {
+			sqlHooks.Register(name, contents)
+			m.Logger.Debug("Registered SQL hook " + name)
+			continue
+		}
+
+		if IsRepeatableFile(name) {
+			repeatable, err := ParseRepeatableFile(name, contents)
+			if err == nil {
+				err = m.RegisterRepeatableMigration(repeatable)
+			}
+			if err != nil {
+				return sqlHooks, nil, &SourceError{File: name, Err: err}
+			}
+			continue
+		}
+
+		migrationPath, err := ParseSqlFile(name, contents)
+		if err == nil {
+			err = m.RegisterMigrationPath(migrationPath)
+		}
+		if err != nil {
+			return sqlHooks, nil, &SourceError{File: name, Err: err}
+		}
+
+		versionFiles[migrationPath.Version] = append(versionFiles[migrationPath.Version], name)
+	}
+
+	return sqlHooks, versionFiles, nil
+}
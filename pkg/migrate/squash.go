@@ -0,0 +1,180 @@
+package migrate
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/crgwilson/pgm/pkg/lint"
+)
+
+// SquashedMigration is a single migration standing in for every version up to
+// and including Version
+type SquashedMigration struct {
+	Version string
+	// Squashes lists every original version, including any squashed earlier
+	Squashes []string
+	// Requires lists the migrations the original scripts required, other
+	// than those squashed in with them
+	Requires []string
+	Up       string
+	Down     string
+	// Template is set when any of the original scripts was a template, in
+	// which case both squashed scripts are too, with {{ in the others escaped
+	Template bool
+}
+
+// UpFile renders the up script, headed by the directive which tells pgm which
+// versions it replaces
+func (s SquashedMigration) UpFile() string {
+	directives := Directive{Name: directiveSquashes, Args: s.Squashes}.String() + "\n"
+	if len(s.Requires) > 0 {
+		directives += Directive{Name: directiveRequires, Args: s.Requires}.String() + "\n"
+	}
+	if s.Template {
+		directives += Directive{Name: directiveTemplate}.String() + "\n"
+	}
+
+	return directives + "\n" + s.Up
+}
+
+// DownFile renders the down script
+func (s SquashedMigration) DownFile() string {
+	if s.Template {
+		return Directive{Name: directiveTemplate}.String() + "\n\n" + s.Down
+	}
+
+	return s.Down
+}
+
+// Squash combines every version up to and including through into one. The up
+// script is each up script in order and the down script is each down script
+// in reverse.
+func (m *MigrationManager) Squash(through string) (SquashedMigration, error) {
+	if !m.isKnownVersion(through) {
+		return SquashedMigration{}, ErrSchemaVersionUnknown
+	}
+
+	versions := make([]string, 0)
+	for _, v := range m.SchemaVersions {
+		if v <= through {
+			versions = append(versions, v)
+		}
+	}
+
+	if len(versions) < 2 {
+		return SquashedMigration{}, ErrNothingToSquash
+	}
+
+	squashed := SquashedMigration{Version: through}
+	for _, v := range versions {
+		schema := m.SchemaVersionMap[v]
+		if schema.Down == "" {
+			return SquashedMigration{}, ErrMissingDownMigration
+		}
+
+		squashed.Template = squashed.Template || IsTemplate(schema.Up) || IsTemplate(schema.Down)
+	}
+
+	ups := make([]string, 0, len(versions))
+	downs := make([]string, 0, len(versions))
+	requires := make([]string, 0)
+	for _, v := range versions {
+		schema := m.SchemaVersionMap[v]
+		squashed.Squashes = append(squashed.Squashes, m.squashedVersions(v)...)
+		requires = append(requires, schema.Requires...)
+		ups = append(ups, "-- "+v+".up.sql\n"+squashedScript(schema.Up, squashed.Template))
+		downs = append([]string{"-- " + v + ".down.sql\n" + squashedScript(schema.Down, squashed.Template)}, downs...)
+	}
+	sort.Strings(squashed.Squashes)
+	squashed.Requires = m.externalRequirements(requires, squashed.Squashes)
+
+	squashed.Up = strings.Join(ups, "\n")
+	squashed.Down = strings.Join(downs, "\n")
+
+	return squashed, nil
+}
+
+// externalRequirements drops duplicates and requirements on the versions
+// being squashed, which the squashed script satisfies itself
+func (m *MigrationManager) externalRequirements(requires, squashes []string) []string {
+	squashed := make(map[string]bool)
+	for _, v := range squashes {
+		squashed[v] = true
+	}
+
+	kept := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, text := range requires {
+		// Directives were checked when the scripts were registered
+		required, _ := ParseRequirement(text, m.Component)
+		if required.Component == m.Component && squashed[required.Version] {
+			continue
+		}
+
+		if !seen[required.String()] {
+			seen[required.String()] = true
+			kept = append(kept, text)
+		}
+	}
+
+	return kept
+}
+
+// squashedScript prepares one original script to be joined with the others.
+// Its last statement is terminated so it can't run into the first statement
+// of the next script, and if the squashed script is a template but this one
+// wasn't, any {{ in it is escaped so that it comes out as written.
+func squashedScript(sql string, template bool) string {
+	script := terminateStatements(stripDirectives(sql))
+	if template && !IsTemplate(sql) {
+		script = strings.ReplaceAll(script, "{{", `{{"{{"}}`)
+	}
+
+	return script
+}
+
+// terminateStatements adds a semicolon after the last statement of a script
+// if it doesn't already have one
+func terminateStatements(sql string) string {
+	if endsStatement(sql) {
+		return sql
+	}
+
+	trimmed := strings.TrimRight(sql, " \t\r\n")
+	terminated := trimmed + ";\n"
+	if !endsStatement(terminated) {
+		// The statement ends with a line comment, which would swallow the
+		// semicolon
+		terminated = trimmed + "\n;\n"
+	}
+
+	return terminated
+}
+
+// endsStatement reports whether anything appended to sql starts a new
+// statement rather than continuing its last one
+func endsStatement(sql string) bool {
+	return len(lint.Split(sql+"\nSELECT")) > len(lint.Split(sql))
+}
+
+// stripDirectives removes the directives describing the script as a whole,
+// which are replaced by those at the top of the squashed script, and makes
+// sure the script ends with a new line. Directives applying to the statements
+// around them, such as batch and lint-ignore, are kept where they are.
+func stripDirectives(sql string) string {
+	lines := strings.Split(sql, "\n")
+	kept := make([]string, 0, len(lines))
+	for _, line := range lines {
+		directive := parseDirectiveLine(line)
+		if directive != nil {
+			switch directive.Name {
+			case directiveSquashes, directiveRequires, directiveTemplate:
+				continue
+			}
+		}
+
+		kept = append(kept, line)
+	}
+
+	return strings.TrimRight(strings.Join(kept, "\n"), "\n") + "\n"
+}
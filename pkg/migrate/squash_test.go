@@ -0,0 +1,270 @@
+package migrate
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/crgwilson/pgm/pkg/lint"
+)
+
+func TestSquash(t *testing.T) {
+	testMigrator, _ := newTestMigrator(t, testPaths)
+
+	_, err := testMigrator.Squash("004")
+	if err != ErrSchemaVersionUnknown {
+		t.Errorf("got %v, want %v", err, ErrSchemaVersionUnknown)
+	}
+
+	_, err = testMigrator.Squash("001")
+	if err != ErrNothingToSquash {
+		t.Errorf("got %v, want %v", err, ErrNothingToSquash)
+	}
+
+	squashed, err := testMigrator.Squash("002")
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	wantUp := "-- +pgm squashes 001 002\n\n-- 001.up.sql\n001up;\n\n-- 002.up.sql\n002up;\n"
+	if squashed.UpFile() != wantUp {
+		t.Errorf("got %q, want %q", squashed.UpFile(), wantUp)
+	}
+
+	wantDown := "-- 002.down.sql\n002down;\n\n-- 001.down.sql\n001down;\n"
+	if squashed.DownFile() != wantDown {
+		t.Errorf("got %q, want %q", squashed.DownFile(), wantDown)
+	}
+
+	// Squashing again takes in the earlier squash
+	again := NewMigrationManager(NewMemoryMigrationStore(), nil)
+	for _, path := range []MigrationPath{
+		{Version: "002", Action: "up", Raw: []byte(squashed.UpFile())},
+		{Version: "002", Action: "down", Raw: []byte(squashed.DownFile())},
+		{Version: "003", Action: "up", Raw: []byte("003up")},
+		{Version: "003", Action: "down", Raw: []byte("003down")},
+	} {
+		err := again.RegisterMigrationPath(path)
+		if err != nil {
+			t.Fatalf("got %v, want no error", err)
+		}
+	}
+
+	squashed, err = again.Squash("003")
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	want := []string{"001", "002", "003"}
+	if !reflect.DeepEqual(squashed.Squashes, want) {
+		t.Errorf("got %v, want %v", squashed.Squashes, want)
+	}
+
+	if len(ParseDirectives(squashed.UpFile())) != 1 {
+		t.Errorf("got %v, want only the new squashes directive", ParseDirectives(squashed.UpFile()))
+	}
+}
+
+func TestSquashKeepsDirectives(t *testing.T) {
+	testMigrator, _ := newTestMigrator(t, []MigrationPath{
+		{Version: "001", Action: "up", Raw: []byte("-- +pgm requires billing/014\nCREATE TABLE account(id INT)")},
+		{Version: "001", Action: "down", Raw: []byte("DROP TABLE account")},
+		{Version: "002", Action: "up", Raw: []byte("-- +pgm requires 001 billing/014 auth/003\nALTER TABLE account ADD COLUMN name TEXT;\n-- +pgm batch size=100\nUPDATE account SET name='' WHERE id IN (SELECT id FROM account WHERE name IS NULL LIMIT $1)")},
+		{Version: "002", Action: "down", Raw: []byte("ALTER TABLE account DROP COLUMN name")},
+	})
+
+	squashed, err := testMigrator.Squash("002")
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	want := []Directive{
+		{Name: directiveSquashes, Args: []string{"001", "002"}},
+		{Name: directiveRequires, Args: []string{"billing/014", "auth/003"}},
+	}
+	if !reflect.DeepEqual(ParseDirectives(squashed.UpFile()), want) {
+		t.Errorf("got %v, want %v", ParseDirectives(squashed.UpFile()), want)
+	}
+
+	// The batch directive stays with the statement it applies to
+	sections, err := SplitSections(squashed.UpFile())
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	if len(sections) != 2 || sections[1].BatchSize != 100 {
+		t.Errorf("got %+v, want the UPDATE to still be batched", sections)
+	}
+}
+
+func TestSquashTerminatesStatements(t *testing.T) {
+	testMigrator, _ := newTestMigrator(t, []MigrationPath{
+		{Version: "001", Action: "up", Raw: []byte("CREATE TABLE a(id INT)")},
+		{Version: "001", Action: "down", Raw: []byte("DROP TABLE a -- and its data\n")},
+		{Version: "002", Action: "up", Raw: []byte("CREATE TABLE b(id INT);\nCREATE TABLE c(id INT)\n\n")},
+		{Version: "002", Action: "down", Raw: []byte("DROP TABLE c;\nDROP TABLE b;")},
+	})
+
+	squashed, err := testMigrator.Squash("002")
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	tests := []struct {
+		Name string
+		Sql  string
+		Want []string
+	}{
+		{"up", squashed.Up, []string{"CREATE TABLE A(ID INT)", "CREATE TABLE B(ID INT)", "CREATE TABLE C(ID INT)"}},
+		{"down", squashed.Down, []string{"DROP TABLE C", "DROP TABLE B", "DROP TABLE A"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			got := make([]string, 0)
+			for _, statement := range lint.Split(test.Sql) {
+				got = append(got, statement.Code)
+			}
+
+			if !reflect.DeepEqual(got, test.Want) {
+				t.Errorf("got %q, want %q", got, test.Want)
+			}
+		})
+	}
+}
+
+func TestSquashTemplates(t *testing.T) {
+	testMigrator, _ := newTestMigrator(t, []MigrationPath{
+		{Version: "001", Action: "up", Raw: []byte("INSERT INTO note VALUES ('{{ not a template }}');")},
+		{Version: "001", Action: "down", Raw: []byte("DELETE FROM note;")},
+		{Version: "002", Action: "up", Raw: []byte("-- +pgm template\nCREATE SCHEMA {{ ident .schema }};")},
+		{Version: "002", Action: "down", Raw: []byte("-- +pgm template\nDROP SCHEMA {{ ident .schema }};")},
+	})
+
+	squashed, err := testMigrator.Squash("002")
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	got, err := RenderTemplate("002.up.sql", squashed.UpFile(), map[string]string{"schema": "tenant"})
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	want := "-- +pgm squashes 001 002\n-- +pgm template\n\n-- 001.up.sql\nINSERT INTO note VALUES ('{{ not a template }}');\n\n-- 002.up.sql\nCREATE SCHEMA \"tenant\";\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// squashedPaths registers 002 as a squash of 001 and 002, followed by an
+// ordinary 003
+var squashedPaths = []MigrationPath{
+	{Version: "002", Action: "up", Raw: []byte("-- +pgm squashes 001 002\n002up")},
+	{Version: "002", Action: "down", Raw: []byte("002down")},
+	{Version: "003", Action: "up", Raw: []byte("003up")},
+	{Version: "003", Action: "down", Raw: []byte("003down")},
+}
+
+func TestSquashedMigrations(t *testing.T) {
+	t.Run("fresh database", func(t *testing.T) {
+		testMigrator, db := newTestMigrator(t, squashedPaths)
+
+		err := testMigrator.Up("003")
+		if err != nil {
+			t.Fatalf("got %v, want no error", err)
+		}
+
+		err = testMigrator.Down("002")
+		if err != nil {
+			t.Fatalf("got %v, want no error", err)
+		}
+
+		want := []string{"-- +pgm squashes 001 002\n002up", "003up", "003down"}
+		if !reflect.DeepEqual(db.Executed(), want) {
+			t.Errorf("got %v, want %v", db.Executed(), want)
+		}
+
+		history, _ := db.GetMigrationHistory()
+		if history[1].MigrationType != migrationTypeSquashed {
+			t.Errorf("got %q, want %q", history[1].MigrationType, migrationTypeSquashed)
+		}
+	})
+
+	t.Run("squashed version reverted", func(t *testing.T) {
+		testMigrator, _ := newTestMigrator(t, squashedPaths, WithHistory(
+			Migration{Version: "000"},
+			Migration{Version: "001", Name: "001", Direction: "up"},
+			Migration{Version: "002", Name: "002", Direction: "up"},
+			Migration{Version: "000", Name: "002", Direction: "down", MigrationType: migrationTypeSquashed},
+		))
+
+		status, err := testMigrator.Status()
+		if err != nil {
+			t.Fatalf("got %v, want no error", err)
+		}
+
+		for _, v := range status.Versions {
+			if v.State != StatusPending {
+				t.Errorf("got %q for version %s, want %q", v.State, v.Version, StatusPending)
+			}
+		}
+	})
+
+	t.Run("migrated before the squash", func(t *testing.T) {
+		testMigrator, db := newTestMigrator(t, squashedPaths, WithHistory(
+			Migration{Version: "000"},
+			Migration{Version: "001", Name: "001", Direction: "up"},
+			Migration{Version: "002", Name: "002", Direction: "up"},
+		))
+
+		err := testMigrator.Up("003")
+		if err != nil {
+			t.Fatalf("got %v, want no error", err)
+		}
+
+		want := []string{"003up"}
+		if !reflect.DeepEqual(db.Executed(), want) {
+			t.Errorf("got %v, want %v", db.Executed(), want)
+		}
+
+		status, err := testMigrator.Status()
+		if err != nil {
+			t.Fatalf("got %v, want no error", err)
+		}
+
+		for _, v := range status.Versions {
+			if v.State != StatusApplied {
+				t.Errorf("got %q for version %s, want %q", v.State, v.Version, StatusApplied)
+			}
+		}
+	})
+
+	t.Run("legacy history", func(t *testing.T) {
+		testMigrator, db := newTestMigrator(t, squashedPaths, WithHistory(
+			Migration{Version: "000"},
+			Migration{Version: "001"},
+			Migration{Version: "002"},
+		))
+
+		err := testMigrator.Up("003")
+		if err != nil {
+			t.Fatalf("got %v, want no error", err)
+		}
+
+		if len(db.Executed()) != 1 {
+			t.Errorf("got %v, want only 003 to run", db.Executed())
+		}
+	})
+
+	t.Run("partially migrated", func(t *testing.T) {
+		testMigrator, _ := newTestMigrator(t, squashedPaths, WithHistory(
+			Migration{Version: "000"},
+			Migration{Version: "001", Name: "001", Direction: "up"},
+		))
+
+		err := testMigrator.Up("003")
+		if err != ErrSquashPartiallyApplied {
+			t.Errorf("got %v, want %v", err, ErrSquashPartiallyApplied)
+		}
+	})
+}
@@ -0,0 +1,145 @@
+package migrate
+
+const (
+	StatusBaseline   = "baseline"
+	StatusApplied    = "applied"
+	StatusPending    = "pending"
+	StatusOutOfOrder = "out-of-order"
+	StatusOutdated   = "outdated"
+)
+
+type VersionStatus struct {
+	Version string
+	State   string
+}
+
+type RepeatableStatus struct {
+	Name     string
+	Checksum string
+	State    string
+}
+
+// Status summarises which migrations have been applied to the database and
+// which are still waiting to run
+type Status struct {
+	CurrentVersion  string
+	BaselineVersion string
+	// LatestVersion is the highest version with a migration
+	LatestVersion string
+	// Ahead is set when the current version is newer than every known one
+	Ahead       bool
+	Versions    []VersionStatus
+	Repeatables []RepeatableStatus
+}
+
+// Status reports the state of every migration. It returns ErrNoMigrations if
+// none have been registered.
+func (m *MigrationManager) Status() (Status, error) {
+	if len(m.SchemaVersions) == 0 {
+		return Status{}, ErrNoMigrations
+	}
+
+	currentVersion, err := m.CurrentVersion()
+	if err != nil {
+		return Status{}, err
+	}
+
+	baselineVersion, err := m.BaselineVersion()
+	if err != nil {
+		return Status{}, err
+	}
+
+	status := Status{
+		CurrentVersion:  currentVersion,
+		BaselineVersion: baselineVersion,
+		LatestVersion:   m.HighestAvailableVersion(),
+		Ahead:           m.databaseAhead(currentVersion),
+		Versions:        make([]VersionStatus, 0, len(m.SchemaVersions)),
+		Repeatables:     make([]RepeatableStatus, 0, len(m.RepeatableMap)),
+	}
+
+	applied, err := m.appliedVersions()
+	if err != nil {
+		return Status{}, err
+	}
+
+	for _, version := range m.SchemaVersions {
+		// Anything missing below the current version was skipped over
+		state, ok := applied[version]
+		if !ok && version < currentVersion {
+			state = StatusOutOfOrder
+		} else if !ok {
+			state = StatusPending
+		}
+
+		status.Versions = append(status.Versions, VersionStatus{
+			Version: version,
+			State:   state,
+		})
+	}
+
+	var checksums map[string]string
+	if len(m.RepeatableMap) > 0 {
+		checksums, err = m.Datastore.GetRepeatableChecksums()
+		if err != nil {
+			return Status{}, err
+		}
+	}
+
+	for _, name := range m.repeatableNames() {
+		repeatable := m.RepeatableMap[name]
+
+		applied, ok := checksums[name]
+		var state string
+		switch {
+		case !ok:
+			state = StatusPending
+		case applied != repeatable.Checksum:
+			state = StatusOutdated
+		default:
+			state = StatusApplied
+		}
+
+		status.Repeatables = append(status.Repeatables, RepeatableStatus{
+			Name:     name,
+			Checksum: repeatable.Checksum,
+			State:    state,
+		})
+	}
+
+	return status, nil
+}
+
+// PendingVersions returns the versions Up would apply next, in order
+func (m *MigrationManager) PendingVersions() ([]string, error) {
+	version, err := m.CurrentVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := m.appliedVersions()
+	if err != nil {
+		return nil, err
+	}
+
+	return m.pendingVersions(version, applied), nil
+}
+
+// pendingVersions works out PendingVersions from the current version and the
+// applied versions
+func (m *MigrationManager) pendingVersions(version string, applied map[string]string) []string {
+	pending := make([]string, 0)
+	for _, v := range m.SchemaVersions {
+		if _, ok := applied[v]; ok {
+			continue
+		}
+
+		if v < version && !m.AllowOutOfOrder {
+			continue
+		}
+
+		pending = append(pending, v)
+	}
+
+	return pending
+}
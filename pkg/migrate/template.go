@@ -0,0 +1,120 @@
+package migrate
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// templateEnvPrefix marks environment variables which are passed to templated
+// migrations, e.g. PGM_VAR_TENANT_SCHEMA becomes the variable tenant_schema
+const templateEnvPrefix = "PGM_VAR_"
+
+// IsTemplate reports whether a script asked to be rendered as a template with
+// a "-- +pgm template" directive. Scripts are only rendered when they ask to
+// be, so ordinary SQL containing {{ keeps working.
+func IsTemplate(sql string) bool {
+	for _, directive := range ParseDirectives(sql) {
+		if directive.Name == directiveTemplate {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RenderTemplate renders a templated migration using Go's text/template
+// syntax, e.g.
+//
+//	CREATE TABLE {{ ident .schema }}.accounts (id BIGINT);
+//	INSERT INTO settings VALUES ('region', {{ literal .region }});
+//
+// Referring to a variable which has not been defined is an error. Values are
+// inserted as is, so anything which isn't trusted should go through the ident
+// or literal functions, which quote it the way PostgreSQL's quote_ident and
+// quote_literal do.
+func RenderTemplate(name, sql string, vars map[string]string) (string, error) {
+	if vars == nil {
+		vars = make(map[string]string)
+	}
+
+	tmpl, err := template.New(name).Option("missingkey=error").Funcs(templateFuncs).Parse(sql)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidTemplate, err)
+	}
+
+	var b strings.Builder
+	err = tmpl.Execute(&b, vars)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidTemplate, err)
+	}
+
+	return b.String(), nil
+}
+
+var templateFuncs = template.FuncMap{
+	"ident":   QuoteIdent,
+	"literal": QuoteLiteral,
+}
+
+// QuoteIdent quotes a value for use as an identifier, e.g. a schema or table
+// name. The result is always quoted, so it is case sensitive.
+func QuoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// QuoteLiteral quotes a value for use as a string literal. Values containing
+// backslashes are written as E'...' escape strings so that they mean the same
+// thing whatever standard_conforming_strings is set to.
+func QuoteLiteral(value string) string {
+	quoted := "'" + strings.ReplaceAll(value, "'", "''") + "'"
+	if strings.Contains(value, `\`) {
+		return "E" + strings.ReplaceAll(quoted, `\`, `\\`)
+	}
+
+	return quoted
+}
+
+// TemplateVarsFromEnv collects template variables from PGM_VAR_ prefixed
+// environment variables, lower casing their names
+func TemplateVarsFromEnv(environ []string) map[string]string {
+	vars := make(map[string]string)
+	for _, env := range environ {
+		name, value, ok := strings.Cut(env, "=")
+		if !ok || !strings.HasPrefix(name, templateEnvPrefix) {
+			continue
+		}
+
+		vars[strings.ToLower(strings.TrimPrefix(name, templateEnvPrefix))] = value
+	}
+
+	return vars
+}
+
+// render returns the SQL which will actually run for a script, rendering it
+// if it is a template
+func (m *MigrationManager) render(name, sql string) (string, error) {
+	if !IsTemplate(sql) {
+		return sql, nil
+	}
+
+	return RenderTemplate(name, sql, m.TemplateVars)
+}
+
+// Rendered returns the SQL which will run for one direction of a version, with
+// any template rendered
+func (m *MigrationManager) Rendered(version, direction string) (string, error) {
+	schema, ok := m.SchemaVersionMap[version]
+	if !ok {
+		return "", ErrSchemaVersionUnknown
+	}
+
+	switch direction {
+	case "up":
+		return m.render(version+".up.sql", schema.Up)
+	case "down":
+		return m.render(version+".down.sql", schema.Down)
+	default:
+		return "", ErrInvalidAction
+	}
+}
@@ -0,0 +1,122 @@
+package migrate
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	vars := map[string]string{"schema": `Tenant "1"`, "region": "eu's", "path": `C:\data`}
+
+	tests := []struct {
+		Sql  string
+		Want string
+	}{
+		{"CREATE SCHEMA {{ ident .schema }};", `CREATE SCHEMA "Tenant ""1""";`},
+		{"SELECT {{ literal .region }};", "SELECT 'eu''s';"},
+		{"SELECT {{ literal .path }};", `SELECT E'C:\\data';`},
+		{"SELECT {{ .region }};", "SELECT eu's;"},
+	}
+
+	for _, test := range tests {
+		got, err := RenderTemplate("test.sql", test.Sql, vars)
+		if err != nil {
+			t.Errorf("got %v, want no error", err)
+		}
+
+		if got != test.Want {
+			t.Errorf("got %q, want %q", got, test.Want)
+		}
+	}
+
+	for _, sql := range []string{"SELECT {{ .missing }};", "SELECT {{ .schema "} {
+		_, err := RenderTemplate("test.sql", sql, vars)
+		if !errors.Is(err, ErrInvalidTemplate) {
+			t.Errorf("got %v, want %v", err, ErrInvalidTemplate)
+		}
+	}
+}
+
+func TestTemplateVarsFromEnv(t *testing.T) {
+	got := TemplateVarsFromEnv([]string{"PGM_VAR_TENANT_SCHEMA=tenant_1", "PGM_VAR_EMPTY=", "HOME=/root", "PGM_VARIANT=x"})
+	want := map[string]string{"tenant_schema": "tenant_1", "empty": ""}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestTemplatedMigrations(t *testing.T) {
+	up := "-- +pgm template\nCREATE SCHEMA {{ ident .schema }};"
+	plain := "SELECT '{{1,2}}'::INT[];"
+
+	testMigrator, db := newTestMigrator(t, testPaths)
+	for _, path := range []MigrationPath{
+		{Version: "004", Action: "up", Raw: []byte(up)},
+		{Version: "004", Action: "down", Raw: []byte("-- +pgm template\nDROP SCHEMA {{ ident .schema }};")},
+		{Version: "005", Action: "up", Raw: []byte(plain)},
+		{Version: "005", Action: "down", Raw: []byte("005down")},
+	} {
+		err := testMigrator.RegisterMigrationPath(path)
+		if err != nil {
+			t.Fatalf("got %v, want no error", err)
+		}
+	}
+
+	// Undefined variables stop the run before the template is applied
+	err := testMigrator.Up("005")
+	if !errors.Is(err, ErrInvalidTemplate) {
+		t.Errorf("got %v, want %v", err, ErrInvalidTemplate)
+	}
+
+	version, _ := testMigrator.CurrentVersion()
+	if version != "003" {
+		t.Errorf("got %v, want 003", version)
+	}
+
+	testMigrator.TemplateVars = map[string]string{"schema": "tenant_1"}
+
+	steps, err := testMigrator.PlanUp("005")
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	want := []PlannedStep{
+		{Version: "004", Direction: "up", Sql: "-- +pgm template\nCREATE SCHEMA \"tenant_1\";"},
+		{Version: "005", Direction: "up", Sql: plain},
+	}
+	if !reflect.DeepEqual(steps, want) {
+		t.Errorf("got %v, want %v", steps, want)
+	}
+
+	err = testMigrator.Up("005")
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	executed := db.Executed()
+	if executed[len(executed)-2] != want[0].Sql {
+		t.Errorf("got %q, want %q", executed[len(executed)-2], want[0].Sql)
+	}
+
+	// The checksum is of the script as written
+	history, _ := db.GetMigrationHistory()
+	for _, migration := range history {
+		if migration.Name == "004" && migration.Checksum != Checksum(up) {
+			t.Errorf("got %v, want %v", migration.Checksum, Checksum(up))
+		}
+	}
+
+	steps, err = testMigrator.PlanDown("003")
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	want = []PlannedStep{
+		{Version: "005", Direction: "down", Sql: "005down"},
+		{Version: "004", Direction: "down", Sql: "-- +pgm template\nDROP SCHEMA \"tenant_1\";"},
+	}
+	if !reflect.DeepEqual(steps, want) {
+		t.Errorf("got %v, want %v", steps, want)
+	}
+}
@@ -0,0 +1,103 @@
+package migrate
+
+import (
+	"context"
+	"strconv"
+	"sync"
+)
+
+// Tracer starts spans. It is kept to what pgm needs so that an OpenTelemetry
+// trace.Tracer, or any other tracing library, can be adapted to it in a few
+// lines without pgm depending on it.
+type Tracer interface {
+	Start(ctx context.Context, name string, attributes map[string]string) (context.Context, Span)
+}
+
+// Span is a single traced operation started by a Tracer
+type Span interface {
+	RecordError(err error)
+	End()
+}
+
+// Span attributes set on every run and step
+const (
+	AttributeComponent  = "pgm.component"
+	AttributeDirection  = "pgm.direction"
+	AttributeVersion    = "pgm.version"
+	AttributeFrom       = "pgm.from_version"
+	AttributeTarget     = "pgm.target_version"
+	AttributeRepeatable = "pgm.repeatable"
+)
+
+// TraceSpans returns hooks which trace every run of the manager as a span
+// under ctx, with a child span for each of its steps
+func (m *MigrationManager) TraceSpans(ctx context.Context, tracer Tracer) Hooks {
+	var mu sync.Mutex
+	var runCtx context.Context
+	var runSpan, stepSpan Span
+
+	end := func(span Span, err error) {
+		if span == nil {
+			return
+		}
+
+		if err != nil {
+			span.RecordError(err)
+		}
+		span.End()
+	}
+
+	return Hooks{
+		BeforeRun: func(run RunInfo) error {
+			mu.Lock()
+			defer mu.Unlock()
+
+			runCtx, runSpan = tracer.Start(ctx, "pgm "+run.Direction, map[string]string{
+				AttributeComponent: m.Component,
+				AttributeDirection: run.Direction,
+				AttributeFrom:      run.FromVersion,
+				AttributeTarget:    run.TargetVersion,
+			})
+
+			return nil
+		},
+		BeforeStep: func(step StepInfo) error {
+			mu.Lock()
+			defer mu.Unlock()
+
+			parent := runCtx
+			if parent == nil {
+				parent = ctx
+			}
+
+			_, stepSpan = tracer.Start(parent, "pgm "+step.Direction+" "+step.Version, map[string]string{
+				AttributeComponent:  m.Component,
+				AttributeDirection:  step.Direction,
+				AttributeVersion:    step.Version,
+				AttributeRepeatable: strconv.FormatBool(step.Repeatable),
+			})
+
+			return nil
+		},
+		AfterStep: func(step StepInfo) error {
+			mu.Lock()
+			defer mu.Unlock()
+
+			end(stepSpan, step.Err)
+			stepSpan = nil
+
+			return nil
+		},
+		AfterRun: func(run RunInfo) error {
+			mu.Lock()
+			defer mu.Unlock()
+
+			// A step whose before hooks failed never reaches AfterStep
+			end(stepSpan, run.Err)
+			end(runSpan, run.Err)
+			runCtx, runSpan, stepSpan = nil, nil, nil
+
+			return nil
+		},
+	}
+}
@@ -0,0 +1,60 @@
+package migrate
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+type recordingSpan struct {
+	tracer *recordingTracer
+	name   string
+}
+
+func (s recordingSpan) RecordError(err error) {
+	s.tracer.events = append(s.tracer.events, "error "+s.name+": "+err.Error())
+}
+
+func (s recordingSpan) End() {
+	s.tracer.events = append(s.tracer.events, "end "+s.name)
+}
+
+type parentKey struct{}
+
+type recordingTracer struct {
+	events []string
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string, attributes map[string]string) (context.Context, Span) {
+	parent, _ := ctx.Value(parentKey{}).(string)
+	t.events = append(t.events, "start "+name+" under "+parent+" at "+attributes[AttributeVersion]+attributes[AttributeTarget])
+
+	return context.WithValue(ctx, parentKey{}, name), recordingSpan{tracer: t, name: name}
+}
+
+func TestTraceSpans(t *testing.T) {
+	testMigrator, _ := newTestMigrator(t, testPaths, FailOnVersion("002"))
+
+	tracer := &recordingTracer{}
+	ctx := context.WithValue(context.Background(), parentKey{}, "deploy")
+	testMigrator.AddHooks(testMigrator.TraceSpans(ctx, tracer))
+
+	err := testMigrator.Up("002")
+	if err != ErrInjectedMigrationFailure {
+		t.Fatalf("got %v, want %v", err, ErrInjectedMigrationFailure)
+	}
+
+	want := []string{
+		"start pgm up under deploy at 002",
+		"start pgm up 001 under pgm up at 001",
+		"end pgm up 001",
+		"start pgm up 002 under pgm up at 002",
+		"error pgm up 002: Injected migration failure",
+		"end pgm up 002",
+		"error pgm up: Injected migration failure",
+		"end pgm up",
+	}
+	if !reflect.DeepEqual(tracer.events, want) {
+		t.Errorf("got %v, want %v", tracer.events, want)
+	}
+}
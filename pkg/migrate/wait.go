@@ -0,0 +1,74 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/crgwilson/pgm/pkg/logger"
+)
+
+// DefaultWaitInterval is how often WaitForVersion checks the database unless
+// told otherwise
+const DefaultWaitInterval = 2 * time.Second
+
+// reachedVersion reports whether a database at version current is at or past
+// target. Versions this manager doesn't know, such as those of a newer
+// release, are compared by name.
+func (m *MigrationManager) reachedVersion(current, target string) bool {
+	currentIdx, currentErr := m.getVersionIndex(current)
+	targetIdx, targetErr := m.getVersionIndex(target)
+	if currentErr == nil && targetErr == nil {
+		return currentIdx >= targetIdx
+	}
+
+	return current >= target
+}
+
+// WaitForVersion blocks until the database is at or past targetVersion,
+// checking every interval, and gives up with ErrWaitTimedOut once ctx is
+// done. A database which can't be reached or hasn't been initialized yet is
+// waited on like any other, so this can run before a migration job has even
+// started.
+func (m *MigrationManager) WaitForVersion(ctx context.Context, targetVersion string, interval time.Duration) error {
+	if !m.isKnownVersion(targetVersion) {
+		return ErrSchemaVersionUnknown
+	}
+
+	if interval <= 0 {
+		interval = DefaultWaitInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	m.Logger.Info("Waiting for schema version "+targetVersion, logger.Version(targetVersion))
+
+	current := ""
+	var lastErr error
+	for {
+		version, err := m.Datastore.GetCurrentSchemaVersion()
+		if err == nil && m.reachedVersion(version, targetVersion) {
+			m.Logger.Info("Reached schema version "+version, logger.Version(version))
+			return nil
+		}
+
+		if err == nil {
+			current = version
+			m.Logger.Debug("Still waiting for schema version "+targetVersion, logger.Version(version))
+		} else {
+			lastErr = err
+			m.Logger.Debug("Unable to read schema version", logger.F("error", err))
+		}
+
+		select {
+		case <-ctx.Done():
+			if current == "" && lastErr != nil {
+				return fmt.Errorf("%w for version %s: %v", ErrWaitTimedOut, targetVersion, lastErr)
+			}
+
+			return fmt.Errorf("%w for version %s, the database is at version %s", ErrWaitTimedOut, targetVersion, current)
+		case <-ticker.C:
+		}
+	}
+}
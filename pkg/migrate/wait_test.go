@@ -0,0 +1,65 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWaitForVersion(t *testing.T) {
+	testMigrator, _ := newTestMigrator(t, testPaths)
+
+	// Another process migrates the database while we wait
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		testMigrator.Up("002")
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := testMigrator.WaitForVersion(ctx, "002", time.Millisecond)
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	// Already past the target
+	err = testMigrator.WaitForVersion(ctx, "001", time.Millisecond)
+	if err != nil {
+		t.Errorf("got %v, want no error", err)
+	}
+
+	err = testMigrator.WaitForVersion(ctx, "999", time.Millisecond)
+	if err != ErrSchemaVersionUnknown {
+		t.Errorf("got %v, want %v", err, ErrSchemaVersionUnknown)
+	}
+}
+
+func TestWaitForVersionTimesOut(t *testing.T) {
+	tests := []struct {
+		Name        string
+		Initialized bool
+		Want        string
+	}{
+		{"behind", true, "Timed out waiting for the database to be migrated for version 003, the database is at version 000"},
+		{"not initialized", false, "Timed out waiting for the database to be migrated for version 003: Migration table could not be found"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			testMigrator, _ := newTestMigrator(t, testPaths)
+			if !test.Initialized {
+				testMigrator.Datastore = NewMemoryMigrationStore()
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+
+			err := testMigrator.WaitForVersion(ctx, "003", time.Millisecond)
+			if !errors.Is(err, ErrWaitTimedOut) || err.Error() != test.Want {
+				t.Errorf("got %v, want %q", err, test.Want)
+			}
+		})
+	}
+}
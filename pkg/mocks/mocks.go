@@ -4,13 +4,13 @@ import (
 	"fmt"
 )
 
+// SpyLogger records every line written to it so tests can inspect them
 type SpyLogger struct {
 	Logs []string
 }
 
-func (s SpyLogger) Println(v ...interface{}) {
-	newLogs := append(s.Logs, fmt.Sprint(v...))
-	s.Logs = newLogs
+func (s *SpyLogger) Println(v ...interface{}) {
+	s.Logs = append(s.Logs, fmt.Sprint(v...))
 }
 
 func NewSpyLogger() *SpyLogger {
@@ -3,10 +3,16 @@ package pg
 import (
 	"errors"
 	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
 )
 
+const defaultPort = 5432
+
 var ErrMissingAddress = errors.New("Database address is required but not set")
 var ErrMissingPort = errors.New("Database port number is required but not set")
+var ErrInvalidConnectionString = errors.New("Connection string must be a postgres:// URL")
 
 type PostgresConfig struct {
 	Address  string
@@ -20,12 +26,11 @@ type PostgresConfig struct {
 func (c PostgresConfig) ConnectionString() (string, error) {
 	connString := "postgres://"
 
-	if c.User != "" {
-		connString = connString + c.User
-	}
-
+	// Credentials are escaped so that passwords may contain characters like '@'
 	if c.User != "" && c.Password != "" {
-		connString = connString + ":" + c.Password + "@"
+		connString = connString + url.UserPassword(c.User, c.Password).String() + "@"
+	} else if c.User != "" {
+		connString = connString + url.User(c.User).String() + "@"
 	}
 
 	if c.Address == "" {
@@ -51,3 +56,40 @@ func (c PostgresConfig) ConnectionString() (string, error) {
 	return connString, nil
 }
 
+// ParseConnectionString builds a PostgresConfig from a postgres:// URL, the
+// inverse of ConnectionString
+func ParseConnectionString(connString string) (PostgresConfig, error) {
+	u, err := url.Parse(connString)
+	if err != nil {
+		return PostgresConfig{}, err
+	}
+
+	if u.Scheme != "postgres" && u.Scheme != "postgresql" {
+		return PostgresConfig{}, ErrInvalidConnectionString
+	}
+
+	if u.Hostname() == "" {
+		return PostgresConfig{}, ErrMissingAddress
+	}
+
+	port := defaultPort
+	if u.Port() != "" {
+		port, err = strconv.Atoi(u.Port())
+		if err != nil {
+			return PostgresConfig{}, ErrInvalidConnectionString
+		}
+	}
+
+	password, _ := u.User.Password()
+
+	config := PostgresConfig{
+		Address:  u.Hostname(),
+		Port:     port,
+		User:     u.User.Username(),
+		Password: password,
+		Database: strings.TrimPrefix(u.Path, "/"),
+		SslMode:  u.Query().Get("sslmode"),
+	}
+
+	return config, nil
+}
@@ -1,7 +1,10 @@
 package pg
 
 import (
+	"context"
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
 
 	_ "github.com/lib/pq"
 )
@@ -21,3 +24,96 @@ func OpenDb(connConfig PostgresConfig) (*sql.DB, error) {
 
 	return db, nil
 }
+
+// RandomName appends a random suffix to prefix, for naming throwaway
+// databases and schemas
+func RandomName(prefix string) (string, error) {
+	b := make([]byte, 6)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return prefix + hex.EncodeToString(b), nil
+}
+
+// CreateScratchDatabase creates an empty database with a random name on the
+// same server as connConfig, returning the config needed to connect to it and
+// a function which drops it again
+func CreateScratchDatabase(connConfig PostgresConfig) (PostgresConfig, func() error, error) {
+	name, err := RandomName("pgm_scratch_")
+	if err != nil {
+		return PostgresConfig{}, nil, err
+	}
+
+	admin, err := OpenDb(connConfig)
+	if err != nil {
+		return PostgresConfig{}, nil, err
+	}
+	defer admin.Close()
+
+	_, err = admin.Exec("CREATE DATABASE " + name)
+	if err != nil {
+		return PostgresConfig{}, nil, err
+	}
+
+	scratchConfig := connConfig
+	scratchConfig.Database = name
+
+	drop := func() error {
+		return DropDatabase(connConfig, name)
+	}
+
+	return scratchConfig, drop, nil
+}
+
+// DropDatabase drops the named database on the same server as connConfig,
+// disconnecting anyone still using it
+func DropDatabase(connConfig PostgresConfig, name string) error {
+	admin, err := OpenDb(connConfig)
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+
+	_, err = admin.Exec("SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE datname=$1 AND pid<>pg_backend_pid()", name)
+	if err != nil {
+		return err
+	}
+
+	_, err = admin.Exec("DROP DATABASE IF EXISTS " + name)
+
+	return err
+}
+
+// SingleConn runs every statement on the same pooled connection, so session
+// state such as search_path carries over from one statement to the next
+type SingleConn struct {
+	Conn *sql.Conn
+}
+
+func (c SingleConn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.Conn.ExecContext(context.Background(), query, args...)
+}
+
+func (c SingleConn) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.Conn.QueryContext(context.Background(), query, args...)
+}
+
+func (c SingleConn) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.Conn.QueryRowContext(context.Background(), query, args...)
+}
+
+func (c SingleConn) Close() error {
+	return c.Conn.Close()
+}
+
+// NewSingleConn pins a connection from db for the caller's exclusive use
+func NewSingleConn(db *sql.DB) (SingleConn, error) {
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return SingleConn{}, err
+	}
+
+	return SingleConn{Conn: conn}, nil
+}
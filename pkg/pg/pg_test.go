@@ -0,0 +1,32 @@
+package pg_test
+
+import (
+	"testing"
+
+	"github.com/crgwilson/pgm/pkg/pg"
+	"github.com/crgwilson/pgm/pkg/pgtest"
+)
+
+func TestMain(m *testing.M) {
+	pgtest.Main(m)
+}
+
+func TestOpenDb(t *testing.T) {
+	_, config := pgtest.NewDatabase(t)
+
+	db, err := pg.OpenDb(config)
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+	defer db.Close()
+
+	var database string
+	err = db.QueryRow("SELECT current_database()").Scan(&database)
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	if database != config.Database {
+		t.Errorf("got %q, want %q", database, config.Database)
+	}
+}
@@ -0,0 +1,247 @@
+// Package pgtest provides throwaway PostgreSQL databases for integration
+// tests.
+//
+// A server is found by looking at PGM_TEST_DATABASE_URL first, which should
+// point at a role allowed to create databases. Failing that, if the PostgreSQL
+// server binaries (initdb and pg_ctl) are installed, a private server is
+// started in a temporary directory. When neither is available tests using
+// this package are skipped.
+package pgtest
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/crgwilson/pgm/pkg/pg"
+)
+
+const DatabaseUrlEnv = "PGM_TEST_DATABASE_URL"
+
+const serverStartTimeout = 30 * time.Second
+
+var ErrNoServer = errors.New("No PostgreSQL server available, set " + DatabaseUrlEnv + " or install initdb and pg_ctl")
+
+// Server is a PostgreSQL server which test databases are created in
+type Server struct {
+	// URL connects to the server's maintenance database
+	URL string
+
+	dataDir string
+	pgCtl   string
+}
+
+var (
+	serverOnce sync.Once
+	server     *Server
+	serverErr  error
+)
+
+// Main wraps testing.M, stopping any server started by this package once the
+// tests have finished. Use it from TestMain in packages with integration tests.
+func Main(m *testing.M) {
+	code := m.Run()
+
+	if server != nil {
+		server.Stop()
+	}
+
+	os.Exit(code)
+}
+
+// GetServer returns the shared test server, starting a private one on first
+// use if PGM_TEST_DATABASE_URL is not set
+func GetServer() (*Server, error) {
+	serverOnce.Do(func() {
+		databaseUrl := os.Getenv(DatabaseUrlEnv)
+		if databaseUrl != "" {
+			server = &Server{URL: databaseUrl}
+			return
+		}
+
+		server, serverErr = StartServer()
+	})
+
+	return server, serverErr
+}
+
+// findBinary looks for a PostgreSQL server binary in PATH and then in the
+// usual distribution package locations
+func findBinary(name string) (string, error) {
+	path, err := exec.LookPath(name)
+	if err == nil {
+		return path, nil
+	}
+
+	patterns := []string{
+		"/usr/lib/postgresql/*/bin/" + name,
+		"/usr/pgsql-*/bin/" + name,
+		"/usr/local/opt/postgresql*/bin/" + name,
+	}
+	for _, pattern := range patterns {
+		matches, _ := filepath.Glob(pattern)
+		if len(matches) > 0 {
+			// Prefer the newest version installed
+			sort.Strings(matches)
+			return matches[len(matches)-1], nil
+		}
+	}
+
+	return "", ErrNoServer
+}
+
+func freePort() (int, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
+
+// StartServer runs initdb in a temporary directory and starts a server there
+// with pg_ctl, listening on a free local port and trusting every connection
+func StartServer() (*Server, error) {
+	initDb, err := findBinary("initdb")
+	if err != nil {
+		return nil, err
+	}
+
+	pgCtl, err := findBinary("pg_ctl")
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := ioutil.TempDir("", "pgm-pgtest")
+	if err != nil {
+		return nil, err
+	}
+	dataDir := filepath.Join(dir, "data")
+
+	out, err := exec.Command(initDb, "-D", dataDir, "-U", "postgres", "--auth=trust", "--no-sync").CombinedOutput()
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("initdb failed: %v: %s", err, out)
+	}
+
+	port, err := freePort()
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	options := fmt.Sprintf("-p %d -k %s -c listen_addresses=127.0.0.1 -c fsync=off", port, dir)
+	logFile := filepath.Join(dir, "postgres.log")
+	out, err = exec.Command(pgCtl, "start", "-w", "-D", dataDir, "-l", logFile, "-o", options, "-t", strconv.Itoa(int(serverStartTimeout.Seconds()))).CombinedOutput()
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("pg_ctl start failed: %v: %s", err, out)
+	}
+
+	s := Server{
+		URL:     fmt.Sprintf("postgres://postgres@127.0.0.1:%d/postgres?sslmode=disable", port),
+		dataDir: dataDir,
+		pgCtl:   pgCtl,
+	}
+
+	return &s, nil
+}
+
+// Stop shuts down and removes a server started by StartServer. Servers given
+// through PGM_TEST_DATABASE_URL are left alone.
+func (s *Server) Stop() error {
+	if s.pgCtl == "" {
+		return nil
+	}
+
+	out, err := exec.Command(s.pgCtl, "stop", "-D", s.dataDir, "-m", "fast").CombinedOutput()
+	os.RemoveAll(filepath.Dir(s.dataDir))
+	if err != nil {
+		return fmt.Errorf("pg_ctl stop failed: %v: %s", err, out)
+	}
+
+	return nil
+}
+
+// CreateDatabase creates an empty database on the server, returning the
+// config needed to connect to it
+func (s *Server) CreateDatabase() (pg.PostgresConfig, error) {
+	name, err := pg.RandomName("pgm_test_")
+	if err != nil {
+		return pg.PostgresConfig{}, err
+	}
+
+	admin, err := sql.Open("postgres", s.URL)
+	if err != nil {
+		return pg.PostgresConfig{}, err
+	}
+	defer admin.Close()
+
+	_, err = admin.Exec("CREATE DATABASE " + name)
+	if err != nil {
+		return pg.PostgresConfig{}, err
+	}
+
+	config, err := pg.ParseConnectionString(s.URL)
+	if err != nil {
+		return pg.PostgresConfig{}, err
+	}
+	config.Database = name
+
+	return config, nil
+}
+
+// DropDatabase drops a database made by CreateDatabase, disconnecting anyone
+// still using it
+func (s *Server) DropDatabase(name string) error {
+	config, err := pg.ParseConnectionString(s.URL)
+	if err != nil {
+		return err
+	}
+
+	return pg.DropDatabase(config, name)
+}
+
+// NewDatabase creates an isolated database for a single test and opens a
+// connection to it. Both are cleaned up when the test finishes. The test is
+// skipped if there is no server available.
+func NewDatabase(t testing.TB) (*sql.DB, pg.PostgresConfig) {
+	t.Helper()
+
+	s, err := GetServer()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	config, err := s.CreateDatabase()
+	if err != nil {
+		t.Fatalf("unable to create test database: %v", err)
+	}
+
+	db, err := pg.OpenDb(config)
+	if err != nil {
+		t.Fatalf("unable to open test database: %v", err)
+	}
+
+	t.Cleanup(func() {
+		db.Close()
+
+		err := s.DropDatabase(config.Database)
+		if err != nil {
+			t.Errorf("unable to drop test database: %v", err)
+		}
+	})
+
+	return db, config
+}
@@ -0,0 +1,223 @@
+// Package server reports on a database's migrations over HTTP, so that pgm
+// can run as a sidecar answering readiness checks and, optionally, apply
+// pending migrations on request
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/crgwilson/pgm/pkg/logger"
+	"github.com/crgwilson/pgm/pkg/migrate"
+)
+
+// Status is the body of a /status response
+type Status struct {
+	Component       string   `json:"component,omitempty"`
+	CurrentVersion  string   `json:"current_version"`
+	LatestVersion   string   `json:"latest_version"`
+	BaselineVersion string   `json:"baseline_version,omitempty"`
+	UpToDate        bool     `json:"up_to_date"`
+	Ahead           bool     `json:"ahead,omitempty"`
+	Pending         []string `json:"pending"`
+	OutOfOrder      []string `json:"out_of_order,omitempty"`
+	Repeatables     []string `json:"pending_repeatables,omitempty"`
+	Error           string   `json:"error,omitempty"`
+}
+
+// MigrateResult is the body of a /migrate response
+type MigrateResult struct {
+	FromVersion string `json:"from_version,omitempty"`
+	ToVersion   string `json:"to_version,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// Server serves the following endpoints for a single migration manager:
+//
+//	GET  /healthz  200 if the migration table can be read, otherwise 503
+//	GET  /status   the current and pending versions, with 200 if nothing is
+//	               pending and 503 if anything is, so it can serve as a
+//	               readiness check
+//	POST /migrate  applies every pending migration, only enabled when a token
+//	               is set and only for requests bearing it
+type Server struct {
+	Migrator *migrate.MigrationManager
+	// Token must be sent as "Authorization: Bearer <token>" to /migrate,
+	// which is disabled when it is empty
+	Token string
+
+	// running stops a second /migrate from starting while one is underway
+	running sync.Mutex
+	mux     *http.ServeMux
+}
+
+func NewServer(migrator *migrate.MigrationManager, token string) *Server {
+	s := Server{
+		Migrator: migrator,
+		Token:    token,
+		mux:      http.NewServeMux(),
+	}
+
+	s.mux.HandleFunc("/healthz", s.healthz)
+	s.mux.HandleFunc("/status", s.status)
+	s.mux.HandleFunc("/migrate", s.migrate)
+
+	return &s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func writeJson(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
+
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	_, err := s.Migrator.CurrentVersion()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Write([]byte("ok\n"))
+}
+
+// Report works out the status served at /status
+func (s *Server) Report() (Status, error) {
+	report := Status{
+		Component: s.Migrator.Component,
+		Pending:   make([]string, 0),
+	}
+
+	status, err := s.Migrator.Status()
+	if err != nil {
+		return report, err
+	}
+
+	report.LatestVersion = status.LatestVersion
+	report.CurrentVersion = status.CurrentVersion
+	report.BaselineVersion = status.BaselineVersion
+	report.Ahead = status.Ahead
+
+	for _, v := range status.Versions {
+		switch v.State {
+		case migrate.StatusPending:
+			report.Pending = append(report.Pending, v.Version)
+		case migrate.StatusOutOfOrder:
+			report.OutOfOrder = append(report.OutOfOrder, v.Version)
+		}
+	}
+
+	for _, r := range status.Repeatables {
+		if r.State != migrate.StatusApplied {
+			report.Repeatables = append(report.Repeatables, r.Name)
+		}
+	}
+
+	report.UpToDate = len(report.Pending) == 0 && len(report.Repeatables) == 0
+	return report, nil
+}
+
+func (s *Server) status(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	report, err := s.Report()
+	if err != nil {
+		report.Error = err.Error()
+		writeJson(w, http.StatusServiceUnavailable, report)
+		return
+	}
+
+	code := http.StatusOK
+	if !report.UpToDate {
+		code = http.StatusServiceUnavailable
+	}
+
+	writeJson(w, code, report)
+}
+
+// authorized checks the request's bearer token in constant time
+func (s *Server) authorized(r *http.Request) bool {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) == 1
+}
+
+func (s *Server) migrate(w http.ResponseWriter, r *http.Request) {
+	if s.Token == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if !s.running.TryLock() {
+		writeJson(w, http.StatusConflict, MigrateResult{Error: migrate.ErrMigrationLocked.Error()})
+		return
+	}
+	defer s.running.Unlock()
+
+	result := MigrateResult{}
+
+	if len(s.Migrator.SchemaVersions) == 0 {
+		result.Error = migrate.ErrNoMigrations.Error()
+		writeJson(w, http.StatusServiceUnavailable, result)
+		return
+	}
+
+	from, err := s.Migrator.CurrentVersion()
+	if err != nil {
+		result.Error = err.Error()
+		writeJson(w, http.StatusServiceUnavailable, result)
+		return
+	}
+	result.FromVersion = from
+
+	// Up holds the migration lock for as long as it runs, so another copy of
+	// pgm can't migrate the database at the same time
+	err = s.Migrator.Up(s.Migrator.HighestAvailableVersion())
+	if errors.Is(err, migrate.ErrAlreadyReachedTargetVersion) {
+		err = nil
+	}
+
+	result.ToVersion, _ = s.Migrator.CurrentVersion()
+
+	switch {
+	case errors.Is(err, migrate.ErrMigrationLocked):
+		result.Error = err.Error()
+		writeJson(w, http.StatusConflict, result)
+	case err != nil:
+		s.Migrator.Logger.Error("Migration requested over HTTP failed", logger.F("error", err))
+		result.Error = err.Error()
+		writeJson(w, http.StatusInternalServerError, result)
+	default:
+		writeJson(w, http.StatusOK, result)
+	}
+}
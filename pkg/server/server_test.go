@@ -0,0 +1,192 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	"github.com/crgwilson/pgm/pkg/migrate"
+)
+
+var migrations = fstest.MapFS{
+	"001.up.sql": {Data: []byte("SELECT 1;")},
+	"002.up.sql": {Data: []byte("SELECT 1;")},
+}
+
+func request(s *Server, method, path, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+
+	return w
+}
+
+func TestStatus(t *testing.T) {
+	migrator := migrate.NewMigrationManager(migrate.NewMemoryMigrationStore(), nil)
+	_, _, err := migrator.LoadFS(migrations)
+	if err == nil {
+		err = migrator.InitDb()
+	}
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	s := NewServer(migrator, "secret")
+
+	w := request(s, http.MethodGet, "/status", "")
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("got %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+
+	var status Status
+	err = json.NewDecoder(w.Body).Decode(&status)
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	want := Status{CurrentVersion: "000", LatestVersion: "002", Pending: []string{"001", "002"}}
+	if !reflect.DeepEqual(status, want) {
+		t.Errorf("got %+v, want %+v", status, want)
+	}
+
+	w = request(s, http.MethodPost, "/migrate", "secret")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %s, want %d", w.Code, w.Body, http.StatusOK)
+	}
+
+	var result MigrateResult
+	json.NewDecoder(w.Body).Decode(&result)
+	if result != (MigrateResult{FromVersion: "000", ToVersion: "002"}) {
+		t.Errorf("got %+v, want 000 to 002", result)
+	}
+
+	w = request(s, http.MethodGet, "/status", "")
+	if w.Code != http.StatusOK {
+		t.Errorf("got %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	migrator := migrate.NewMigrationManager(migrate.NewMemoryMigrationStore(), nil)
+	_, _, err := migrator.LoadFS(migrations)
+	if err == nil {
+		err = migrator.InitDb()
+	}
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	s := NewServer(migrator, "")
+
+	w := request(s, http.MethodGet, "/healthz", "")
+	if w.Code != http.StatusOK {
+		t.Errorf("got %d, want %d", w.Code, http.StatusOK)
+	}
+
+	// A store which has never been initialized has no migration table
+	s.Migrator.Datastore = migrate.NewMemoryMigrationStore()
+	w = request(s, http.MethodGet, "/healthz", "")
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("got %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestMigrateAuthorization(t *testing.T) {
+	tests := []struct {
+		Name       string
+		Configured string
+		Method     string
+		Sent       string
+		Want       int
+	}{
+		{"disabled", "", http.MethodPost, "", http.StatusNotFound},
+		{"no token", "secret", http.MethodPost, "", http.StatusUnauthorized},
+		{"wrong token", "secret", http.MethodPost, "guess", http.StatusUnauthorized},
+		{"wrong method", "secret", http.MethodGet, "secret", http.StatusMethodNotAllowed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			migrator := migrate.NewMigrationManager(migrate.NewMemoryMigrationStore(), nil)
+			_, _, err := migrator.LoadFS(migrations)
+			if err == nil {
+				err = migrator.InitDb()
+			}
+			if err != nil {
+				t.Fatalf("got %v, want no error", err)
+			}
+
+			s := NewServer(migrator, test.Configured)
+
+			w := request(s, test.Method, "/migrate", test.Sent)
+			if w.Code != test.Want {
+				t.Errorf("got %d, want %d", w.Code, test.Want)
+			}
+
+			version, _ := s.Migrator.CurrentVersion()
+			if version != "000" {
+				t.Errorf("got %v, want 000", version)
+			}
+		})
+	}
+}
+
+func TestMigrateLocked(t *testing.T) {
+	migrator := migrate.NewMigrationManager(migrate.NewMemoryMigrationStore(migrate.FailOnLock()), nil)
+	_, _, err := migrator.LoadFS(migrations)
+	if err == nil {
+		err = migrator.InitDb()
+	}
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	s := NewServer(migrator, "secret")
+
+	w := request(s, http.MethodPost, "/migrate", "secret")
+	if w.Code != http.StatusConflict {
+		t.Errorf("got %d, want %d", w.Code, http.StatusConflict)
+	}
+}
+
+func TestNoMigrations(t *testing.T) {
+	migrator := migrate.NewMigrationManager(migrate.NewMemoryMigrationStore(), nil)
+	err := migrator.InitDb()
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	s := NewServer(migrator, "secret")
+
+	for _, test := range []struct {
+		Method string
+		Path   string
+	}{
+		{http.MethodGet, "/status"},
+		{http.MethodPost, "/migrate"},
+	} {
+		t.Run(test.Path, func(t *testing.T) {
+			w := request(s, test.Method, test.Path, "secret")
+			if w.Code != http.StatusServiceUnavailable {
+				t.Errorf("got %d, want %d", w.Code, http.StatusServiceUnavailable)
+			}
+
+			var body struct{ Error string }
+			err := json.NewDecoder(w.Body).Decode(&body)
+			if err != nil {
+				t.Fatalf("got %v, want no error", err)
+			}
+
+			if body.Error != migrate.ErrNoMigrations.Error() {
+				t.Errorf("got %q, want %q", body.Error, migrate.ErrNoMigrations.Error())
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package tenant
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrSkipped = errors.New("Skipped since another tenant failed")
+
+// Result is the outcome of migrating a single tenant
+type Result struct {
+	Tenant      string
+	FromVersion string
+	ToVersion   string
+	Duration    time.Duration
+	// Err is ErrSkipped for tenants which were never started because an
+	// earlier one failed in fail fast mode
+	Err error
+}
+
+func (r Result) Ok() bool {
+	return r.Err == nil
+}
+
+func (r Result) Skipped() bool {
+	return r.Err == ErrSkipped
+}
+
+// Runner migrates tenants concurrently
+type Runner struct {
+	// Parallelism is the most tenants worked on at once, at least 1
+	Parallelism int
+	// FailFast stops starting new tenants once any tenant has failed. Tenants
+	// already in progress are left to finish.
+	FailFast bool
+}
+
+// Run calls f for every tenant, which fills in the versions of the result it
+// is given. Tenants are started in the order given and results are returned
+// in the same order.
+func (r Runner) Run(tenants []string, f func(result *Result) error) []Result {
+	parallelism := r.Parallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	results := make([]Result, len(tenants))
+	slots := make(chan struct{}, parallelism)
+
+	var mu sync.Mutex
+	failed := false
+
+	var wg sync.WaitGroup
+	for i, name := range tenants {
+		results[i].Tenant = name
+
+		slots <- struct{}{}
+
+		mu.Lock()
+		skip := r.FailFast && failed
+		mu.Unlock()
+
+		if skip {
+			results[i].Err = ErrSkipped
+			<-slots
+			continue
+		}
+
+		wg.Add(1)
+		go func(result *Result) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+
+			start := time.Now()
+			result.Err = f(result)
+			result.Duration = time.Since(start)
+
+			if result.Err != nil {
+				mu.Lock()
+				failed = true
+				mu.Unlock()
+			}
+		}(&results[i])
+	}
+	wg.Wait()
+
+	return results
+}
+
+// Summary counts the tenants which succeeded, failed and were skipped
+func Summary(results []Result) (succeeded, failed, skipped int) {
+	for _, result := range results {
+		switch {
+		case result.Ok():
+			succeeded++
+		case result.Skipped():
+			skipped++
+		default:
+			failed++
+		}
+	}
+
+	return succeeded, failed, skipped
+}
@@ -0,0 +1,76 @@
+package tenant
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errTenantFailed = errors.New("tenant failed")
+
+func TestRunner(t *testing.T) {
+	tenants := []string{"a", "b", "c", "d", "e"}
+
+	var mu sync.Mutex
+	running, most := 0, 0
+	results := Runner{Parallelism: 2}.Run(tenants, func(result *Result) error {
+		mu.Lock()
+		running++
+		if running > most {
+			most = running
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+
+		result.ToVersion = "002"
+		if result.Tenant == "b" {
+			return errTenantFailed
+		}
+
+		return nil
+	})
+
+	if most > 2 {
+		t.Errorf("got %d tenants at once, want at most 2", most)
+	}
+
+	for i, result := range results {
+		if result.Tenant != tenants[i] || result.ToVersion != "002" {
+			t.Errorf("got %v, want tenant %s at version 002", result, tenants[i])
+		}
+	}
+
+	// Without fail fast every tenant is attempted
+	succeeded, failed, skipped := Summary(results)
+	if succeeded != 4 || failed != 1 || skipped != 0 {
+		t.Errorf("got %d/%d/%d, want 4/1/0", succeeded, failed, skipped)
+	}
+}
+
+func TestRunnerFailFast(t *testing.T) {
+	results := Runner{Parallelism: 1, FailFast: true}.Run([]string{"a", "b", "c", "d"}, func(result *Result) error {
+		if result.Tenant == "b" {
+			return errTenantFailed
+		}
+
+		return nil
+	})
+
+	want := []error{nil, errTenantFailed, ErrSkipped, ErrSkipped}
+	for i, result := range results {
+		if result.Err != want[i] {
+			t.Errorf("got %v for %s, want %v", result.Err, result.Tenant, want[i])
+		}
+	}
+
+	succeeded, failed, skipped := Summary(results)
+	if succeeded != 1 || failed != 1 || skipped != 2 {
+		t.Errorf("got %d/%d/%d, want 1/1/2", succeeded, failed, skipped)
+	}
+}
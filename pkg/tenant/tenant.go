@@ -0,0 +1,101 @@
+// Package tenant runs the same migrations against many tenants, where each
+// tenant is either a schema in one database or a database of its own, and
+// keeps its own migration table.
+package tenant
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+type Kind string
+
+const (
+	KindSchema   Kind = "schema"
+	KindDatabase Kind = "database"
+)
+
+var ErrUnknownKind = errors.New("Tenant kind must be either 'schema' or 'database'")
+
+func ParseKind(name string) (Kind, error) {
+	switch Kind(strings.ToLower(name)) {
+	case KindSchema:
+		return KindSchema, nil
+	case KindDatabase:
+		return KindDatabase, nil
+	default:
+		return "", ErrUnknownKind
+	}
+}
+
+// Queryer is implemented by *sql.DB and friends
+type Queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// Parse reads one tenant per line, skipping blank lines and # comments
+func Parse(r io.Reader) ([]string, error) {
+	tenants := make([]string, 0)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		tenants = append(tenants, line)
+	}
+
+	return tenants, scanner.Err()
+}
+
+// FromFile reads a tenant list written in the format Parse expects
+func FromFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Parse(f)
+}
+
+// FromQuery lists the tenants returned by a query, which must select a single
+// text column
+func FromQuery(db Queryer, query string, args ...interface{}) ([]string, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tenants := make([]string, 0)
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, name)
+	}
+
+	return tenants, rows.Err()
+}
+
+// Like lists every schema or database whose name matches a LIKE pattern, e.g.
+// tenant_%
+func Like(db Queryer, kind Kind, pattern string) ([]string, error) {
+	switch kind {
+	case KindSchema:
+		return FromQuery(db, "SELECT nspname FROM pg_namespace WHERE nspname LIKE $1 ORDER BY nspname", pattern)
+	case KindDatabase:
+		return FromQuery(db, "SELECT datname FROM pg_database WHERE datname LIKE $1 AND NOT datistemplate ORDER BY datname", pattern)
+	default:
+		return nil, ErrUnknownKind
+	}
+}
@@ -0,0 +1,38 @@
+package tenant
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	got, err := Parse(strings.NewReader("tenant_1\n\n# retired\n  tenant_2  \n#tenant_3\ntenant_4"))
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	want := []string{"tenant_1", "tenant_2", "tenant_4"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestParseKind(t *testing.T) {
+	tests := []struct {
+		Name string
+		Want Kind
+		Err  error
+	}{
+		{"schema", KindSchema, nil},
+		{"Database", KindDatabase, nil},
+		{"table", "", ErrUnknownKind},
+	}
+
+	for _, test := range tests {
+		got, err := ParseKind(test.Name)
+		if got != test.Want || err != test.Err {
+			t.Errorf("got %v %v, want %v %v", got, err, test.Want, test.Err)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+// Package tracing adapts an OpenTelemetry tracer to migrate.Tracer, so that
+// migration runs and their steps show up as spans alongside the rest of a
+// service's traces
+package tracing
+
+import (
+	"context"
+	"sort"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/crgwilson/pgm/pkg/migrate"
+)
+
+// Tracer starts OpenTelemetry spans for a migration manager, see
+// migrate.MigrationManager.TraceSpans
+type Tracer struct {
+	Tracer trace.Tracer
+}
+
+func NewTracer(tracer trace.Tracer) *Tracer {
+	return &Tracer{Tracer: tracer}
+}
+
+func (t *Tracer) Start(ctx context.Context, name string, attributes map[string]string) (context.Context, migrate.Span) {
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	kvs := make([]attribute.KeyValue, 0, len(keys))
+	for _, key := range keys {
+		kvs = append(kvs, attribute.String(key, attributes[key]))
+	}
+
+	ctx, span := t.Tracer.Start(ctx, name, trace.WithAttributes(kvs...))
+	return ctx, Span{Span: span}
+}
+
+// Span marks itself as failed when it records an error
+type Span struct {
+	Span trace.Span
+}
+
+func (s Span) RecordError(err error) {
+	s.Span.RecordError(err)
+	s.Span.SetStatus(codes.Error, err.Error())
+}
+
+func (s Span) End() {
+	s.Span.End()
+}
@@ -0,0 +1,72 @@
+package tracing
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/crgwilson/pgm/pkg/migrate"
+)
+
+func TestTracer(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	migrator := migrate.NewComponentManager(migrate.NewMemoryMigrationStore(migrate.FailOnVersion("002")), "billing", nil)
+	err := migrator.InitDb()
+	if err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+
+	for _, version := range []string{"001", "002"} {
+		err := migrator.RegisterMigrationPath(migrate.MigrationPath{Version: version, Action: "up", Raw: []byte("SELECT 1;")})
+		if err != nil {
+			t.Fatalf("got %v, want no error", err)
+		}
+	}
+
+	migrator.AddHooks(migrator.TraceSpans(context.Background(), NewTracer(provider.Tracer("pgm"))))
+
+	err = migrator.Up("002")
+	if err != migrate.ErrInjectedMigrationFailure {
+		t.Fatalf("got %v, want %v", err, migrate.ErrInjectedMigrationFailure)
+	}
+
+	spans := exporter.GetSpans()
+	names := make([]string, 0, len(spans))
+	for _, span := range spans {
+		names = append(names, span.Name)
+	}
+
+	want := []string{"pgm up 001", "pgm up 002", "pgm up"}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("got %v, want %v", names, want)
+	}
+
+	run := spans[2]
+	for _, step := range spans[:2] {
+		if step.Parent.SpanID() != run.SpanContext.SpanID() {
+			t.Errorf("got %s under %v, want it under the run", step.Name, step.Parent.SpanID())
+		}
+	}
+
+	failed := spans[1]
+	if failed.Status.Code != codes.Error {
+		t.Errorf("got %v, want %v", failed.Status.Code, codes.Error)
+	}
+
+	wantAttributes := []attribute.KeyValue{
+		attribute.String(migrate.AttributeComponent, "billing"),
+		attribute.String(migrate.AttributeDirection, "up"),
+		attribute.String(migrate.AttributeRepeatable, "false"),
+		attribute.String(migrate.AttributeVersion, "002"),
+	}
+	if !reflect.DeepEqual(failed.Attributes, wantAttributes) {
+		t.Errorf("got %v, want %v", failed.Attributes, wantAttributes)
+	}
+}